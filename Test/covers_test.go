@@ -0,0 +1,136 @@
+package core_test
+
+import (
+	"math"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/db"
+	"testing"
+)
+
+func TestChromaRoundTrip(t *testing.T) {
+	opts := core.DefaultCoverOptions()
+	features, err := opts.Chroma([][]float64{fixtures.Song(100, 10, fixtures.SampleRate)}, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("Chroma failed: %v", err)
+	}
+	if len(features.Blocks) < 19 || len(features.Blocks) > 21 {
+		t.Errorf("%d blocks for 10s, want about 20", len(features.Blocks))
+	}
+
+	decoded, err := core.DecodeChroma(core.EncodeChroma(features))
+	if err != nil {
+		t.Fatalf("DecodeChroma failed: %v", err)
+	}
+	if decoded.Key != features.Key || len(decoded.Blocks) != len(features.Blocks) || math.Abs(decoded.BlockSeconds-features.BlockSeconds) > 0.001 {
+		t.Fatalf("decoded key %d, %d blocks of %gs, want %d, %d of %gs", decoded.Key, len(decoded.Blocks), decoded.BlockSeconds,
+			features.Key, len(features.Blocks), features.BlockSeconds)
+	}
+	for i := range features.Blocks {
+		for class := range features.Blocks[i] {
+			if math.Abs(decoded.Blocks[i][class]-features.Blocks[i][class]) > 0.5/255+1e-9 {
+				t.Fatalf("block %d class %d decoded as %g, want %g", i, class, decoded.Blocks[i][class], features.Blocks[i][class])
+			}
+		}
+	}
+
+	if _, err := core.DecodeChroma(core.EncodeChroma(features)[:30]); err == nil {
+		t.Error("decoded truncated chroma features")
+	}
+}
+
+func TestFindCovers(t *testing.T) {
+	catalogue := fixtures.NewCatalogue()
+	memory, indexer := catalogue.DB, catalogue.Indexer
+	indexer.Covers = core.NewCoverIndex(memory)
+	if err := catalogue.AddSongs(100, 6, 30); err != nil {
+		t.Fatalf("AddSongs failed: %v", err)
+	}
+
+	// song 3 played 10% slower, three semitones up, from 5s to 20s of the cover,
+	// which is 4.5s to 18s of the song
+	cover := fixtures.Cover(102, 25, fixtures.SampleRate, 0.9, 3)
+	clip := cover[fixtures.Seconds(5):fixtures.Seconds(20)]
+
+	fingerprinted, _, err := core.NewMatcher(memory).FindMatches(clip, 15, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("FindMatches failed: %v", err)
+	}
+	if len(fingerprinted) > 0 && fingerprinted[0].SongId == 3 && fingerprinted[0].Score >= 10 {
+		t.Logf("the landmark matcher found the cover too, with score %.0f", fingerprinted[0].Score)
+	}
+
+	results, err := indexer.Covers.FindSimilar([][]float64{clip}, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("FindSimilar failed: %v", err)
+	}
+	for _, r := range results {
+		t.Logf("song %d %q: score %.2f, %+d semitones, %.1fs-%.1fs", r.SongID, r.Title, r.Score, r.Transposition, r.Start, r.End)
+	}
+
+	if len(results) == 0 || results[0].SongID != 3 || results[0].Title != "song 3" {
+		t.Fatalf("got %+v, want song 3 first", results)
+	}
+	best := results[0]
+	if best.Transposition != 3 {
+		t.Errorf("cover transposed by %d semitones, want 3", best.Transposition)
+	}
+	if math.Abs(best.Start-4.5) > 1.5 || math.Abs(best.End-18) > 1.5 {
+		t.Errorf("cover placed at %.1fs-%.1fs of the song, want 4.5s-18s", best.Start, best.End)
+	}
+	if len(results) > 1 && results[1].Score > best.Score-0.2 {
+		t.Errorf("song %d scores %.2f, too close to the cover's %.2f", results[1].SongID, results[1].Score, best.Score)
+	}
+
+	// only the song closest by profile is aligned, whatever it scores
+	narrow := core.NewCoverIndex(memory)
+	narrow.Options.Candidates, narrow.Options.MinScore = 1, -1
+	results, err = narrow.FindSimilar([][]float64{clip}, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("FindSimilar failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("with 1 candidate got %d results, want 1", len(results))
+	}
+
+	if _, err := core.DeleteSong(memory, 3); err != nil {
+		t.Fatalf("DeleteSong failed: %v", err)
+	}
+	results, err = indexer.Covers.FindSimilar([][]float64{clip}, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("FindSimilar failed: %v", err)
+	}
+	for _, r := range results {
+		if r.SongID == 3 {
+			t.Errorf("deleted song 3 still found with score %.2f", r.Score)
+		}
+	}
+}
+
+func TestFindSimilarRejectsNonPositiveLimits(t *testing.T) {
+	clip := fixtures.Song(100, 5, fixtures.SampleRate)
+	for _, opts := range []struct{ candidates, maxResults int }{{0, 10}, {-1, 10}, {50, 0}, {50, -1}} {
+		covers := core.NewCoverIndex(db.NewMemoryClient())
+		covers.Options.Candidates, covers.Options.MaxResults = opts.candidates, opts.maxResults
+		if _, err := covers.FindSimilar([][]float64{clip}, fixtures.SampleRate); err == nil {
+			t.Errorf("FindSimilar with %d candidates and %d results didn't fail", opts.candidates, opts.maxResults)
+		}
+	}
+}
+
+func TestGetChromaLeavesOutMissingSongs(t *testing.T) {
+	memory := db.NewMemoryClient()
+	for _, songID := range []uint32{1, 2} {
+		if err := memory.StoreChroma(songID, []byte{byte(songID)}); err != nil {
+			t.Fatalf("StoreChroma failed: %v", err)
+		}
+	}
+
+	chroma, err := memory.GetChroma([]uint32{2, 3})
+	if err != nil {
+		t.Fatalf("GetChroma failed: %v", err)
+	}
+	if len(chroma) != 1 || len(chroma[2]) != 1 || chroma[2][0] != 2 {
+		t.Errorf("got %v, want only song 2", chroma)
+	}
+}
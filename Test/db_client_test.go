@@ -2,6 +2,7 @@ package core_test
 
 import (
     "fmt"
+    "shazoom/Test/fixtures"
     "shazoom/core"
     "shazoom/db"
     "shazoom/utils"
@@ -145,4 +146,46 @@ func TestNewPostgresClient(t *testing.T) {
     }
     t.Logf("Successfully retrieved %d unique hash matches from the database.", len(retrievedCouples))
 
-}
\ No newline at end of file
+}
+// BenchmarkPostgresStoreFingerprints times storing a minute of fingerprints, the part of
+// ingestion the store pays for. It needs the database of ../.env and skips without one.
+func BenchmarkPostgresStoreFingerprints(b *testing.B) {
+    if err := godotenv.Load("../.env"); err != nil {
+        b.Skipf("no database to benchmark against: %v", err)
+    }
+
+    client, err := db.NewDBClient()
+    if err != nil {
+        b.Fatalf("Failed to connect to the database: %v", err)
+    }
+    defer client.Close()
+
+    songID, err := client.RegisterSong("Benchmark Song", "Benchmark Artist", "")
+    if err != nil {
+        b.Fatalf("RegisterSong failed: %v", err)
+    }
+    defer client.DeleteSongByID(songID)
+
+    fingerprints, err := core.GenerateFingerprintsFromSamples(fixtures.Song(1, 60, fixtures.SampleRate), fixtures.SampleRate, songID)
+    if err != nil {
+        b.Fatalf("GenerateFingerprintsFromSamples failed: %v", err)
+    }
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        b.StopTimer()
+        if _, err := client.DeleteSongFingerprints(songID); err != nil {
+            b.Fatalf("DeleteSongFingerprints failed: %v", err)
+        }
+        b.StartTimer()
+
+        if err := client.StoreFingerprints(fingerprints); err != nil {
+            b.Fatalf("StoreFingerprints failed: %v", err)
+        }
+    }
+    b.StopTimer()
+
+    if _, err := client.DeleteSongFingerprints(songID); err != nil {
+        b.Fatalf("DeleteSongFingerprints failed: %v", err)
+    }
+}
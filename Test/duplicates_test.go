@@ -0,0 +1,93 @@
+package core_test
+
+import (
+	"math"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/db"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	store := fixtures.NewCatalogue()
+	memory, indexer := store.DB, store.Indexer
+
+	master := fixtures.Song(90, 40, fixtures.SampleRate)
+	other := fixtures.Song(91, 40, fixtures.SampleRate)
+	remaster := make([]float64, len(master))
+	for i, sample := range master {
+		remaster[i] = 0.7 * sample
+	}
+
+	catalogue := []struct {
+		song    db.Song
+		samples []float64
+	}{
+		{db.Song{Title: "Original"}, master},
+		// the same master uploaded again behind three seconds of crowd noise
+		{db.Song{Title: "Original (Live Upload)"}, fixtures.Concat(fixtures.Noise(1, 3, 0.05, fixtures.SampleRate), fixtures.WithNoise(master, 25, 2))},
+		// a radio edit missing the middle ten seconds
+		{db.Song{Title: "Original (Radio Edit)", Album: "Hits 2026"}, fixtures.Concat(master[:fixtures.Seconds(15)], master[fixtures.Seconds(25):])},
+		{db.Song{Title: "Original (Remastered)"}, fixtures.WithNoise(remaster, 30, 3)},
+		{db.Song{Title: "Unrelated"}, other},
+		// borrows eight seconds of Unrelated, which isn't enough to be a duplicate
+		{db.Song{Title: "Sampler"}, fixtures.Concat(other[fixtures.Seconds(10):fixtures.Seconds(18)], fixtures.Song(92, 30, fixtures.SampleRate))},
+	}
+	for _, entry := range catalogue {
+		if _, err := indexer.IndexSamples(entry.samples, fixtures.SampleRate, entry.song); err != nil {
+			t.Fatalf("IndexSamples failed: %v", err)
+		}
+	}
+
+	matcher := core.NewMatcher(memory)
+	clusters, err := matcher.FindDuplicates(core.DefaultDuplicateOptions())
+	if err != nil {
+		t.Fatalf("FindDuplicates failed: %v", err)
+	}
+	for _, cluster := range clusters {
+		for _, pair := range cluster.Pairs {
+			t.Logf("%d ~ %d: score %.0f, offset %dms, overlap %.2f / %.2f", pair.SongA, pair.SongB, pair.Score, pair.OffsetMs, pair.OverlapA, pair.OverlapB)
+		}
+	}
+
+	if len(clusters) != 1 || len(clusters[0].Songs) != 4 {
+		t.Fatalf("got %d clusters, want songs 1 to 4 in one", len(clusters))
+	}
+	for i, song := range clusters[0].Songs {
+		if song.ID != uint32(i+1) {
+			t.Errorf("song %d of the cluster is %d %q, want %d", i, song.ID, song.Title, i+1)
+		}
+	}
+
+	pairs := make(map[[2]uint32]core.DuplicatePair)
+	for _, pair := range clusters[0].Pairs {
+		pairs[[2]uint32{pair.SongA, pair.SongB}] = pair
+	}
+	upload, edit := pairs[[2]uint32{1, 2}], pairs[[2]uint32{1, 3}]
+	if math.Abs(float64(upload.OffsetMs)-3000) > 100 || upload.OverlapA < 0.9 {
+		t.Errorf("the upload aligns at %dms with overlap %.2f, want 3000ms and all of the original", upload.OffsetMs, upload.OverlapA)
+	}
+	if edit.OverlapB < 0.9 || edit.OverlapA > 0.85 {
+		t.Errorf("the radio edit overlaps %.2f of the original and %.2f of itself, want about 0.75 and 1", edit.OverlapA, edit.OverlapB)
+	}
+
+	stats, err := core.MergeDuplicates(memory, 1, []uint32{2, 3, 4})
+	if err != nil {
+		t.Fatalf("MergeDuplicates failed: %v", err)
+	}
+	if stats.Songs != 3 || stats.Fingerprints == 0 {
+		t.Errorf("merge removed %d songs and %d fingerprints", stats.Songs, stats.Fingerprints)
+	}
+
+	kept, _, _ := memory.GetSongByID(1)
+	if kept.Album != "Hits 2026" || kept.Title != "Original" {
+		t.Errorf("the kept song is %q on %q, want the edit's album merged in", kept.Title, kept.Album)
+	}
+	if store, _ := memory.Stats(0); store.Songs != 3 || store.OrphanedFingerprints != 0 {
+		t.Errorf("%d songs and %d orphaned fingerprints left after the merge", store.Songs, store.OrphanedFingerprints)
+	}
+
+	if clusters, err := matcher.FindDuplicates(core.DefaultDuplicateOptions()); err != nil || len(clusters) != 0 {
+		t.Errorf("%d clusters left after the merge: %v", len(clusters), err)
+	}
+}
@@ -0,0 +1,58 @@
+package core_test
+
+import (
+	"fmt"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/eval"
+	"strings"
+	"testing"
+)
+
+func synthCatalogue(firstSeed int64, count int) []eval.Track {
+	tracks := make([]eval.Track, count)
+	for i := range tracks {
+		seed := firstSeed + int64(i)
+		tracks[i] = eval.Track{
+			Title:      fmt.Sprintf("synth %d", seed),
+			Artist:     "shazoom",
+			Samples:    fixtures.Song(seed, scoringSongLength, scoringSampleRate),
+			SampleRate: scoringSampleRate,
+		}
+	}
+	return tracks
+}
+
+func TestEvaluationHarness(t *testing.T) {
+	harness, err := eval.NewHarness(synthCatalogue(1, scoringSongs), core.DefaultConfig())
+	if err != nil {
+		t.Fatalf("failed to index the catalogue: %v", err)
+	}
+
+	cfg := eval.DefaultConfig()
+	cfg.QueriesPerTrack = 2
+	cfg.Distractors = synthCatalogue(100, 4)
+
+	report, err := harness.Run(cfg)
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+	var table strings.Builder
+	report.WriteTo(&table)
+	t.Log("\n" + table.String())
+
+	if len(report.Results) != len(cfg.Conditions) {
+		t.Fatalf("got %d results for %d conditions", len(report.Results), len(cfg.Conditions))
+	}
+
+	clean := report.Results[0]
+	if clean.Queries != scoringSongs*cfg.QueriesPerTrack || clean.DistractorQueries != 4*cfg.QueriesPerTrack {
+		t.Fatalf("unexpected query counts: %d catalogue, %d distractor", clean.Queries, clean.DistractorQueries)
+	}
+	if clean.Top1Accuracy < 0.9 {
+		t.Errorf("clean top-1 accuracy %.2f, want at least 0.9", clean.Top1Accuracy)
+	}
+	if clean.FalsePositiveRate > 0.1 {
+		t.Errorf("clean false positive rate %.2f, want at most 0.1", clean.FalsePositiveRate)
+	}
+}
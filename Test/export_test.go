@@ -0,0 +1,203 @@
+package core_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"shazoom/archive"
+	"shazoom/core"
+	"shazoom/db"
+	"shazoom/models"
+	"testing"
+)
+
+type walkedFingerprint struct {
+	address int64
+	couple  models.Couple
+}
+
+func walkAll(t *testing.T, dbClient db.DBClient) []walkedFingerprint {
+	t.Helper()
+	var all []walkedFingerprint
+	err := dbClient.WalkFingerprints(func(address int64, couple models.Couple) error {
+		all = append(all, walkedFingerprint{address, couple})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkFingerprints failed: %v", err)
+	}
+	return all
+}
+
+// archiveSource has enough fingerprints for several blocks, addresses shared between
+// songs and within one, and an orphan.
+func archiveSource(t *testing.T) *db.MemoryClient {
+	t.Helper()
+	source := db.NewMemoryClient()
+	for i := 0; i < 3; i++ {
+		song := db.Song{Title: fmt.Sprintf("song %d", i+1), Artist: "shazoom", Album: "archive", ReleaseYear: 2000 + i, ContentHash: fmt.Sprint(i)}
+		id, err := source.RegisterOrGetSong(song)
+		if err != nil {
+			t.Fatalf("RegisterOrGetSong failed: %v", err)
+		}
+
+		fingerprints := map[int64]models.Couple{}
+		for f := 0; f < 15000; f++ {
+			address, _ := core.PackAddress(f%512, (f*7)%512, int64(f%3000))
+			fingerprints[address] = models.Couple{AnchorTime: uint32(f * 11), SongId: id}
+		}
+		source.StoreFingerprints(fingerprints)
+		// the same address again at other times
+		for _, anchorTime := range []uint32{5, 50000} {
+			source.StoreFingerprints(map[int64]models.Couple{1 << 40: {AnchorTime: anchorTime, SongId: id}})
+		}
+		source.MarkSongFingerprinted(id)
+	}
+	source.StoreFingerprints(map[int64]models.Couple{99: {AnchorTime: 1, SongId: 77}})
+	return source
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	source := archiveSource(t)
+
+	var file bytes.Buffer
+	exported, err := archive.Export(&file, source, core.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if exported.Songs != 3 || exported.Fingerprints != 3*15002 || exported.Orphaned != 1 {
+		t.Fatalf("exported %+v", exported)
+	}
+	t.Logf("%d fingerprints in %d bytes", exported.Fingerprints, file.Len())
+
+	target := db.NewMemoryClient()
+	imported, err := archive.Import(bytes.NewReader(file.Bytes()), target, archive.DefaultImportOptions())
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if imported.Songs != 3 || imported.Fingerprints != exported.Fingerprints || imported.Header.Config != core.DefaultConfig() {
+		t.Fatalf("imported %+v", imported)
+	}
+
+	want := walkAll(t, source)
+	if got := walkAll(t, target); !reflect.DeepEqual(got, want[:len(want)-1]) {
+		t.Fatalf("imported %d fingerprints differ from the %d exported", len(got), len(want)-1)
+	}
+
+	song, found, err := target.GetSongByID(2)
+	if err != nil || !found || !song.Fingerprinted || song.Album != "archive" || song.ReleaseYear != 2001 {
+		t.Fatalf("song 2 imported as %+v (found %v, err %v)", song, found, err)
+	}
+
+	again, err := archive.Import(bytes.NewReader(file.Bytes()), target, archive.DefaultImportOptions())
+	if err != nil {
+		t.Fatalf("second Import failed: %v", err)
+	}
+	if again.SkippedSongs != 3 || again.Fingerprints != 0 {
+		t.Fatalf("importing twice stored %+v", again)
+	}
+}
+
+func TestImportRefusesOtherConfig(t *testing.T) {
+	built := core.DefaultConfig()
+	built.Address = core.AddressV2
+
+	var file bytes.Buffer
+	if _, err := archive.Export(&file, archiveSource(t), built); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	target := db.NewMemoryClient()
+	_, err := archive.Import(bytes.NewReader(file.Bytes()), target, archive.DefaultImportOptions())
+	if !errors.Is(err, core.ErrIncompatibleFingerprints) {
+		t.Fatalf("Import returned %v, want ErrIncompatibleFingerprints", err)
+	}
+	if songs, _ := target.TotalSongs(); songs != 0 || len(walkAll(t, target)) != 0 {
+		t.Fatalf("the refused import left %d songs behind", songs)
+	}
+
+	forced := archive.DefaultImportOptions()
+	forced.Force = true
+	imported, err := archive.Import(bytes.NewReader(file.Bytes()), target, forced)
+	if err != nil {
+		t.Fatalf("forced Import failed: %v", err)
+	}
+	if imported.Songs != 3 || imported.Header.Config != built {
+		t.Fatalf("forced import %+v", imported)
+	}
+}
+
+// TestExportToStdoutStaysClean follows what shazoom export does when writing to
+// stdout: open the store, then stream the archive. Nothing but the archive may reach
+// stdout, whether the store connects or not.
+func TestExportToStdoutStaysClean(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	captured := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		captured <- data
+	}()
+
+	if dbClient, err := db.NewDBClient(); err == nil {
+		dbClient.Close()
+	}
+	_, exportErr := archive.Export(os.Stdout, archiveSource(t), core.DefaultConfig())
+
+	os.Stdout = stdout
+	w.Close()
+	data := <-captured
+
+	if exportErr != nil {
+		t.Fatalf("Export failed: %v", exportErr)
+	}
+	if _, err := archive.Import(bytes.NewReader(data), db.NewMemoryClient(), archive.DefaultImportOptions()); err != nil {
+		t.Fatalf("stdout doesn't hold just the archive: %v (starts %q)", err, data[:min(len(data), 80)])
+	}
+}
+
+func TestArchiveRejectsDamage(t *testing.T) {
+	var file bytes.Buffer
+	if _, err := archive.Export(&file, archiveSource(t), core.DefaultConfig()); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	data := file.Bytes()
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)/2] ^= 0x10
+
+	cases := map[string][]byte{
+		"flipped bit":       flipped,
+		"truncated":         data[:len(data)-10],
+		"end block cut off": data[:bytes.LastIndexByte(data, 'E')],
+		"not an archive":    []byte("RIFF....WAVEfmt "),
+	}
+	for name, damaged := range cases {
+		t.Run(name, func(t *testing.T) {
+			target := db.NewMemoryClient()
+			_, err := archive.Import(bytes.NewReader(damaged), target, archive.DefaultImportOptions())
+			if !errors.Is(err, archive.ErrCorrupt) {
+				t.Fatalf("Import returned %v, want ErrCorrupt", err)
+			}
+
+			// nothing is marked as done, so the import can be run again
+			if name != "not an archive" {
+				if _, err := archive.Import(bytes.NewReader(data), target, archive.DefaultImportOptions()); err != nil {
+					t.Fatalf("Import after the damaged one failed: %v", err)
+				}
+				if got := len(walkAll(t, target)); got != 3*15002 {
+					t.Fatalf("%d fingerprints after importing again, want %d", got, 3*15002)
+				}
+			}
+		})
+	}
+}
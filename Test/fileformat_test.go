@@ -0,0 +1,98 @@
+package core_test
+
+import (
+	"math"
+	"shazoom/Test/fixtures"
+	"shazoom/fileformat"
+	"testing"
+)
+
+func TestWavRoundTrip(t *testing.T) {
+	set, err := fixtures.NewSet(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create fixture set: %v", err)
+	}
+
+	chord := fixtures.Chord([]float64{261.63, 329.63, 392.0}, 2, 0.8, fixtures.SampleRate)
+	fixture, err := set.Add("c-major", chord, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+
+	wavInfo, err := fileformat.ReadWavInfo(set.Path(fixture))
+	if err != nil {
+		t.Fatalf("ReadWavInfo failed: %v", err)
+	}
+
+	if wavInfo.Channels != 1 || wavInfo.SampleRate != fixtures.SampleRate {
+		t.Fatalf("got %d channels at %d Hz, want mono at %d Hz", wavInfo.Channels, wavInfo.SampleRate, fixtures.SampleRate)
+	}
+	if math.Abs(wavInfo.Duration-2) > 1e-9 {
+		t.Fatalf("got duration %.6fs, want 2s", wavInfo.Duration)
+	}
+	if len(wavInfo.LeftChannelSamples) != len(chord) {
+		t.Fatalf("read %d samples, wrote %d", len(wavInfo.LeftChannelSamples), len(chord))
+	}
+
+	const quantisation = 2.0 / 32768
+	for i, s := range wavInfo.LeftChannelSamples {
+		if math.Abs(s-chord[i]) > quantisation {
+			t.Fatalf("sample %d: read %f, wrote %f", i, s, chord[i])
+		}
+	}
+
+	samples, err := fileformat.WavBytesToSample(wavInfo.Data)
+	if err != nil {
+		t.Fatalf("WavBytesToSample failed: %v", err)
+	}
+	for i := range samples {
+		if samples[i] != wavInfo.LeftChannelSamples[i] {
+			t.Fatalf("WavBytesToSample and ReadWavInfo disagree at sample %d", i)
+		}
+	}
+}
+
+func TestFixtureManifestKeepsGroundTruth(t *testing.T) {
+	dir := t.TempDir()
+	set, err := fixtures.NewSet(dir)
+	if err != nil {
+		t.Fatalf("failed to create fixture set: %v", err)
+	}
+
+	song := fixtures.Song(7, 20, fixtures.SampleRate)
+	if _, err := set.Add("song", song, fixtures.SampleRate); err != nil {
+		t.Fatalf("failed to write song: %v", err)
+	}
+	if _, err := set.AddClip("clip", "song", song, fixtures.SampleRate, 12.5, 5); err != nil {
+		t.Fatalf("failed to write clip: %v", err)
+	}
+	if _, err := set.AddClip("overrun", "song", song, fixtures.SampleRate, 18, 5); err == nil {
+		t.Fatal("expected a clip past the end of its source to be rejected")
+	}
+	if err := set.WriteManifest(); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	loaded, err := fixtures.LoadSet(dir)
+	if err != nil {
+		t.Fatalf("LoadSet failed: %v", err)
+	}
+	clip, ok := loaded.Get("clip")
+	if !ok {
+		t.Fatal("clip missing from the reloaded manifest")
+	}
+	if clip.Source != "song" || clip.Offset != 12.5 || clip.Duration != 5 {
+		t.Fatalf("unexpected ground truth %+v", clip)
+	}
+
+	wavInfo, err := fileformat.ReadWavInfo(loaded.Path(clip))
+	if err != nil {
+		t.Fatalf("ReadWavInfo failed: %v", err)
+	}
+	start := int(12.5 * fixtures.SampleRate)
+	for i := 0; i < 100; i++ {
+		if math.Abs(wavInfo.LeftChannelSamples[i]-song[start+i]) > 2.0/32768 {
+			t.Fatalf("clip sample %d does not line up with the source at %.1fs", i, clip.Offset)
+		}
+	}
+}
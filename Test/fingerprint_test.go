@@ -0,0 +1,336 @@
+package core_test
+
+import (
+	"errors"
+	"math"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/db"
+	"shazoom/models"
+	"testing"
+)
+
+// v1Tag is the version field of an AddressV1 address, which keeps it apart from the
+// legacy Hz/10 ones in the same low 32 bits.
+const v1Tag = int64(core.AddressV1) << 58
+
+func TestAddressBitPacking(t *testing.T) {
+	cases := []struct {
+		anchorBin, targetBin int
+		deltaMs              int64
+		want                 int64
+	}{
+		{0, 0, 0, v1Tag},
+		{0, 0, 1, v1Tag | 1},
+		{0, 1, 0, v1Tag | 1<<14},
+		{1, 0, 0, v1Tag | 1<<23},
+		{511, 511, 16383, v1Tag | 1<<32 - 1},
+		{300, 17, 1234, v1Tag | 300<<23 | 17<<14 | 1234},
+	}
+
+	for _, tc := range cases {
+		address, err := core.PackAddress(tc.anchorBin, tc.targetBin, tc.deltaMs)
+		if err != nil {
+			t.Fatalf("PackAddress(%d, %d, %d) failed: %v", tc.anchorBin, tc.targetBin, tc.deltaMs, err)
+		}
+		if address != tc.want {
+			t.Errorf("PackAddress(%d, %d, %d) = %#x, want %#x", tc.anchorBin, tc.targetBin, tc.deltaMs, address, tc.want)
+		}
+
+		anchorBin, targetBin, deltaMs := core.UnpackAddress(address)
+		if anchorBin != tc.anchorBin || targetBin != tc.targetBin || deltaMs != tc.deltaMs {
+			t.Errorf("UnpackAddress(%#x) = (%d, %d, %d), want (%d, %d, %d)",
+				address, anchorBin, targetBin, deltaMs, tc.anchorBin, tc.targetBin, tc.deltaMs)
+		}
+	}
+}
+
+func TestAddressRangeValidation(t *testing.T) {
+	invalid := []struct {
+		anchorBin, targetBin int
+		deltaMs              int64
+	}{
+		{512, 0, 0},
+		{-1, 0, 0},
+		{0, 512, 0},
+		{0, 0, 16384},
+		{0, 0, -1},
+	}
+
+	for _, tc := range invalid {
+		if _, err := core.PackAddress(tc.anchorBin, tc.targetBin, tc.deltaMs); err == nil {
+			t.Errorf("PackAddress(%d, %d, %d) accepted an out of range value", tc.anchorBin, tc.targetBin, tc.deltaMs)
+		}
+	}
+}
+
+func TestHighFrequenciesDoNotWrap(t *testing.T) {
+	// 5300 Hz used to land in bucket 530, which the 9 bit mask wrapped to 18 (180 Hz)
+	const freq = 5300.0
+	signal := fixtures.Chord([]float64{freq, 700}, 3, 0.8, fixtures.SampleRate)
+
+	spectrogram, err := core.Spectrogram(signal, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("Spectrogram failed: %v", err)
+	}
+
+	fingerprints := core.Fingerprint(core.ExtractPeaks(spectrogram, 3, fixtures.SampleRate), 1)
+	if len(fingerprints) == 0 {
+		t.Fatal("no fingerprints generated")
+	}
+
+	resolution := core.BinResolution(fixtures.SampleRate)
+	found := false
+	for address := range fingerprints {
+		anchorBin, targetBin, _ := core.UnpackAddress(address)
+		for _, bin := range []int{anchorBin, targetBin} {
+			hz := float64(bin) * resolution
+			if math.Abs(hz-freq) <= resolution {
+				found = true
+			} else if math.Abs(hz-700) > resolution {
+				t.Fatalf("address %#x holds bin %d (%.0f Hz), which is neither tone", address, bin, hz)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("no address carries the %.0f Hz tone", freq)
+	}
+}
+
+func TestEffectiveSampleRate(t *testing.T) {
+	cases := map[int]float64{44100: 11025, 22050: 5512.5, 11025: 2756.25, 8000: 2000}
+	for sampleRate, want := range cases {
+		if got := core.EffectiveSampleRate(sampleRate); got != want {
+			t.Errorf("EffectiveSampleRate(%d) = %f, want %f", sampleRate, got, want)
+		}
+	}
+
+	// a tone at a rate that isn't a multiple of 4 still lands on the right frequency
+	const rate = 22050
+	tone := fixtures.Tone(1200, 3, 0.5, rate)
+	spectrogram, err := core.Spectrogram(tone, rate)
+	if err != nil {
+		t.Fatalf("Spectrogram failed: %v", err)
+	}
+	for _, peak := range core.ExtractPeaks(spectrogram, 3, rate) {
+		if math.Abs(peak.Freq-1200) > core.BinResolution(rate) {
+			t.Fatalf("peak at %.1f Hz, want 1200 Hz", peak.Freq)
+		}
+		if frames := peak.Time / core.FrameDuration(rate); math.Abs(frames-math.Round(frames)) > 1e-9 {
+			t.Fatalf("peak time %.6fs is not on the frame grid", peak.Time)
+		}
+	}
+}
+
+func TestAddressV2Layout(t *testing.T) {
+	address, err := core.PackAddressV2(4095, 1000, 1<<20-1, 255)
+	if err != nil {
+		t.Fatalf("PackAddressV2 failed: %v", err)
+	}
+	if address < 0 || core.AddressVersionOf(address) != core.AddressV2 || core.IsTripletAddress(address) {
+		t.Fatalf("address %#x has the wrong sign, version or triplet flag", address)
+	}
+
+	anchorBin, targetBin, deltaMs, extra := core.UnpackAddressV2(address)
+	if anchorBin != 4095 || targetBin != 1000 || deltaMs != 1<<20-1 || extra != 255 {
+		t.Errorf("UnpackAddressV2(%#x) = (%d, %d, %d, %d)", address, anchorBin, targetBin, deltaMs, extra)
+	}
+
+	if _, err := core.PackAddressV2(4096, 0, 0, 0); err == nil {
+		t.Error("PackAddressV2 accepted bin 4096")
+	}
+	if _, err := core.PackAddressV2(0, 0, 0, 256); err == nil {
+		t.Error("PackAddressV2 accepted extra 256")
+	}
+
+	v1, _ := core.PackAddress(511, 511, 16383)
+	if core.AddressVersionOf(v1) != core.AddressV1 {
+		t.Errorf("AddressVersionOf(%#x) = %d, want 1", v1, core.AddressVersionOf(v1))
+	}
+}
+
+func TestLongWindowResolvesFinerAndNeedsAddressV2(t *testing.T) {
+	opts := core.SpectrogramOptions{WindowSize: 4096}
+	if got, want := opts.BinResolution(fixtures.SampleRate), core.BinResolution(fixtures.SampleRate)/4; got != want {
+		t.Fatalf("BinResolution = %f Hz, want %f Hz", got, want)
+	}
+
+	// 1205 Hz sits between two bins of the default window
+	tone := fixtures.Tone(1205, 3, 0.5, fixtures.SampleRate)
+	spectrogram, err := core.SpectrogramWithOptions(tone, fixtures.SampleRate, opts)
+	if err != nil {
+		t.Fatalf("SpectrogramWithOptions failed: %v", err)
+	}
+	if len(spectrogram[0]) != 2048 {
+		t.Fatalf("got %d bins, want 2048", len(spectrogram[0]))
+	}
+	loudest := 0
+	for bin, mag := range spectrogram[len(spectrogram)/2] {
+		if mag > spectrogram[len(spectrogram)/2][loudest] {
+			loudest = bin
+		}
+	}
+	if freq := opts.BinFrequencies(fixtures.SampleRate)[loudest]; math.Abs(freq-1205) > opts.BinResolution(fixtures.SampleRate) {
+		t.Errorf("loudest bin at %.1f Hz, want 1205 Hz", freq)
+	}
+
+	cfg := core.DefaultConfig()
+	cfg.Spectrogram.WindowSize = 4096
+	song := fixtures.Song(1, 10, fixtures.SampleRate)
+	if _, err := cfg.GenerateFingerprintsFromSamples(song, fixtures.SampleRate, 1); err == nil {
+		t.Error("a 4096 sample window fingerprinted into AddressV1")
+	}
+
+	cfg.Address = core.AddressV2
+	fingerprints, err := cfg.GenerateFingerprintsFromSamples(song, fixtures.SampleRate, 1)
+	if err != nil {
+		t.Fatalf("fingerprinting failed: %v", err)
+	}
+	highBins := 0
+	for address := range fingerprints {
+		if anchorBin, _, _, _ := core.UnpackAddressV2(address); anchorBin >= 512 {
+			highBins++
+		}
+	}
+	if highBins == 0 {
+		t.Error("no address uses a bin past the AddressV1 limit")
+	}
+}
+
+func TestMigratedAddressesMatchNativeV2(t *testing.T) {
+	song := fixtures.Song(1, 10, fixtures.SampleRate)
+
+	for _, pairing := range []core.Config{core.DefaultConfig(), tripletConfig()} {
+		v2 := pairing
+		v2.Address = core.AddressV2
+
+		old, err := pairing.GenerateFingerprintsFromSamples(song, fixtures.SampleRate, 1)
+		if err != nil {
+			t.Fatalf("fingerprinting failed: %v", err)
+		}
+		native, err := v2.GenerateFingerprintsFromSamples(song, fixtures.SampleRate, 1)
+		if err != nil {
+			t.Fatalf("fingerprinting failed: %v", err)
+		}
+		if len(old) != len(native) {
+			t.Fatalf("%d v1 fingerprints but %d v2 ones", len(old), len(native))
+		}
+
+		for address, couple := range old {
+			migrated, err := core.MigrateAddress(address, core.AddressV2)
+			if err != nil {
+				t.Fatalf("MigrateAddress(%#x) failed: %v", address, err)
+			}
+			if native[migrated] != couple {
+				t.Fatalf("v1 address %#x migrated to %#x, which the v2 fingerprint doesn't have", address, migrated)
+			}
+		}
+	}
+}
+
+func TestMigrateIndex(t *testing.T) {
+	memory := db.NewMemoryClient()
+	song := fixtures.Song(2, 20, fixtures.SampleRate)
+
+	id, err := memory.RegisterSong("synth 2", "shazoom", "")
+	if err != nil {
+		t.Fatalf("failed to register song: %v", err)
+	}
+	fingerprints, err := core.GenerateFingerprintsFromSamples(song, fixtures.SampleRate, id)
+	if err != nil {
+		t.Fatalf("fingerprinting failed: %v", err)
+	}
+	memory.StoreFingerprints(fingerprints)
+
+	changed, err := core.MigrateIndex(memory, core.AddressV2)
+	if err != nil {
+		t.Fatalf("MigrateIndex failed: %v", err)
+	}
+	if changed != len(fingerprints) {
+		t.Errorf("migrated %d fingerprints, want %d", changed, len(fingerprints))
+	}
+
+	matcher := core.NewMatcher(memory)
+	clip := song[5*fixtures.SampleRate : 10*fixtures.SampleRate]
+
+	if matches, _, _ := matcher.FindMatches(clip, 5, fixtures.SampleRate); len(matches) != 0 {
+		t.Errorf("a v1 query still matched the migrated index")
+	}
+
+	matcher.Config.Address = core.AddressV2
+	matches, _, err := matcher.FindMatches(clip, 5, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(matches) == 0 || matches[0].SongId != id {
+		t.Fatal("the migrated index doesn't recognise its own song")
+	}
+}
+
+func TestMigrateRefusesLegacyAddresses(t *testing.T) {
+	// how indexes stored a pair before addresses held bins: 1000 Hz and 2000 Hz in
+	// units of 10 Hz, 250 ms apart. Read as bins they would be 1077 Hz and 2153 Hz.
+	legacy := int64(100<<23 | 200<<14 | 250)
+	for _, to := range []core.AddressVersion{core.AddressV1, core.AddressV2} {
+		if migrated, err := core.MigrateAddress(legacy, to); !errors.Is(err, core.ErrLegacyIndex) {
+			t.Errorf("MigrateAddress(%#x, v%d) = %#x, %v, want ErrLegacyIndex", legacy, to, migrated, err)
+		}
+	}
+
+	memory := db.NewMemoryClient()
+	song := fixtures.Song(2, 10, fixtures.SampleRate)
+	fingerprints, err := core.GenerateFingerprintsFromSamples(song, fixtures.SampleRate, 1)
+	if err != nil {
+		t.Fatalf("fingerprinting failed: %v", err)
+	}
+	fingerprints[legacy] = models.Couple{AnchorTime: 0, SongId: 1}
+	memory.StoreFingerprints(fingerprints)
+
+	if _, err := core.MigrateIndex(memory, core.AddressV2); !errors.Is(err, core.ErrLegacyIndex) {
+		t.Fatalf("MigrateIndex on a legacy index returned %v, want ErrLegacyIndex", err)
+	}
+	err = memory.WalkFingerprints(func(address int64, couple models.Couple) error {
+		if _, ok := fingerprints[address]; !ok {
+			t.Errorf("address %#x was rewritten", address)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkFingerprints failed: %v", err)
+	}
+}
+
+func tripletConfig() core.Config {
+	cfg := core.DefaultConfig()
+	cfg.Pairing = core.TargetZonePairing
+	cfg.TargetZone.Triplets = true
+	return cfg
+}
+
+func TestLegacyIndexIsRefused(t *testing.T) {
+	memory := db.NewMemoryClient()
+	song := fixtures.Song(7, 10, fixtures.SampleRate)
+	if _, err := core.NewIndexer(memory).IndexSamples(song, fixtures.SampleRate, db.Song{Title: "song"}); err != nil {
+		t.Fatalf("IndexSamples failed: %v", err)
+	}
+	if err := core.CheckIndex(memory); err != nil {
+		t.Fatalf("CheckIndex refused a current index: %v", err)
+	}
+
+	// a fingerprint the way indexes used to store them: 1000 Hz and 2000 Hz in units
+	// of 10 Hz, 250 ms apart, with no version
+	legacy := int64(100<<23 | 200<<14 | 250)
+	if core.AddressVersionOf(legacy) != core.AddressLegacy {
+		t.Fatalf("AddressVersionOf(%#x) = %d, want legacy", legacy, core.AddressVersionOf(legacy))
+	}
+	if err := memory.StoreFingerprints(map[int64]models.Couple{legacy: {AnchorTime: 0, SongId: 1}}); err != nil {
+		t.Fatalf("StoreFingerprints failed: %v", err)
+	}
+
+	if err := core.CheckIndex(memory); !errors.Is(err, core.ErrLegacyIndex) {
+		t.Errorf("CheckIndex returned %v, want ErrLegacyIndex", err)
+	}
+	if _, _, err := core.NewMatcher(memory).FindMatches(song[:5*fixtures.SampleRate], 5, fixtures.SampleRate); !errors.Is(err, core.ErrLegacyIndex) {
+		t.Errorf("FindMatches on a legacy index returned %v, want ErrLegacyIndex", err)
+	}
+}
@@ -0,0 +1,39 @@
+package fixtures
+
+import (
+	"fmt"
+	"shazoom/core"
+	"shazoom/db"
+)
+
+// Catalogue is an in-memory store with an Indexer on it and the samples of the songs
+// AddSongs put there, in order.
+type Catalogue struct {
+	DB      *db.MemoryClient
+	Indexer *core.Indexer
+	Songs   [][]float64
+}
+
+func NewCatalogue() *Catalogue {
+	memory := db.NewMemoryClient()
+	return &Catalogue{DB: memory, Indexer: core.NewIndexer(memory)}
+}
+
+// AddSongs indexes count Songs of seconds each at SampleRate, seeded from firstSeed
+// on. They are titled "song N" after their place in Songs, counting from 1.
+func (c *Catalogue) AddSongs(firstSeed int64, count int, seconds float64) error {
+	for i := 0; i < count; i++ {
+		song := Song(firstSeed+int64(i), seconds, SampleRate)
+		title := fmt.Sprintf("song %d", len(c.Songs)+1)
+		if _, err := c.Indexer.IndexSamples(song, SampleRate, db.Song{Title: title}); err != nil {
+			return fmt.Errorf("indexing %s: %w", title, err)
+		}
+		c.Songs = append(c.Songs, song)
+	}
+	return nil
+}
+
+// Seconds is the number of samples in s seconds at SampleRate, for slicing songs.
+func Seconds(s float64) int {
+	return int(s * SampleRate)
+}
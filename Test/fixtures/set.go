@@ -0,0 +1,115 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"shazoom/fileformat"
+	"shazoom/utils"
+)
+
+const manifestName = "manifest.json"
+
+// Fixture is one WAV file of a Set and its ground truth.
+type Fixture struct {
+	Name       string  `json:"name"`
+	File       string  `json:"file"`
+	SampleRate int     `json:"sample_rate"`
+	Duration   float64 `json:"duration"`
+	// Source names the fixture this one was cut from, and Offset is where in the
+	// source it starts, in seconds. Both are empty for full-length signals.
+	Source string  `json:"source,omitempty"`
+	Offset float64 `json:"offset,omitempty"`
+}
+
+// Set is a directory of fixtures described by a manifest.json.
+type Set struct {
+	Dir      string    `json:"-"`
+	Fixtures []Fixture `json:"fixtures"`
+}
+
+func NewSet(dir string) (*Set, error) {
+	if err := utils.CreateFolder(dir); err != nil {
+		return nil, err
+	}
+	return &Set{Dir: dir}, nil
+}
+
+// Add writes samples as a mono 16-bit WAV named after the fixture.
+func (s *Set) Add(name string, samples []float64, sampleRate int) (Fixture, error) {
+	return s.add(Fixture{Name: name}, samples, sampleRate)
+}
+
+// AddClip writes length seconds of source starting at offset seconds and records
+// where the clip came from.
+func (s *Set) AddClip(name, source string, sourceSamples []float64, sampleRate int, offset, length float64) (Fixture, error) {
+	start := sampleCount(offset, sampleRate)
+	end := start + sampleCount(length, sampleRate)
+	if start < 0 || end > len(sourceSamples) {
+		return Fixture{}, fmt.Errorf("clip %q [%.2fs, %.2fs) is outside of %q", name, offset, offset+length, source)
+	}
+	return s.add(Fixture{Name: name, Source: source, Offset: offset}, sourceSamples[start:end], sampleRate)
+}
+
+func (s *Set) add(fixture Fixture, samples []float64, sampleRate int) (Fixture, error) {
+	fixture.File = fixture.Name + ".wav"
+	fixture.SampleRate = sampleRate
+	fixture.Duration = float64(len(samples)) / float64(sampleRate)
+
+	if err := WriteWav(filepath.Join(s.Dir, fixture.File), samples, sampleRate); err != nil {
+		return Fixture{}, err
+	}
+
+	s.Fixtures = append(s.Fixtures, fixture)
+	return fixture, nil
+}
+
+func (s *Set) Path(fixture Fixture) string {
+	return filepath.Join(s.Dir, fixture.File)
+}
+
+func (s *Set) Get(name string) (Fixture, bool) {
+	for _, fixture := range s.Fixtures {
+		if fixture.Name == name {
+			return fixture, true
+		}
+	}
+	return Fixture{}, false
+}
+
+func (s *Set) WriteManifest() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.Dir, manifestName), data, 0644)
+}
+
+func LoadSet(dir string) (*Set, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read fixture manifest: %w", err)
+	}
+
+	set := &Set{Dir: dir}
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("invalid fixture manifest: %w", err)
+	}
+	return set, nil
+}
+
+// WriteWav stores samples as mono 16-bit PCM, clamping them to [-1, 1] first.
+func WriteWav(path string, samples []float64, sampleRate int) error {
+	clamped := make([]float64, len(samples))
+	for i, s := range samples {
+		clamped[i] = math.Max(-1, math.Min(1, s))
+	}
+
+	data, err := utils.FloatsToBytes(clamped, 16)
+	if err != nil {
+		return err
+	}
+	return fileformat.WriteWavFile(path, data, sampleRate, 1, 16)
+}
@@ -0,0 +1,153 @@
+/*
+Package fixtures synthesises deterministic audio for tests, so the core and fileformat
+packages can be exercised without ffmpeg, a microphone, a database or checked-in mp3s.
+Every generator is a pure function of its arguments (noise takes an explicit seed), and
+Set writes the signals to WAV together with a manifest of their ground truth.
+*/
+package fixtures
+
+import (
+	"math"
+	"math/rand"
+)
+
+// SampleRate matches the rate ffmpeg converts everything to at ingest.
+const SampleRate = 44100
+
+func sampleCount(seconds float64, sampleRate int) int {
+	return int(seconds * float64(sampleRate))
+}
+
+// Tone is a pure sine at freq Hz.
+func Tone(freq, seconds, amplitude float64, sampleRate int) []float64 {
+	samples := make([]float64, sampleCount(seconds, sampleRate))
+	for n := range samples {
+		t := float64(n) / float64(sampleRate)
+		samples[n] = amplitude * math.Sin(2*math.Pi*freq*t)
+	}
+	return samples
+}
+
+// Chirp sweeps linearly from startFreq to endFreq over the whole signal.
+func Chirp(startFreq, endFreq, seconds, amplitude float64, sampleRate int) []float64 {
+	samples := make([]float64, sampleCount(seconds, sampleRate))
+	rate := (endFreq - startFreq) / seconds
+	for n := range samples {
+		t := float64(n) / float64(sampleRate)
+		samples[n] = amplitude * math.Sin(2*math.Pi*(startFreq*t+rate*t*t/2))
+	}
+	return samples
+}
+
+// Chord sums equal-amplitude sines, scaled so the peak never exceeds amplitude.
+func Chord(freqs []float64, seconds, amplitude float64, sampleRate int) []float64 {
+	samples := make([]float64, sampleCount(seconds, sampleRate))
+	if len(freqs) == 0 {
+		return samples
+	}
+	partial := amplitude / float64(len(freqs))
+	for _, freq := range freqs {
+		for n, s := range Tone(freq, seconds, partial, sampleRate) {
+			samples[n] += s
+		}
+	}
+	return samples
+}
+
+// Noise is seeded gaussian white noise with the given RMS level.
+func Noise(seed int64, seconds, rms float64, sampleRate int) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	samples := make([]float64, sampleCount(seconds, sampleRate))
+	for n := range samples {
+		samples[n] = rms * rng.NormFloat64()
+	}
+	return samples
+}
+
+// Song renders a deterministic stand-in for a track: a run of 250 ms notes, each a chord
+// of three random partials between 100 and 2500 Hz, over a faint noise bed. Every seed
+// gives a distinct constellation of peaks, the same seed always the same samples.
+func Song(seed int64, seconds float64, sampleRate int) []float64 {
+	return ShiftedSong(seed, seconds, sampleRate, 1, 1)
+}
+
+// ShiftedSong renders the notes of Song(seed) played tempo times faster and
+// transposed by the pitch factor, for tests of shift robust matching.
+func ShiftedSong(seed int64, seconds float64, sampleRate int, tempo, pitch float64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	samples := Noise(seed^0x5eed, seconds, 0.005, sampleRate)
+	noteLength := int(float64(sampleRate) / 4 / tempo)
+
+	for start := 0; start < len(samples); start += noteLength {
+		var freqs [3]float64
+		for i := range freqs {
+			freqs[i] = (100 + rng.Float64()*2400) * pitch
+		}
+
+		for n := start; n < start+noteLength && n < len(samples); n++ {
+			t := float64(n) / float64(sampleRate)
+			for _, f := range freqs {
+				samples[n] += 0.3 * math.Sin(2*math.Pi*f*t)
+			}
+		}
+	}
+
+	return samples
+}
+
+// Cover renders the notes of Song(seed) the way another band might play them: tempo
+// times faster, transposed by semitones, with an octave overtone and a plucked decay
+// on every partial, over a different noise bed. It shares Song's harmony but hardly
+// any of its spectral peaks.
+func Cover(seed int64, seconds float64, sampleRate int, tempo, semitones float64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	samples := Noise(seed^0xc0de, seconds, 0.01, sampleRate)
+	noteLength := int(float64(sampleRate) / 4 / tempo)
+	pitch := math.Pow(2, semitones/12)
+
+	for start := 0; start < len(samples); start += noteLength {
+		var freqs [3]float64
+		for i := range freqs {
+			freqs[i] = (100 + rng.Float64()*2400) * pitch
+		}
+
+		for n := start; n < start+noteLength && n < len(samples); n++ {
+			t := float64(n) / float64(sampleRate)
+			envelope := math.Exp(-3 * float64(n-start) / float64(noteLength))
+			for _, f := range freqs {
+				samples[n] += envelope * (0.25*math.Sin(2*math.Pi*f*t) + 0.12*math.Sin(4*math.Pi*f*t))
+			}
+		}
+	}
+
+	return samples
+}
+
+// WithNoise mixes seeded white noise into a copy of samples at snrDb.
+func WithNoise(samples []float64, snrDb float64, seed int64) []float64 {
+	var power float64
+	for _, s := range samples {
+		power += s * s
+	}
+	if len(samples) > 0 {
+		power /= float64(len(samples))
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	noiseAmp := math.Sqrt(power / math.Pow(10, snrDb/10))
+
+	noisy := make([]float64, len(samples))
+	for i, s := range samples {
+		noisy[i] = s + noiseAmp*rng.NormFloat64()
+	}
+	return noisy
+}
+
+// Concat joins signals back to back.
+func Concat(signals ...[]float64) []float64 {
+	var out []float64
+	for _, signal := range signals {
+		out = append(out, signal...)
+	}
+	return out
+}
@@ -0,0 +1,171 @@
+package core_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/db"
+	"shazoom/fileformat"
+	"testing"
+)
+
+func TestIndexerSkipsKnownSongs(t *testing.T) {
+	memory := db.NewMemoryClient()
+	indexer := core.NewIndexer(memory)
+	song := fixtures.Song(1, 10, fixtures.SampleRate)
+
+	first, err := indexer.IndexSamples(song, fixtures.SampleRate, db.Song{Title: "synth 1", Artist: "shazoom"})
+	if err != nil {
+		t.Fatalf("first ingest failed: %v", err)
+	}
+	if first.Skipped || first.Fingerprints == 0 {
+		t.Fatalf("first ingest = %+v, want stored fingerprints", first)
+	}
+
+	// same key, then the same audio under another title
+	for _, info := range []db.Song{
+		{Title: "synth 1", Artist: "shazoom"},
+		{Title: "synth 1 (remaster)", Artist: "someone else"},
+	} {
+		again, err := indexer.IndexSamples(song, fixtures.SampleRate, info)
+		if err != nil {
+			t.Fatalf("re-ingest of %q failed: %v", info.Title, err)
+		}
+		if !again.Skipped || again.SongID != first.SongID {
+			t.Errorf("re-ingest of %q = %+v, want song %d skipped", info.Title, again, first.SongID)
+		}
+	}
+
+	if total, _ := memory.TotalSongs(); total != 1 {
+		t.Errorf("%d songs registered, want 1", total)
+	}
+}
+
+func TestRegisterOrGetSong(t *testing.T) {
+	memory := db.NewMemoryClient()
+
+	id, err := memory.RegisterOrGetSong(db.Song{Title: "a", Artist: "b", YouTubeID: "yt1"})
+	if err != nil {
+		t.Fatalf("RegisterOrGetSong failed: %v", err)
+	}
+
+	existing, err := memory.RegisterOrGetSong(db.Song{Title: "other", Artist: "b", YouTubeID: "yt1"})
+	if !errors.Is(err, db.ErrSongExists) || existing != id {
+		t.Errorf("same YouTube ID gave (%d, %v), want (%d, ErrSongExists)", existing, err, id)
+	}
+
+	if _, err := memory.RegisterSong("a", "b", ""); !errors.Is(err, db.ErrSongExists) {
+		t.Errorf("RegisterSong of a duplicate key returned %v, want ErrSongExists", err)
+	}
+}
+
+func TestIndexerResumesInterruptedIngest(t *testing.T) {
+	memory := db.NewMemoryClient()
+	song := fixtures.Song(2, 10, fixtures.SampleRate)
+	info := db.Song{Title: "synth 2", Artist: "shazoom"}
+
+	// registered, but the process died before any fingerprint was stored
+	id, err := memory.RegisterOrGetSong(info)
+	if err != nil {
+		t.Fatalf("RegisterOrGetSong failed: %v", err)
+	}
+
+	result, err := core.NewIndexer(memory).IndexSamples(song, fixtures.SampleRate, info)
+	if err != nil {
+		t.Fatalf("ingest failed: %v", err)
+	}
+	if result.Skipped || result.SongID != id || result.Fingerprints == 0 {
+		t.Fatalf("ingest = %+v, want song %d fingerprinted", result, id)
+	}
+
+	stored, _, _ := memory.GetSongByID(id)
+	if !stored.Fingerprinted {
+		t.Error("song not marked as fingerprinted")
+	}
+}
+
+func TestSongFromTags(t *testing.T) {
+	probe := `{
+		"streams": [{"codec_type": "audio", "tags": {"title": "stream title", "genre": "Jazz"}}],
+		"format": {"duration": "215.5", "tags": {"title": "Blue in Green", "artist": "Miles Davis",
+			"album": "Kind of Blue", "date": "1959-08-17", "tsrc": "USSM15900113"}}
+	}`
+	var metadata fileformat.FFMPEGMetaData
+	if err := json.Unmarshal([]byte(probe), &metadata); err != nil {
+		t.Fatalf("bad probe fixture: %v", err)
+	}
+
+	song := core.SongFromTags(metadata, db.Song{Artist: "given artist"})
+	want := db.Song{
+		Title:       "Blue in Green",
+		Artist:      "given artist",
+		Album:       "Kind of Blue",
+		Duration:    215.5,
+		ISRC:        "USSM15900113",
+		ReleaseYear: 1959,
+		Genre:       "Jazz",
+	}
+	if song != want {
+		t.Errorf("SongFromTags = %+v, want %+v", song, want)
+	}
+}
+
+func TestSearchSongs(t *testing.T) {
+	memory := db.NewMemoryClient()
+	for _, song := range []db.Song{
+		{Title: "So What", Artist: "Miles Davis"},
+		{Title: "Blue in Green", Artist: "Miles Davis"},
+		{Title: "Milestones", Artist: "Miles Davis Sextet"},
+		{Title: "Giant Steps", Artist: "John Coltrane"},
+		{Title: "100%", Artist: "Sonic Youth"},
+	} {
+		if _, err := memory.RegisterOrGetSong(song); err != nil {
+			t.Fatalf("RegisterOrGetSong failed: %v", err)
+		}
+	}
+
+	titles := func(songs []db.Song) []string {
+		var out []string
+		for _, song := range songs {
+			out = append(out, song.Title)
+		}
+		return out
+	}
+
+	cases := []struct {
+		query         string
+		limit, offset int
+		want          []string
+	}{
+		{"MILES", 10, 0, []string{"Blue in Green", "Milestones", "So What"}},
+		{"miles", 2, 1, []string{"Milestones", "So What"}},
+		{"stone", 10, 0, []string{"Milestones"}},
+		{"coltrane", 10, 0, []string{"Giant Steps"}},
+		{"%", 10, 0, []string{"100%"}},
+		{"miles", 10, 5, nil},
+	}
+	for _, tc := range cases {
+		songs, err := memory.SearchSongs(tc.query, tc.limit, tc.offset)
+		if err != nil {
+			t.Fatalf("SearchSongs(%q) failed: %v", tc.query, err)
+		}
+		if got := titles(songs); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SearchSongs(%q, %d, %d) = %q, want %q", tc.query, tc.limit, tc.offset, got, tc.want)
+		}
+	}
+
+	for _, args := range [][2]int{{0, 0}, {-1, 0}, {10, -1}} {
+		if _, err := memory.SearchSongs("miles", args[0], args[1]); err == nil {
+			t.Errorf("SearchSongs with limit %d and offset %d did not fail", args[0], args[1])
+		}
+	}
+
+	songs, _ := memory.SearchSongs("so what", 10, 0)
+	for _, song := range songs {
+		if song.ID == 0 || song.CreatedAt.IsZero() {
+			t.Errorf("search result %+v is missing its ID or creation time", song)
+		}
+	}
+}
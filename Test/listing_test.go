@@ -0,0 +1,175 @@
+package core_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"shazoom/api"
+	"shazoom/db"
+	"shazoom/models"
+	"testing"
+)
+
+// listingCatalogue registers songs with repeated titles and artists, so pages have to
+// break ties by ID, and gives song i i*10 fingerprints.
+func listingCatalogue(t *testing.T, songs int) *db.MemoryClient {
+	memory := db.NewMemoryClient()
+	for i := 0; i < songs; i++ {
+		id, err := memory.RegisterOrGetSong(db.Song{
+			Title:  fmt.Sprintf("title %d", i%4),
+			Artist: fmt.Sprintf("artist %d", i),
+		})
+		if err != nil {
+			t.Fatalf("RegisterOrGetSong failed: %v", err)
+		}
+
+		fingerprints := map[int64]models.Couple{}
+		for f := 0; f < i*10; f++ {
+			fingerprints[int64(f)] = models.Couple{AnchorTime: uint32(f), SongId: id}
+		}
+		memory.StoreFingerprints(fingerprints)
+	}
+	return memory
+}
+
+func TestListSongsPagination(t *testing.T) {
+	const songs = 23
+	memory := listingCatalogue(t, songs)
+
+	for _, sort := range []db.SongSort{db.SortByID, db.SortByTitle, db.SortByArtist, db.SortByCreated} {
+		var listed []db.SongListing
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > songs {
+				t.Fatalf("%s: cursor never ran out", sort)
+			}
+			page, err := memory.ListSongs(cursor, 5, sort)
+			if err != nil {
+				t.Fatalf("%s: ListSongs failed: %v", sort, err)
+			}
+			listed = append(listed, page.Songs...)
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+
+		if len(listed) != songs {
+			t.Fatalf("%s: listed %d songs, want %d", sort, len(listed), songs)
+		}
+
+		seen := map[uint32]bool{}
+		for i, song := range listed {
+			if seen[song.ID] {
+				t.Errorf("%s: song %d listed twice", sort, song.ID)
+			}
+			seen[song.ID] = true
+
+			if want := int(song.ID-1) * 10; song.Fingerprints != want {
+				t.Errorf("%s: song %d has %d fingerprints, want %d", sort, song.ID, song.Fingerprints, want)
+			}
+			if i == 0 {
+				continue
+			}
+			prev := listed[i-1]
+			if sort == db.SortByTitle && (prev.Title > song.Title || prev.Title == song.Title && prev.ID > song.ID) {
+				t.Errorf("%s: %q (%d) listed before %q (%d)", sort, prev.Title, prev.ID, song.Title, song.ID)
+			}
+			if sort == db.SortByID && prev.ID > song.ID {
+				t.Errorf("%s: %d listed before %d", sort, prev.ID, song.ID)
+			}
+		}
+	}
+}
+
+func TestListSongsCountsFollowTheFingerprints(t *testing.T) {
+	memory := listingCatalogue(t, 4)
+
+	// storing the same couples again adds nothing, deleting a song's drops its count
+	memory.StoreFingerprints(map[int64]models.Couple{0: {AnchorTime: 0, SongId: 3}, 1000: {AnchorTime: 7, SongId: 3}})
+	if _, err := memory.DeleteSongFingerprints(2); err != nil {
+		t.Fatalf("DeleteSongFingerprints failed: %v", err)
+	}
+
+	page, err := memory.ListSongs("", 10, db.SortByID)
+	if err != nil {
+		t.Fatalf("ListSongs failed: %v", err)
+	}
+	want := map[uint32]int{1: 0, 2: 0, 3: 21, 4: 30}
+	for _, song := range page.Songs {
+		if song.Fingerprints != want[song.ID] {
+			t.Errorf("song %d has %d fingerprints, want %d", song.ID, song.Fingerprints, want[song.ID])
+		}
+	}
+
+	memory.DeleteCollection("fingerprints")
+	page, _ = memory.ListSongs("", 10, db.SortByID)
+	for _, song := range page.Songs {
+		if song.Fingerprints != 0 {
+			t.Errorf("song %d still has %d fingerprints after the table was dropped", song.ID, song.Fingerprints)
+		}
+	}
+}
+
+func TestListSongsRejectsForeignCursors(t *testing.T) {
+	memory := listingCatalogue(t, 10)
+
+	page, err := memory.ListSongs("", 3, db.SortByTitle)
+	if err != nil {
+		t.Fatalf("ListSongs failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		cursor string
+		sort   db.SongSort
+	}{
+		{page.NextCursor, db.SortByArtist},
+		{"not a cursor", db.SortByTitle},
+	} {
+		if _, err := memory.ListSongs(tc.cursor, 3, tc.sort); !errors.Is(err, db.ErrInvalidCursor) {
+			t.Errorf("ListSongs(%q, %s) returned %v, want ErrInvalidCursor", tc.cursor, tc.sort, err)
+		}
+	}
+}
+
+func TestSongsEndpoint(t *testing.T) {
+	server := httptest.NewServer(api.NewServer(listingCatalogue(t, 7)).Handler())
+	defer server.Close()
+
+	var body struct {
+		Songs []struct {
+			ID           uint32 `json:"id"`
+			Artist       string `json:"artist"`
+			Fingerprints int    `json:"fingerprints"`
+		} `json:"songs"`
+		NextCursor string `json:"next_cursor"`
+	}
+
+	get := func(query string) int {
+		resp, err := http.Get(server.URL + "/songs" + query)
+		if err != nil {
+			t.Fatalf("GET /songs%s failed: %v", query, err)
+		}
+		defer resp.Body.Close()
+		body.Songs, body.NextCursor = nil, ""
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode
+	}
+
+	if status := get("?limit=4&sort=artist"); status != http.StatusOK || len(body.Songs) != 4 || body.NextCursor == "" {
+		t.Fatalf("first page: status %d, %d songs, cursor %q", status, len(body.Songs), body.NextCursor)
+	}
+	if status := get("?limit=4&sort=artist&cursor=" + body.NextCursor); status != http.StatusOK || len(body.Songs) != 3 || body.NextCursor != "" {
+		t.Fatalf("last page: status %d, %d songs, cursor %q", status, len(body.Songs), body.NextCursor)
+	}
+	if last := body.Songs[2]; last.Artist != "artist 6" || last.Fingerprints != 60 {
+		t.Errorf("last song = %+v, want artist 6 with 60 fingerprints", last)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=x", "?sort=bpm", "?cursor=bogus"} {
+		if status := get(query); status != http.StatusBadRequest {
+			t.Errorf("GET /songs%s returned %d, want 400", query, status)
+		}
+	}
+}
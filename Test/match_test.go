@@ -1,3 +1,5 @@
+//go:build microphone
+
 package core_test
 
 import (
@@ -0,0 +1,47 @@
+package core_test
+
+import (
+	"fmt"
+	"shazoom/db"
+	"sync"
+	"testing"
+)
+
+func TestMemoryClientAllocatesSongIDs(t *testing.T) {
+	memory := db.NewMemoryClient()
+
+	const workers, perWorker = 8, 250
+	ids := make(chan uint32, workers*perWorker)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id, err := memory.RegisterSong(fmt.Sprintf("song %d-%d", w, i), "shazoom", "")
+				if err != nil {
+					t.Errorf("RegisterSong failed: %v", err)
+					return
+				}
+				ids <- id
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[uint32]bool)
+	for id := range ids {
+		if id == 0 || id > workers*perWorker {
+			t.Errorf("ID %d is outside 1..%d", id, workers*perWorker)
+		}
+		if seen[id] {
+			t.Errorf("ID %d handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != workers*perWorker {
+		t.Errorf("got %d distinct IDs, want %d", len(seen), workers*perWorker)
+	}
+}
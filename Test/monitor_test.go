@@ -0,0 +1,311 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/db"
+	"shazoom/monitor"
+	"shazoom/utils"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMonitorStream(t *testing.T) {
+	catalogue := fixtures.NewCatalogue()
+	if err := catalogue.AddSongs(70, 4, 30); err != nil {
+		t.Fatalf("AddSongs failed: %v", err)
+	}
+	memory, songs := catalogue.DB, catalogue.Songs
+
+	// the broadcast: a jingle, song 3 from 10s in, song 1 whole, then talk
+	broadcast := fixtures.Concat(
+		fixtures.Noise(1, 6, 0.05, fixtures.SampleRate),
+		songs[2][fixtures.Seconds(10):],
+		songs[0],
+		fixtures.Noise(2, 12, 0.05, fixtures.SampleRate),
+	)
+	pcm, err := utils.FloatsToBytes(fixtures.WithNoise(broadcast, 20, 4), 16)
+	if err != nil {
+		t.Fatalf("FloatsToBytes failed: %v", err)
+	}
+
+	station := httptest.NewServer(&monitor.Station{PCM: pcm, Title: "Test FM - Morning Show", MetaInt: 8000})
+	defer station.Close()
+
+	var mu sync.Mutex
+	var hooked []monitor.Event
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event monitor.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		hooked = append(hooked, event)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	source, err := monitor.Open(ctx, station.URL)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer source.Close()
+
+	mon := monitor.New(core.NewMatcher(memory))
+	mon.Options.SampleRate, mon.Options.Channels = fixtures.SampleRate, 1
+	mon.Start = time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	var log bytes.Buffer
+	webhook := monitor.NewWebhook(hook.URL)
+	if err := mon.Run(ctx, source, monitor.NewJSONLines(&log), webhook); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	webhook.Close()
+
+	want := []struct {
+		kind     monitor.EventType
+		songID   uint32
+		position float64
+	}{
+		{monitor.SongStarted, 3, 6},
+		{monitor.SongEnded, 3, 26},
+		{monitor.SongStarted, 1, 26},
+		{monitor.SongEnded, 1, 56},
+	}
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d events, want %d:\n%s", len(lines), len(want), log.String())
+	}
+	for i, w := range want {
+		var event monitor.Event
+		if err := json.Unmarshal([]byte(lines[i]), &event); err != nil {
+			t.Fatalf("event %d doesn't decode: %v", i, err)
+		}
+		if event.Type != w.kind || event.SongID != w.songID || math.Abs(event.Position-w.position) > 1 {
+			t.Errorf("event %d is %s of song %d at %.2fs, want %s of song %d at %gs", i, event.Type, event.SongID, event.Position, w.kind, w.songID, w.position)
+		}
+		if wantTime := mon.Start.Add(time.Duration(event.Position * float64(time.Second))); !event.Time.Equal(wantTime) {
+			t.Errorf("event %d at %s, want %s", i, event.Time, wantTime)
+		}
+		if event.StreamTitle != "Test FM - Morning Show" {
+			t.Errorf("event %d has stream title %q", i, event.StreamTitle)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hooked) != len(want) || hooked[1].Type != monitor.SongEnded || hooked[1].Duration < 19 {
+		t.Errorf("webhook got %+v", hooked)
+	}
+}
+
+func TestWebhookDoesNotBlockEmit(t *testing.T) {
+	var mu sync.Mutex
+	received := 0
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		received++
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	webhook := monitor.NewWebhook(hook.URL)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		webhook.Emit(monitor.Event{Type: monitor.SongStarted, SongID: uint32(i + 1)})
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Emit took %v for a slow receiver", elapsed)
+	}
+
+	webhook.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if received != 5 {
+		t.Errorf("receiver got %d events after Close, want 5", received)
+	}
+}
+
+func TestMonitorStopsOnCancel(t *testing.T) {
+	memory := db.NewMemoryClient()
+	song := fixtures.Song(80, 20, fixtures.SampleRate)
+	if _, err := core.NewIndexer(memory).IndexSamples(song, fixtures.SampleRate, db.Song{Title: "looped"}); err != nil {
+		t.Fatalf("IndexSamples failed: %v", err)
+	}
+	pcm, _ := utils.FloatsToBytes(song, 16)
+
+	// a live station never ends, the monitor has to be told to stop
+	station := httptest.NewServer(&monitor.Station{PCM: pcm, Loop: true, BytesPerSecond: 40 * 2 * fixtures.SampleRate})
+	defer station.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	source, err := monitor.Open(ctx, station.URL)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer source.Close()
+
+	mon := monitor.New(core.NewMatcher(memory))
+	mon.Options.SampleRate, mon.Options.Channels = fixtures.SampleRate, 1
+
+	reader, writer := io.Pipe()
+	go func() {
+		// cancel once the song has started
+		buf := make([]byte, 4096)
+		var seen strings.Builder
+		for !strings.Contains(seen.String(), string(monitor.SongStarted)) {
+			n, err := reader.Read(buf)
+			if err != nil {
+				return
+			}
+			seen.Write(buf[:n])
+		}
+		cancel()
+		io.Copy(io.Discard, reader)
+	}()
+
+	var log bytes.Buffer
+	err = mon.Run(ctx, source, monitor.NewJSONLines(io.MultiWriter(&log, writer)))
+	writer.Close()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run returned %v, want context.Canceled", err)
+	}
+	if !strings.Contains(log.String(), string(monitor.SongEnded)) {
+		t.Errorf("the song playing when the monitor stopped wasn't ended:\n%s", log.String())
+	}
+}
+
+// collected is a Sink keeping every event.
+type collected struct {
+	events []monitor.Event
+}
+
+func (c *collected) Emit(event monitor.Event) error {
+	c.events = append(c.events, event)
+	return nil
+}
+
+// announcingReader plays pcm and announces titles[i] from byte at[i] on, like the ICY
+// metadata of a station.
+type announcingReader struct {
+	pcm    []byte
+	read   int
+	at     []int
+	titles []string
+}
+
+func (r *announcingReader) Read(p []byte) (int, error) {
+	if r.read == len(r.pcm) {
+		return 0, io.EOF
+	}
+	end := len(r.pcm)
+	for _, at := range r.at {
+		if at > r.read {
+			end = min(end, at)
+			break
+		}
+	}
+	n := copy(p, r.pcm[r.read:end])
+	r.read += n
+	return n, nil
+}
+
+func (r *announcingReader) StreamTitle() string {
+	title := ""
+	for i, at := range r.at {
+		if at < r.read {
+			title = r.titles[i]
+		}
+	}
+	return title
+}
+
+// TestMonitorTitlesFromSongStart changes the title a little before song 1 starts and
+// again before it is confirmed. Each event carries the title its song started under.
+func TestMonitorTitlesFromSongStart(t *testing.T) {
+	catalogue := fixtures.NewCatalogue()
+	if err := catalogue.AddSongs(70, 4, 30); err != nil {
+		t.Fatalf("AddSongs failed: %v", err)
+	}
+	songs := catalogue.Songs
+
+	broadcast := fixtures.Concat(songs[2][fixtures.Seconds(10):], songs[0], fixtures.Noise(1, 10, 0.05, fixtures.SampleRate))
+	pcm, err := utils.FloatsToBytes(fixtures.WithNoise(broadcast, 20, 5), 16)
+	if err != nil {
+		t.Fatalf("FloatsToBytes failed: %v", err)
+	}
+	byteAt := func(s float64) int { return 2 * fixtures.Seconds(s) }
+	source := &announcingReader{
+		pcm:    pcm,
+		at:     []int{0, byteAt(18), byteAt(28)},
+		titles: []string{"Song Three", "Song One", "Traffic News"},
+	}
+
+	mon := monitor.New(core.NewMatcher(catalogue.DB))
+	mon.Options.SampleRate, mon.Options.Channels = fixtures.SampleRate, 1
+	var log collected
+	if err := mon.Run(context.Background(), source, &log); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	want := []struct {
+		kind   monitor.EventType
+		songID uint32
+		title  string
+	}{
+		{monitor.SongStarted, 3, "Song Three"},
+		{monitor.SongEnded, 3, "Song Three"},
+		{monitor.SongStarted, 1, "Song One"},
+		{monitor.SongEnded, 1, "Song One"},
+	}
+	if len(log.events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(log.events), len(want), log.events)
+	}
+	for i, w := range want {
+		if event := log.events[i]; event.Type != w.kind || event.SongID != w.songID || event.StreamTitle != w.title {
+			t.Errorf("event %d is %s of song %d under %q, want %s of song %d under %q",
+				i, event.Type, event.SongID, event.StreamTitle, w.kind, w.songID, w.title)
+		}
+	}
+}
+
+// TestMonitorClosesStalledStream cancels a monitor while the station has stopped
+// sending without hanging up.
+func TestMonitorClosesStalledStream(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+
+	mon := monitor.New(core.NewMatcher(db.NewMemoryClient()))
+	mon.Options.SampleRate, mon.Options.Channels = fixtures.SampleRate, 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- mon.Run(ctx, reader) }()
+
+	pcm, _ := utils.FloatsToBytes(fixtures.Noise(1, 1, 0.05, fixtures.SampleRate), 16)
+	if _, err := writer.Write(pcm); err != nil {
+		t.Fatalf("writing the stream failed: %v", err)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run returned %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run still blocks reading the stream 5s after cancellation")
+	}
+}
@@ -0,0 +1,129 @@
+package core_test
+
+import (
+	"fmt"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/db"
+	"testing"
+)
+
+// gridPeaks puts a peak on every bin of bins in each of frames frames, 50ms apart.
+func gridPeaks(frames int, bins ...int) []core.Peak {
+	var peaks []core.Peak
+	for f := 0; f < frames; f++ {
+		for _, bin := range bins {
+			peaks = append(peaks, core.Peak{Time: float64(f) * 0.05, Freq: float64(bin) * 10, Bin: bin})
+		}
+	}
+	return peaks
+}
+
+func TestTargetZoneBounds(t *testing.T) {
+	peaks := gridPeaks(40, 20, 60, 300)
+
+	legacy := core.Fingerprint(peaks, 1)
+	sameFrame := 0
+	for address := range legacy {
+		if _, _, deltaMs := core.UnpackAddress(address); deltaMs == 0 {
+			sameFrame++
+		}
+	}
+	if sameFrame == 0 {
+		t.Fatal("expected next-peaks pairing to pair peaks of the same frame")
+	}
+
+	opts := core.TargetZoneOptions{MinDeltaMs: 40, MaxDeltaMs: 500, FreqWindow: 100, FanOut: 3}
+	for address := range core.FingerprintTargetZone(peaks, 1, opts) {
+		anchorBin, targetBin, deltaMs := core.UnpackAddress(address)
+		if deltaMs < int64(opts.MinDeltaMs) || deltaMs > int64(opts.MaxDeltaMs) {
+			t.Errorf("address %#x has delta %d ms outside [%d, %d]", address, deltaMs, opts.MinDeltaMs, opts.MaxDeltaMs)
+		}
+		if diff := anchorBin - targetBin; diff > opts.FreqWindow || -diff > opts.FreqWindow {
+			t.Errorf("address %#x pairs bins %d and %d, window is %d", address, anchorBin, targetBin, opts.FreqWindow)
+		}
+	}
+}
+
+func TestTargetZoneFanOut(t *testing.T) {
+	// a single anchor followed by ten targets on distinct bins, so no address repeats
+	peaks := []core.Peak{{Time: 0, Bin: 100}}
+	for i := 1; i <= 10; i++ {
+		peaks = append(peaks, core.Peak{Time: float64(i) * 0.1, Bin: 100 + i})
+	}
+
+	opts := core.TargetZoneOptions{MinDeltaMs: 1, MaxDeltaMs: 5000, FanOut: 4}
+	pairs := 0
+	for address, couple := range core.FingerprintTargetZone(peaks, 7, opts) {
+		if couple.SongId != 7 {
+			t.Fatalf("couple carries song %d, want 7", couple.SongId)
+		}
+		if anchorBin, _, _ := core.UnpackAddress(address); anchorBin == 100 {
+			pairs++
+		}
+	}
+	if pairs != opts.FanOut {
+		t.Errorf("anchor paired with %d targets, fan-out is %d", pairs, opts.FanOut)
+	}
+
+	opts.Triplets = true
+	triplets := core.FingerprintTargetZone(peaks[:opts.FanOut+1], 7, opts)
+	want := opts.FanOut * (opts.FanOut - 1) / 2
+	// later anchors pair too: 3 targets give 3 triplets, 2 give 1
+	want += 3 + 1
+	if len(triplets) != want {
+		t.Errorf("got %d triplet hashes, want %d", len(triplets), want)
+	}
+	for address := range triplets {
+		if !core.IsTripletAddress(address) {
+			t.Errorf("address %#x is not a triplet address", address)
+		}
+	}
+}
+
+func TestPairingStrategiesMatch(t *testing.T) {
+	const songs = 4
+
+	triplets := tripletConfig()
+
+	zone := core.DefaultConfig()
+	zone.Pairing = core.TargetZonePairing
+
+	for name, cfg := range map[string]core.Config{"target zone": zone, "triplets": triplets} {
+		memory := db.NewMemoryClient()
+		ids := make([]uint32, songs)
+		tracks := make([][]float64, songs)
+		for i := range tracks {
+			tracks[i] = fixtures.Song(int64(i+1), scoringSongLength, scoringSampleRate)
+			id, err := memory.RegisterSong(fmt.Sprintf("synth %d", i+1), "shazoom", "")
+			if err != nil {
+				t.Fatalf("failed to register song: %v", err)
+			}
+			fingerprints, err := cfg.GenerateFingerprintsFromSamples(tracks[i], scoringSampleRate, id)
+			if err != nil {
+				t.Fatalf("failed to fingerprint song: %v", err)
+			}
+			if err := memory.StoreFingerprints(fingerprints); err != nil {
+				t.Fatalf("failed to store fingerprints: %v", err)
+			}
+			ids[i] = id
+		}
+
+		matcher := core.NewMatcher(memory)
+		matcher.Config = cfg
+
+		for i, track := range tracks {
+			start := (i + 1) * 3 * scoringSampleRate
+			clip := track[start : start+scoringClipLength*scoringSampleRate]
+			for _, sample := range [][]float64{clip, fixtures.WithNoise(clip, 0, int64(i))} {
+				matches, _, err := matcher.FindMatches(sample, scoringClipLength, scoringSampleRate)
+				if err != nil {
+					t.Fatalf("%s: query failed: %v", name, err)
+				}
+				if len(matches) == 0 || matches[0].SongId != ids[i] {
+					t.Errorf("%s: clip of song %d not recognised", name, i+1)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package core_test
+
+import (
+	"bytes"
+	"math"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/eval"
+	"testing"
+)
+
+// frames start 512 samples apart after downsampling by 4
+const hopSeconds = 512.0 * 4 / 44100
+
+func TestExtractLocalMaximaDensity(t *testing.T) {
+	song := fixtures.Song(3, 10, fixtures.SampleRate)
+	spectrogram, err := core.Spectrogram(song, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("Spectrogram failed: %v", err)
+	}
+
+	opts := core.DefaultLocalMaximaOptions()
+	opts.PeaksPerSecond = 15
+	peaks := core.ExtractLocalMaxima(spectrogram, fixtures.SampleRate, core.SpectrogramOptions{}, opts)
+	if len(peaks) == 0 {
+		t.Fatal("no peaks extracted")
+	}
+
+	perSecond := map[int]int{}
+	for i, peak := range peaks {
+		perSecond[int(peak.Time)]++
+		if i > 0 && peak.Time < peaks[i-1].Time {
+			t.Fatalf("peaks are not in time order at %d", i)
+		}
+
+		frame := spectrogram[int(math.Round(peak.Time/hopSeconds))]
+		bin := int(math.Round(peak.Freq / binHz))
+		for k := max(1, bin-opts.FreqRadius); k <= min(len(frame)-1, bin+opts.FreqRadius); k++ {
+			if frame[k] > frame[bin] {
+				t.Fatalf("peak at %.1f Hz, %.2fs is not a local maximum", peak.Freq, peak.Time)
+			}
+		}
+	}
+
+	for second, count := range perSecond {
+		if count > opts.PeaksPerSecond {
+			t.Errorf("second %d has %d peaks, target is %d", second, count, opts.PeaksPerSecond)
+		}
+	}
+}
+
+func TestPeakPickerComparison(t *testing.T) {
+	cfg := eval.DefaultConfig()
+	cfg.QueriesPerTrack = 2
+	cfg.Distractors = synthCatalogue(100, 2)
+	cfg.Conditions = []eval.Condition{
+		eval.NewCondition(),
+		eval.NewCondition(eval.WhiteNoise(0)),
+		eval.NewCondition(eval.WhiteNoise(-10)),
+		eval.NewCondition(eval.PinkNoise(0)),
+		eval.NewCondition(eval.PinkNoise(-10)),
+		eval.NewCondition(eval.EQ(-12, 6, 300)),
+		eval.NewCondition(eval.BandLimit(3000), eval.PinkNoise(5)),
+	}
+
+	localMaxima := core.DefaultConfig()
+	localMaxima.PeakPicker = core.LocalMaximaPeaks
+
+	// mean top-1 accuracy over the conditions after the clean one, per picker
+	noisy := make(map[core.PeakPicker]float64)
+	for _, fingerprintConfig := range []core.Config{core.DefaultConfig(), localMaxima} {
+		harness, err := eval.NewHarness(synthCatalogue(1, scoringSongs), fingerprintConfig)
+		if err != nil {
+			t.Fatalf("failed to index the catalogue: %v", err)
+		}
+
+		report, err := harness.Run(cfg)
+		if err != nil {
+			t.Fatalf("evaluation failed: %v", err)
+		}
+
+		var table bytes.Buffer
+		report.WriteTo(&table)
+		t.Logf("peak picker %q:\n%s", fingerprintConfig.PeakPicker, table.String())
+
+		if clean := report.Results[0]; clean.Top1Accuracy < 0.9 {
+			t.Errorf("%s: clean top-1 accuracy %.2f, want at least 0.9", fingerprintConfig.PeakPicker, clean.Top1Accuracy)
+		}
+		for _, result := range report.Results[1:] {
+			noisy[fingerprintConfig.PeakPicker] += result.Top1Accuracy / float64(len(report.Results)-1)
+		}
+	}
+
+	// the band picker stays the default as long as it holds up at least as well
+	defaultPicker := core.DefaultConfig().PeakPicker
+	if noisy[defaultPicker] < noisy[core.LocalMaximaPeaks] {
+		t.Errorf("default picker %q averages %.2f top-1 under noise, local maxima %.2f",
+			defaultPicker, noisy[defaultPicker], noisy[core.LocalMaximaPeaks])
+	}
+}
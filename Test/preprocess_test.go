@@ -0,0 +1,131 @@
+package core_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/db"
+	"testing"
+)
+
+func TestPreprocessTrimsAndLevels(t *testing.T) {
+	const lead = 3.0
+	song := fixtures.Song(4, 10, fixtures.SampleRate)
+	recording := fixtures.Concat(fixtures.Noise(1, lead, 1e-5, fixtures.SampleRate), song)
+	for i := range recording {
+		recording[i] = 0.05*recording[i] + 0.1
+	}
+
+	opts := core.DefaultPreprocessOptions()
+	opts.Normalize = core.RMSNormalization
+	opts.TargetDb = -20
+	preprocessed, err := opts.Preprocess(recording, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("Preprocess failed: %v", err)
+	}
+
+	if got := preprocessed.LeadingSilence(); math.Abs(got-lead) > 0.02 {
+		t.Errorf("trimmed %.3fs of leading silence, want %.1fs", got, lead)
+	}
+	if got := preprocessed.OriginalTime(1); math.Abs(got-(1+lead)) > 0.02 {
+		t.Errorf("1s into the trimmed clip maps to %.3fs, want %.1fs", got, 1+lead)
+	}
+
+	var mean, power float64
+	for _, s := range preprocessed.Samples {
+		mean += s
+		power += s * s
+	}
+	mean /= float64(len(preprocessed.Samples))
+	level := 10 * math.Log10(power/float64(len(preprocessed.Samples)))
+	if math.Abs(mean) > 1e-3 || math.Abs(level+20) > 0.1 {
+		t.Errorf("mean %.4f, level %.2f dBFS, want no DC at -20 dBFS", mean, level)
+	}
+}
+
+func TestLoudnessOfFullScaleSine(t *testing.T) {
+	// BS.1770 calibrates a full scale 1 kHz sine to -3.01 LUFS
+	const sampleRate = 48000
+	tone := fixtures.Tone(1000, 5, 1, sampleRate)
+
+	opts := core.PreprocessOptions{Normalize: core.LoudnessNormalization, TargetDb: -23}
+	preprocessed, err := opts.Preprocess(tone, sampleRate)
+	if err != nil {
+		t.Fatalf("Preprocess failed: %v", err)
+	}
+	if got := 20 * math.Log10(preprocessed.Gain); math.Abs(got-(-23+3.01)) > 0.05 {
+		t.Errorf("gain %.2f dB, want %.2f dB", got, -23+3.01)
+	}
+}
+
+func TestPreprocessRejectsSilence(t *testing.T) {
+	hiss := fixtures.Noise(7, 4, 1e-4, fixtures.SampleRate)
+	// a click is not enough sound to fingerprint
+	copy(hiss[fixtures.SampleRate:], fixtures.Tone(800, 0.1, 0.5, fixtures.SampleRate))
+
+	if _, err := core.DefaultPreprocessOptions().Preprocess(hiss, fixtures.SampleRate); !errors.Is(err, core.ErrSilentClip) {
+		t.Fatalf("Preprocess returned %v, want ErrSilentClip", err)
+	}
+
+	matcher := core.NewMatcher(db.NewMemoryClient())
+	matcher.Config.Preprocess = core.DefaultPreprocessOptions()
+	if _, _, err := matcher.FindMatches(hiss, 4, fixtures.SampleRate); !errors.Is(err, core.ErrSilentClip) {
+		t.Fatalf("FindMatches returned %v, want ErrSilentClip", err)
+	}
+}
+
+func TestPreprocessDefaultsTheSilenceThreshold(t *testing.T) {
+	song := fixtures.Song(3, 4, fixtures.SampleRate)
+
+	for _, opts := range []core.PreprocessOptions{{TrimSilence: true}, {MinSound: 0.5}} {
+		processed, err := opts.Preprocess(song, fixtures.SampleRate)
+		if err != nil {
+			t.Fatalf("Preprocess(%+v) returned %v for a song", opts, err)
+		}
+		if len(processed.Samples) == 0 {
+			t.Errorf("Preprocess(%+v) cut the whole song", opts)
+		}
+	}
+}
+
+func TestPreprocessKeepsTimestamps(t *testing.T) {
+	cfg := core.DefaultConfig()
+	cfg.Preprocess = core.DefaultPreprocessOptions()
+
+	memory := db.NewMemoryClient()
+	indexer := &core.Indexer{DB: memory, Config: cfg}
+	var target []float64
+	for i := 0; i < 5; i++ {
+		song := fixtures.Song(int64(i+20), 20, fixtures.SampleRate)
+		if _, err := indexer.IndexSamples(song, fixtures.SampleRate, db.Song{Title: fmt.Sprintf("song %d", i+1)}); err != nil {
+			t.Fatalf("IndexSamples failed: %v", err)
+		}
+		if i == 1 {
+			target = song
+		}
+	}
+
+	// two seconds of room tone before the song, recorded at a low level
+	offset := 8 * fixtures.SampleRate
+	clip := fixtures.Concat(
+		fixtures.Noise(3, 2, 1e-5, fixtures.SampleRate),
+		fixtures.WithNoise(target[offset:offset+5*fixtures.SampleRate], 15, 1),
+	)
+	for i := range clip {
+		clip[i] *= 0.02
+	}
+
+	matches, _, err := (&core.Matcher{DB: memory, Config: cfg}).FindMatches(clip, 7, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("FindMatches failed: %v", err)
+	}
+	if len(matches) == 0 || matches[0].SongId != 2 {
+		t.Fatalf("matched %v, want song 2", matches)
+	}
+	// the clip starts two seconds before the 8s mark
+	if got := float64(matches[0].Timestamp); math.Abs(got-6000) > 100 {
+		t.Errorf("clip placed at %.0fms, want 6000ms", got)
+	}
+}
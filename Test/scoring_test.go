@@ -0,0 +1,285 @@
+package core_test
+
+import (
+	"math/rand"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/models"
+	"testing"
+)
+
+const (
+	scoringSampleRate = fixtures.SampleRate
+	scoringSongs      = 8
+	scoringSongLength = 30.0
+	scoringClipLength = 5.0
+	scoringClips      = 24
+	hopMs             = 47
+)
+
+type labelledClip struct {
+	songID   uint32
+	offsetMs int32
+	sample   map[int64]uint32
+}
+
+// buildScoringIndex fingerprints the synthetic songs into an in-memory stand-in for
+// the fingerprints table. Song i is stored under ID i+1.
+func buildScoringIndex(t testing.TB) (map[int64][]models.Couple, [][]float64) {
+	index := make(map[int64][]models.Couple)
+	songs := make([][]float64, scoringSongs)
+
+	for i := range songs {
+		songs[i] = fixtures.Song(int64(i+1), scoringSongLength, scoringSampleRate)
+
+		fingerprints, err := core.GenerateFingerprintsFromSamples(songs[i], scoringSampleRate, uint32(i+1))
+		if err != nil {
+			t.Fatalf("failed to fingerprint song %d: %v", i+1, err)
+		}
+		for address, couple := range fingerprints {
+			index[address] = append(index[address], couple)
+		}
+	}
+
+	return index, songs
+}
+
+func buildScoringSet(t testing.TB, snrDb float64) (map[int64][]models.Couple, []labelledClip) {
+	index, songs := buildScoringIndex(t)
+
+	rng := rand.New(rand.NewSource(42))
+	clipSamples := int(scoringClipLength * scoringSampleRate)
+	clips := make([]labelledClip, 0, scoringClips)
+
+	for i := 0; i < scoringClips; i++ {
+		songIdx := i % scoringSongs
+		offset := rng.Intn(len(songs[songIdx]) - clipSamples)
+		clip := fixtures.WithNoise(songs[songIdx][offset:offset+clipSamples], snrDb, int64(i))
+
+		fingerprints, err := core.GenerateFingerprintsFromSamples(clip, scoringSampleRate, 0)
+		if err != nil {
+			t.Fatalf("failed to fingerprint clip %d: %v", i, err)
+		}
+
+		sample := make(map[int64]uint32, len(fingerprints))
+		for address, couple := range fingerprints {
+			sample[address] = couple.AnchorTime
+		}
+		clips = append(clips, labelledClip{
+			songID:   uint32(songIdx + 1),
+			offsetMs: int32(offset * 1000 / scoringSampleRate),
+			sample:   sample,
+		})
+	}
+
+	return index, clips
+}
+
+func topSong(scores map[uint32]core.TimingScore) uint32 {
+	var best uint32
+	bestScore := -1.0
+	for songID, timing := range scores {
+		if timing.Score > bestScore || (timing.Score == bestScore && songID < best) {
+			best, bestScore = songID, timing.Score
+		}
+	}
+	return best
+}
+
+func scorerAccuracy(index map[int64][]models.Couple, clips []labelledClip, scorer core.TimingScorer) float64 {
+	correct := 0
+	for _, clip := range clips {
+		if topSong(scorer(core.CollectMatches(clip.sample, index))) == clip.songID {
+			correct++
+		}
+	}
+	return float64(correct) / float64(len(clips))
+}
+
+// scoringCondition is one cell of the accuracy grid in TestScorerAccuracy.
+type scoringCondition struct {
+	clipSeconds float64
+	snrDb       float64
+	tempo       float64
+}
+
+const (
+	hardScoringSongs  = 12
+	hardScoringLength = 20.0
+	hardScoringClips  = 36
+	// siblingTempo is how much faster the sibling of every song plays the same notes.
+	siblingTempo = 1.15
+)
+
+// buildHardScoringIndex stores hardScoringSongs songs under IDs 1..n and, under IDs
+// n+1..2n, a sibling of each that plays the same notes siblingTempo times faster. A
+// sibling shares the frequencies and most addresses of its song but not the timing,
+// so only the offset analysis can tell the two apart.
+func buildHardScoringIndex(t testing.TB) map[int64][]models.Couple {
+	index := make(map[int64][]models.Couple)
+
+	for i := 0; i < 2*hardScoringSongs; i++ {
+		seed, tempo := int64(i+1), 1.0
+		if i >= hardScoringSongs {
+			seed, tempo = int64(i-hardScoringSongs+1), siblingTempo
+		}
+		song := fixtures.ShiftedSong(seed, hardScoringLength, scoringSampleRate, tempo, 1)
+
+		fingerprints, err := core.GenerateFingerprintsFromSamples(song, scoringSampleRate, uint32(i+1))
+		if err != nil {
+			t.Fatalf("failed to fingerprint song %d: %v", i+1, err)
+		}
+		for address, couple := range fingerprints {
+			index[address] = append(index[address], couple)
+		}
+	}
+
+	return index
+}
+
+// buildHardScoringClips cuts clips from the songs played at cond.tempo, so a tempo
+// other than 1 makes the true offset drift across the clip.
+func buildHardScoringClips(t testing.TB, cond scoringCondition) []labelledClip {
+	sources := make([][]float64, hardScoringSongs)
+	for i := range sources {
+		sources[i] = fixtures.ShiftedSong(int64(i+1), hardScoringLength, scoringSampleRate, cond.tempo, 1)
+	}
+
+	rng := rand.New(rand.NewSource(42))
+	clipSamples := int(cond.clipSeconds * scoringSampleRate)
+	clips := make([]labelledClip, 0, hardScoringClips)
+
+	for i := 0; i < hardScoringClips; i++ {
+		songIdx := i % hardScoringSongs
+		offset := rng.Intn(len(sources[songIdx]) - clipSamples)
+		clip := fixtures.WithNoise(sources[songIdx][offset:offset+clipSamples], cond.snrDb, int64(i))
+
+		fingerprints, err := core.GenerateFingerprintsFromSamples(clip, scoringSampleRate, 0)
+		if err != nil {
+			t.Fatalf("failed to fingerprint clip %d: %v", i, err)
+		}
+
+		sample := make(map[int64]uint32, len(fingerprints))
+		for address, couple := range fingerprints {
+			sample[address] = couple.AnchorTime
+		}
+		clips = append(clips, labelledClip{
+			songID:   uint32(songIdx + 1),
+			offsetMs: int32(float64(offset) * 1000 * cond.tempo / scoringSampleRate),
+			sample:   sample,
+		})
+	}
+
+	return clips
+}
+
+/*
+TestScorerAccuracy runs both scorers over a grid hard enough for them to disagree:
+short clips, noise down to -25 dB, clips played 2% fast, and a sibling of every song
+in the index. The streak scorer is the default because the histogram scorer doesn't
+beat it over the whole grid: it gains at -25 dB, where chance collisions form short
+chains of close deltas but rarely pile into one bucket, and loses about as much at
+-10 dB.
+*/
+func TestScorerAccuracy(t *testing.T) {
+	index := buildHardScoringIndex(t)
+
+	var histogramTotal, streakTotal float64
+	differ := false
+	for _, clipSeconds := range []float64{1, 2} {
+		for _, snrDb := range []float64{-10, -20, -25} {
+			for _, tempo := range []float64{1, 1.02} {
+				cond := scoringCondition{clipSeconds, snrDb, tempo}
+				clips := buildHardScoringClips(t, cond)
+
+				histogram := scorerAccuracy(index, clips, core.HistogramScorer(50, 1))
+				streak := scorerAccuracy(index, clips, core.StreakScorer(3))
+
+				t.Logf("clip %.0f s, SNR %5.1f dB, tempo %.2f: histogram %.2f, streak %.2f (%d clips)",
+					clipSeconds, snrDb, tempo, histogram, streak, len(clips))
+
+				if histogram != streak {
+					differ = true
+				}
+				histogramTotal += histogram
+				streakTotal += streak
+			}
+		}
+	}
+
+	if !differ {
+		t.Errorf("both scorers scored the same on every condition, the set can't tell them apart")
+	}
+	if streakTotal < histogramTotal {
+		t.Errorf("the default streak scorer (%.2f) is less accurate over the grid than the histogram scorer (%.2f)", streakTotal, histogramTotal)
+	}
+}
+
+func TestHistogramScorerIgnoresDriftingChains(t *testing.T) {
+	// Song 1 has a chain of deltas 3 ms apart drifting over 1.2 s, song 2 has fewer
+	// hits that all agree on one offset. Only the latter is a real alignment.
+	matches := map[uint32][][2]uint32{}
+	for i := uint32(0); i < 400; i++ {
+		matches[1] = append(matches[1], [2]uint32{1000, 5000 + 3*i})
+	}
+	for i := uint32(0); i < 60; i++ {
+		matches[2] = append(matches[2], [2]uint32{100 * i, 100*i + 7000})
+	}
+
+	if got := topSong(core.StreakScorer(3)(matches)); got != 1 {
+		t.Fatalf("expected the streak scorer to be fooled by the chain, picked %d", got)
+	}
+	if got := topSong(core.HistogramScorer(50, 1)(matches)); got != 2 {
+		t.Fatalf("histogram scorer picked song %d, want 2", got)
+	}
+}
+
+func TestScorerReportsClipOffset(t *testing.T) {
+	index, clips := buildScoringSet(t, 10)
+	scorer := core.HistogramScorer(50, 1)
+
+	for i, clip := range clips {
+		timing, ok := scorer(core.CollectMatches(clip.sample, index))[clip.songID]
+		if !ok {
+			t.Fatalf("clip %d got no score for its own song", i)
+		}
+
+		diff := timing.OffsetMs - clip.offsetMs
+		if diff < 0 {
+			diff = -diff
+		}
+		// peak times are frame quantised, so allow one hop on top of the reported tolerance
+		if diff > timing.ToleranceMs+hopMs {
+			t.Errorf("clip %d reported offset %d ±%d ms, actual %d ms", i, timing.OffsetMs, timing.ToleranceMs, clip.offsetMs)
+		}
+	}
+}
+
+// benchmarkScoringCondition is a cell of the accuracy grid where the scorers differ.
+var benchmarkScoringCondition = scoringCondition{clipSeconds: 2, snrDb: -20, tempo: 1}
+
+func BenchmarkHistogramScorer(b *testing.B) {
+	index := buildHardScoringIndex(b)
+	clips := buildHardScoringClips(b, benchmarkScoringCondition)
+	scorer := core.HistogramScorer(50, 1)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		clip := clips[i%len(clips)]
+		scorer(core.CollectMatches(clip.sample, index))
+	}
+	b.ReportMetric(scorerAccuracy(index, clips, scorer), "accuracy")
+}
+
+func BenchmarkStreakScorer(b *testing.B) {
+	index := buildHardScoringIndex(b)
+	clips := buildHardScoringClips(b, benchmarkScoringCondition)
+	scorer := core.StreakScorer(3)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		clip := clips[i%len(clips)]
+		scorer(core.CollectMatches(clip.sample, index))
+	}
+	b.ReportMetric(scorerAccuracy(index, clips, scorer), "accuracy")
+}
@@ -0,0 +1,140 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"strings"
+	"testing"
+)
+
+func TestRecognizeSegments(t *testing.T) {
+	catalogue := fixtures.NewCatalogue()
+	if err := catalogue.AddSongs(50, 5, 40); err != nil {
+		t.Fatalf("AddSongs failed: %v", err)
+	}
+	memory, songs := catalogue.DB, catalogue.Songs
+
+	// a mix: 8s of noise, 30s of song 2 from 5s in, all of song 4, 10s of noise and
+	// 25s of song 1 from the start
+	recording := fixtures.Concat(
+		fixtures.Noise(1, 8, 0.05, fixtures.SampleRate),
+		songs[1][fixtures.Seconds(5):fixtures.Seconds(35)],
+		songs[3],
+		fixtures.Noise(2, 10, 0.05, fixtures.SampleRate),
+		songs[0][:fixtures.Seconds(25)],
+	)
+	recording = fixtures.WithNoise(recording, 20, 3)
+
+	want := []struct {
+		songID             uint32
+		start, end, songAt float64
+	}{
+		{2, 8, 38, 5},
+		{4, 38, 78, 0},
+		{1, 88, 113, 0},
+	}
+
+	matcher := core.NewMatcher(memory)
+	segments, err := matcher.RecognizeSegments([][]float64{recording}, fixtures.SampleRate, core.DefaultSegmentOptions())
+	if err != nil {
+		t.Fatalf("RecognizeSegments failed: %v", err)
+	}
+	for _, s := range segments {
+		t.Logf("song %d %.2fs-%.2fs from %.2fs, confidence %.2f, score %.0f", s.SongID, s.Start, s.End, s.SongOffset, s.Confidence, s.Score)
+	}
+
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(want))
+	}
+	for i, w := range want {
+		s := segments[i]
+		if s.SongID != w.songID || s.Title != fmt.Sprintf("song %d", w.songID) {
+			t.Errorf("segment %d is song %d %q, want song %d", i, s.SongID, s.Title, w.songID)
+			continue
+		}
+		if math.Abs(s.Start-w.start) > 1 || math.Abs(s.End-w.end) > 1 {
+			t.Errorf("song %d spans %.2fs-%.2fs, want %gs-%gs", w.songID, s.Start, s.End, w.start, w.end)
+		}
+		if math.Abs(s.SongOffset-w.songAt) > 1 {
+			t.Errorf("song %d starts %.2fs into the song, want %gs", w.songID, s.SongOffset, w.songAt)
+		}
+		if s.Confidence < 0.99 {
+			t.Errorf("song %d has confidence %.2f, want every window to match", w.songID, s.Confidence)
+		}
+	}
+
+	var timeline bytes.Buffer
+	if err := core.WriteTimeline(&timeline, segments); err != nil {
+		t.Fatalf("WriteTimeline failed: %v", err)
+	}
+	var decoded struct {
+		Segments []struct {
+			SongID uint32  `json:"song_id"`
+			Start  float64 `json:"start"`
+		} `json:"segments"`
+	}
+	if err := json.Unmarshal(timeline.Bytes(), &decoded); err != nil || len(decoded.Segments) != len(segments) {
+		t.Fatalf("timeline %s doesn't decode to %d segments: %v", timeline.Bytes(), len(segments), err)
+	}
+
+	var cue bytes.Buffer
+	if err := core.WriteCueSheet(&cue, segments, "mix.wav"); err != nil {
+		t.Fatalf("WriteCueSheet failed: %v", err)
+	}
+	for _, line := range []string{`FILE "mix.wav" WAVE`, "TRACK 03 AUDIO", `TITLE "song 4"`} {
+		if !strings.Contains(cue.String(), line) {
+			t.Errorf("CUE sheet is missing %q:\n%s", line, cue.String())
+		}
+	}
+}
+
+// TestSegmentsBridgeAnInterruption plays a few seconds of another catalogued song
+// over the middle of one, like a jingle. The song carries on at the same offset
+// afterwards, so it stays one segment.
+func TestSegmentsBridgeAnInterruption(t *testing.T) {
+	catalogue := fixtures.NewCatalogue()
+	if err := catalogue.AddSongs(70, 2, 60); err != nil {
+		t.Fatalf("AddSongs failed: %v", err)
+	}
+	memory, songs := catalogue.DB, catalogue.Songs
+
+	recording := fixtures.Concat(
+		songs[0][:fixtures.Seconds(20)],
+		songs[1][fixtures.Seconds(30):fixtures.Seconds(38)],
+		songs[0][fixtures.Seconds(28):],
+	)
+	recording = fixtures.WithNoise(recording, 20, 4)
+
+	matcher := core.NewMatcher(memory)
+	segments, err := matcher.RecognizeSegments([][]float64{recording}, fixtures.SampleRate, core.DefaultSegmentOptions())
+	if err != nil {
+		t.Fatalf("RecognizeSegments failed: %v", err)
+	}
+	for _, s := range segments {
+		t.Logf("song %d %.2fs-%.2fs, confidence %.2f", s.SongID, s.Start, s.End, s.Confidence)
+	}
+
+	if len(segments) != 1 || segments[0].SongID != 1 {
+		t.Fatalf("got %d segments, want song 1 throughout", len(segments))
+	}
+	if s := segments[0]; s.Start > 1 || s.End < 59 {
+		t.Errorf("song 1 spans %.2fs-%.2fs, want 0s-60s", s.Start, s.End)
+	}
+}
+
+func TestWriteCueSheetTimes(t *testing.T) {
+	var cue bytes.Buffer
+	segments := []core.Segment{{Title: `say "hi"`, Artist: "a", Start: 125.5, Confidence: 1}}
+	if err := core.WriteCueSheet(&cue, segments, "x.flac"); err != nil {
+		t.Fatalf("WriteCueSheet failed: %v", err)
+	}
+	for _, line := range []string{"INDEX 01 02:05:38", `TITLE "say 'hi'"`} {
+		if !strings.Contains(cue.String(), line) {
+			t.Errorf("CUE sheet is missing %q:\n%s", line, cue.String())
+		}
+	}
+}
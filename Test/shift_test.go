@@ -0,0 +1,78 @@
+package core_test
+
+import (
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"testing"
+)
+
+// changeSpeed plays samples back speed times faster by linear interpolation, which
+// shifts tempo and pitch together like a sped-up radio edit.
+func changeSpeed(samples []float64, speed float64) []float64 {
+	out := make([]float64, int(float64(len(samples))/speed))
+	for n := range out {
+		pos := float64(n) * speed
+		i := int(pos)
+		if i+1 >= len(samples) {
+			out[n] = samples[len(samples)-1]
+			continue
+		}
+		frac := pos - float64(i)
+		out[n] = samples[i]*(1-frac) + samples[i+1]*frac
+	}
+	return out
+}
+
+func TestShiftSearchMatchesStretchedClips(t *testing.T) {
+	index, songs := buildScoringIndex(t)
+	clipSamples := int(scoringClipLength * scoringSampleRate)
+	offset := 10 * scoringSampleRate
+
+	cases := []struct {
+		name   string
+		songID uint32
+		clip   []float64
+	}{
+		{"speed +5%", 3, changeSpeed(songs[2][offset:offset+clipSamples*2], 1.05)[:clipSamples]},
+		{"speed -8%", 5, changeSpeed(songs[4][offset:offset+clipSamples], 0.92)[:clipSamples]},
+		{"tempo -7%", 2, fixtures.ShiftedSong(2, scoringSongLength, scoringSampleRate, 0.93, 1)[offset : offset+clipSamples]},
+		{"pitch +6%", 7, fixtures.ShiftedSong(7, scoringSongLength, scoringSampleRate, 1, 1.06)[offset : offset+clipSamples]},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clip := fixtures.WithNoise(tc.clip, 10, 1)
+			variants, err := core.ShiftedSampleFingerprints(clip, scoringClipLength, scoringSampleRate, core.DefaultShiftSearch())
+			if err != nil {
+				t.Fatalf("ShiftedSampleFingerprints failed: %v", err)
+			}
+
+			plain := core.ScoreShiftedMatches(variants[:1], index)
+			shifted := core.ScoreShiftedMatches(variants, index)
+			t.Logf("plain: top %d (own song %.0f), shift search: top %d (own song %.0f)",
+				topSong(plain), plain[tc.songID].Score, topSong(shifted), shifted[tc.songID].Score)
+
+			if got := topSong(shifted); got != tc.songID {
+				t.Fatalf("shift search matched song %d, want %d", got, tc.songID)
+			}
+			if shifted[tc.songID].Score <= plain[tc.songID].Score {
+				t.Errorf("shift search did not improve the score of the true song (%.0f <= %.0f)",
+					shifted[tc.songID].Score, plain[tc.songID].Score)
+			}
+		})
+	}
+}
+
+func TestShiftSearchKeepsUnshiftedMatches(t *testing.T) {
+	index, songs := buildScoringIndex(t)
+	clipSamples := int(scoringClipLength * scoringSampleRate)
+	offset := 4 * scoringSampleRate
+
+	variants, err := core.ShiftedSampleFingerprints(songs[0][offset:offset+clipSamples], scoringClipLength, scoringSampleRate, core.DefaultShiftSearch())
+	if err != nil {
+		t.Fatalf("ShiftedSampleFingerprints failed: %v", err)
+	}
+	if got := topSong(core.ScoreShiftedMatches(variants, index)); got != 1 {
+		t.Fatalf("shift search matched song %d, want 1", got)
+	}
+}
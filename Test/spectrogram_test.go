@@ -0,0 +1,245 @@
+package core_test
+
+import (
+	"fmt"
+	"image/png"
+	"math"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"reflect"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"testing"
+)
+
+// one FFT bin after downsampling by 4: 44100 / 4 / 1024
+const binHz = 44100.0 / 4 / 1024
+
+func TestExtractPeaksFindsTone(t *testing.T) {
+	const freq = 1000.0
+	tone := fixtures.Tone(freq, 3, 0.5, fixtures.SampleRate)
+
+	spectrogram, err := core.Spectrogram(tone, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("Spectrogram failed: %v", err)
+	}
+
+	peaks := core.ExtractPeaks(spectrogram, 3, fixtures.SampleRate)
+	if len(peaks) == 0 {
+		t.Fatal("no peaks extracted from a pure tone")
+	}
+
+	for _, peak := range peaks {
+		if math.Abs(peak.Freq-freq) > binHz {
+			t.Fatalf("peak at %.1f Hz (t=%.2fs), want %.0f Hz ± one bin", peak.Freq, peak.Time, freq)
+		}
+	}
+}
+
+func TestExtractPeaksFollowsChirp(t *testing.T) {
+	chirp := fixtures.Chirp(300, 2000, 4, 0.5, fixtures.SampleRate)
+
+	spectrogram, err := core.Spectrogram(chirp, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("Spectrogram failed: %v", err)
+	}
+
+	peaks := core.ExtractPeaks(spectrogram, 4, fixtures.SampleRate)
+	if len(peaks) < 10 {
+		t.Fatalf("only %d peaks extracted from the chirp", len(peaks))
+	}
+
+	for _, peak := range peaks {
+		want := 300 + (2000-300)*peak.Time/4
+		// the frame covers ~93 ms of the sweep, on top of the bin width
+		if math.Abs(peak.Freq-want) > 50 {
+			t.Errorf("peak at %.1f Hz (t=%.2fs), want about %.0f Hz", peak.Freq, peak.Time, want)
+		}
+	}
+}
+
+func TestSpectrogramDecibelNormalisation(t *testing.T) {
+	tone := fixtures.Tone(440, 2, 0.1, fixtures.SampleRate)
+	opts := core.SpectrogramOptions{Magnitude: core.DecibelMagnitude, NormalizeFrames: true}
+
+	spectrogram, err := core.SpectrogramWithOptions(tone, fixtures.SampleRate, opts)
+	if err != nil {
+		t.Fatalf("SpectrogramWithOptions failed: %v", err)
+	}
+
+	for i, frame := range spectrogram {
+		loudest := 0.0
+		for _, value := range frame {
+			if value < 0 {
+				t.Fatalf("frame %d has a negative dB value %f", i, value)
+			}
+			loudest = math.Max(loudest, value)
+		}
+		// normalised to 0 dB, lifted by the 100 dB floor
+		if math.Abs(loudest-100) > 1e-9 {
+			t.Fatalf("frame %d peaks at %f, want 100", i, loudest)
+		}
+	}
+}
+
+func TestPerceptualBandsKeepPeakFrequency(t *testing.T) {
+	const freq = 1500.0
+	tone := fixtures.Tone(freq, 3, 0.5, fixtures.SampleRate)
+
+	for _, scale := range []core.FrequencyScale{core.MelFrequency, core.BarkFrequency} {
+		opts := core.SpectrogramOptions{Frequency: scale, Bands: 64, Magnitude: core.LogMagnitude}
+
+		binFreqs := opts.BinFrequencies(fixtures.SampleRate)
+		if len(binFreqs) != 64 {
+			t.Fatalf("%s: got %d band frequencies, want 64", scale, len(binFreqs))
+		}
+		for i := 1; i < len(binFreqs); i++ {
+			if binFreqs[i] <= binFreqs[i-1] {
+				t.Fatalf("%s: band centres are not increasing at %d", scale, i)
+			}
+		}
+
+		spectrogram, err := core.SpectrogramWithOptions(tone, fixtures.SampleRate, opts)
+		if err != nil {
+			t.Fatalf("%s: SpectrogramWithOptions failed: %v", scale, err)
+		}
+		if len(spectrogram[0]) != 64 {
+			t.Fatalf("%s: got %d columns, want 64", scale, len(spectrogram[0]))
+		}
+
+		peaks := core.ExtractPeaksWithOptions(spectrogram, 3, fixtures.SampleRate, opts)
+		if len(peaks) == 0 {
+			t.Fatalf("%s: no peaks extracted", scale)
+		}
+
+		band := core.NearestBin(binFreqs, freq)
+		width := (binFreqs[min(band+1, len(binFreqs)-1)] - binFreqs[max(band-1, 0)]) / 2
+		for _, peak := range peaks {
+			if math.Abs(peak.Freq-freq) > width {
+				t.Fatalf("%s: peak at %.1f Hz, want %.0f Hz ± %.0f", scale, peak.Freq, freq, width)
+			}
+		}
+	}
+}
+
+// referenceSpectrogram is the frame loop Spectrogram ran before it had workers: a
+// fresh frame and FFT per window.
+func referenceSpectrogram(t *testing.T, samples []float64, sampleRate int) [][]float64 {
+	filtered := core.LowPassFilter(5000, float64(sampleRate), samples)
+	downsampled, err := core.Downsample(filtered, sampleRate, sampleRate/4)
+	if err != nil {
+		t.Fatalf("Downsample failed: %v", err)
+	}
+
+	const size, hop = 1024, 512
+	var spectrogram [][]float64
+	for start := 0; start+size <= len(downsampled); start += hop {
+		frame := make([]float64, size)
+		for j := range frame {
+			frame[j] = downsampled[start+j] * (0.5 - 0.5*math.Cos(2*math.Pi*float64(j)/float64(size-1)))
+		}
+		spectrum := core.FFT(frame)
+		magnitude := make([]float64, size/2)
+		for j := range magnitude {
+			magnitude[j] = cmplx.Abs(spectrum[j])
+		}
+		spectrogram = append(spectrogram, magnitude)
+	}
+	return spectrogram
+}
+
+func TestParallelSpectrogramIsDeterministic(t *testing.T) {
+	song := fixtures.Song(3, 12, fixtures.SampleRate)
+	want := referenceSpectrogram(t, song, fixtures.SampleRate)
+
+	for _, workers := range []int{0, 1, 3, 7, core.AllProcs} {
+		spectrogram, err := core.SpectrogramWithOptions(song, fixtures.SampleRate, core.SpectrogramOptions{Workers: workers})
+		if err != nil {
+			t.Fatalf("SpectrogramWithOptions failed: %v", err)
+		}
+		if !reflect.DeepEqual(spectrogram, want) {
+			t.Fatalf("%d workers: spectrogram differs from the sequential FFT", workers)
+		}
+	}
+
+	cfg := core.DefaultConfig()
+	sequential, err := cfg.GenerateFingerprintsFromSamples(song, fixtures.SampleRate, 1)
+	if err != nil {
+		t.Fatalf("GenerateFingerprintsFromSamples failed: %v", err)
+	}
+	cfg.Spectrogram.Workers = core.AllProcs
+	parallel, err := cfg.GenerateFingerprintsFromSamples(song, fixtures.SampleRate, 1)
+	if err != nil {
+		t.Fatalf("GenerateFingerprintsFromSamples failed: %v", err)
+	}
+	if !reflect.DeepEqual(parallel, sequential) {
+		t.Fatalf("parallel fingerprints differ: %d vs %d", len(parallel), len(sequential))
+	}
+}
+
+func BenchmarkSpectrogram(b *testing.B) {
+	song := fixtures.Song(1, 60, fixtures.SampleRate)
+
+	for _, workers := range []int{1, core.AllProcs} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			opts := core.SpectrogramOptions{Workers: workers}
+			for i := 0; i < b.N; i++ {
+				if _, err := core.SpectrogramWithOptions(song, fixtures.SampleRate, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMagnitudesToImage(t *testing.T) {
+	spectrogram := [][]float64{
+		{0, 1, 2},
+		{4, 3, 0},
+	}
+	decode := func(opts core.SpectrogramOptions) []uint8 {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "spectrogram.png")
+		if err := core.MagnitudesToImage(spectrogram, opts, path); err != nil {
+			t.Fatalf("MagnitudesToImage failed: %v", err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open the image: %v", err)
+		}
+		defer file.Close()
+		img, err := png.Decode(file)
+		if err != nil {
+			t.Fatalf("failed to decode the image: %v", err)
+		}
+
+		// one row per frame, one column per bin
+		if bounds := img.Bounds(); bounds.Dx() != 3 || bounds.Dy() != 2 {
+			t.Fatalf("image is %dx%d, want 3x2", bounds.Dx(), bounds.Dy())
+		}
+		var pixels []uint8
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 3; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				pixels = append(pixels, uint8(r>>8))
+			}
+		}
+		return pixels
+	}
+
+	// linear magnitudes are scaled by the loudest value alone
+	if got, want := decode(core.SpectrogramOptions{}), []uint8{0, 63, 127, 255, 191, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("linear image %v, want %v", got, want)
+	}
+
+	// log scaled ones are stretched between their minimum and maximum
+	spectrogram[0][0] = -4
+	if got, want := decode(core.SpectrogramOptions{Magnitude: core.DecibelMagnitude}), []uint8{0, 159, 191, 255, 223, 127}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decibel image %v, want %v", got, want)
+	}
+
+	if err := core.MagnitudesToImage(nil, core.SpectrogramOptions{}, filepath.Join(t.TempDir(), "empty.png")); err == nil {
+		t.Error("rendered an empty spectrogram")
+	}
+}
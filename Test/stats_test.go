@@ -0,0 +1,65 @@
+package core_test
+
+import (
+	"fmt"
+	"shazoom/db"
+	"shazoom/models"
+	"testing"
+)
+
+func TestStoreStats(t *testing.T) {
+	memory := db.NewMemoryClient()
+
+	sizes := []int{100, 0, 120, 5, 110}
+	ids := make([]uint32, len(sizes))
+	for i, size := range sizes {
+		id, err := memory.RegisterSong(fmt.Sprintf("song %d", i), "shazoom", "")
+		if err != nil {
+			t.Fatalf("RegisterSong failed: %v", err)
+		}
+		ids[i] = id
+
+		fingerprints := map[int64]models.Couple{}
+		for f := 0; f < size; f++ {
+			fingerprints[int64(1000*(i+1)+f)] = models.Couple{AnchorTime: uint32(f), SongId: id}
+		}
+		// every song also lands in one shared bucket
+		if size > 0 {
+			fingerprints[7] = models.Couple{AnchorTime: 999999, SongId: id}
+		}
+		memory.StoreFingerprints(fingerprints)
+	}
+
+	// a song deleted without its fingerprints
+	memory.StoreFingerprints(map[int64]models.Couple{1: {AnchorTime: 1, SongId: 4242}, 2: {AnchorTime: 2, SongId: 4242}})
+
+	stats, err := memory.Stats(3)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+
+	if stats.Songs != 5 || stats.Fingerprints != 335+4+2 || stats.OrphanedFingerprints != 2 {
+		t.Errorf("got %d songs, %d fingerprints, %d orphaned", stats.Songs, stats.Fingerprints, stats.OrphanedFingerprints)
+	}
+	if stats.MinPerSong != 0 || stats.MedianPerSong != 101 || stats.MaxPerSong != 121 {
+		t.Errorf("per song min/median/max = %d/%d/%d, want 0/101/121", stats.MinPerSong, stats.MedianPerSong, stats.MaxPerSong)
+	}
+	if len(stats.TopAddresses) != 3 || stats.TopAddresses[0] != (db.AddressCount{Address: 7, Count: 4}) {
+		t.Errorf("top addresses = %+v, want address 7 with 4 fingerprints first", stats.TopAddresses)
+	}
+
+	sparse := stats.SparseSongs(50, 0.1)
+	if len(sparse) != 2 || sparse[0].SongID != ids[1] || sparse[1].SongID != ids[3] {
+		t.Errorf("sparse songs = %+v, want songs %d and %d", sparse, ids[1], ids[3])
+	}
+	if sparse := stats.SparseSongs(0, 0.5); len(sparse) != 2 {
+		t.Errorf("half the median flags %d songs, want 2", len(sparse))
+	}
+
+	if stats, err := memory.Stats(0); err != nil || len(stats.TopAddresses) != 0 {
+		t.Errorf("Stats(0) = %d top addresses, %v; want none and no error", len(stats.TopAddresses), err)
+	}
+	if _, err := memory.Stats(-1); err == nil {
+		t.Error("Stats accepted a negative number of top addresses")
+	}
+}
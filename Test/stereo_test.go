@@ -0,0 +1,138 @@
+package core_test
+
+import (
+	"fmt"
+	"shazoom/Test/fixtures"
+	"shazoom/core"
+	"shazoom/db"
+	"testing"
+)
+
+// widenedSong pans two melodies of one song hard left and right, with a little of
+// each bleeding into the other side, like a stereo mix with a wide arrangement.
+func widenedSong(seed int64, seconds float64) (left, right []float64) {
+	first := fixtures.Song(seed, seconds, fixtures.SampleRate)
+	second := fixtures.Song(seed+1000, seconds, fixtures.SampleRate)
+
+	left = make([]float64, len(first))
+	right = make([]float64, len(first))
+	for i := range first {
+		left[i] = first[i] + 0.2*second[i]
+		right[i] = second[i] + 0.2*first[i]
+	}
+	return left, right
+}
+
+func TestTaggedChannelsNeedAddressV2(t *testing.T) {
+	cfg := core.DefaultConfig()
+	cfg.Channels = core.TaggedChannels
+
+	if _, err := cfg.GenerateFingerprintsFromSamples(fixtures.Song(1, 3, fixtures.SampleRate), fixtures.SampleRate, 1); err == nil {
+		t.Fatal("tagged channels fingerprinted into AddressV1 addresses")
+	}
+
+	cfg.Address = core.AddressV2
+	fingerprints, err := cfg.GenerateFingerprintsFromSamples(fixtures.Song(1, 3, fixtures.SampleRate), fixtures.SampleRate, 1)
+	if err != nil {
+		t.Fatalf("GenerateFingerprintsFromSamples failed: %v", err)
+	}
+
+	// a mono signal is hashed under both channel tags
+	tags := map[int]int{}
+	for address := range fingerprints {
+		_, _, _, extra := core.UnpackAddressV2(address)
+		tags[extra]++
+	}
+	if len(tags) != 2 || tags[1] != tags[2] {
+		t.Errorf("fingerprints per tag = %v, want the same number under tags 1 and 2", tags)
+	}
+}
+
+func TestChannelStrategiesMatchMonoQueries(t *testing.T) {
+	const songs = 6
+	const length = 20.0
+	const clipLength = 5.0
+
+	stereo := make([][2][]float64, songs)
+	for i := range stereo {
+		stereo[i][0], stereo[i][1] = widenedSong(int64(i+1), length)
+	}
+
+	strategies := []core.ChannelStrategy{core.SeparateChannels, core.MonoDownmix, core.MidSide, core.TaggedChannels}
+	scores := map[core.ChannelStrategy]float64{}
+
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			cfg := core.DefaultConfig()
+			cfg.Channels = strategy
+			cfg.Address = core.AddressV2
+
+			memory := db.NewMemoryClient()
+			indexer := &core.Indexer{DB: memory, Config: cfg}
+			for i, channels := range stereo {
+				song := db.Song{Title: fmt.Sprintf("song %d", i+1)}
+				if _, err := indexer.IndexChannels(channels[:], fixtures.SampleRate, song); err != nil {
+					t.Fatalf("IndexChannels failed: %v", err)
+				}
+			}
+
+			matcher := &core.Matcher{DB: memory, Config: cfg}
+			clipSamples := int(clipLength * fixtures.SampleRate)
+			for i, channels := range stereo {
+				offset := (i + 3) * fixtures.SampleRate
+				// what ReformatWav(…, 1) makes of a stereo recording
+				mono := make([]float64, clipSamples)
+				for n := range mono {
+					mono[n] = (channels[0][offset+n] + channels[1][offset+n]) / 2
+				}
+
+				matches, _, err := matcher.FindMatches(fixtures.WithNoise(mono, 10, int64(i)), clipLength, fixtures.SampleRate)
+				if err != nil {
+					t.Fatalf("FindMatches failed: %v", err)
+				}
+				if len(matches) == 0 || matches[0].SongId != uint32(i+1) {
+					t.Fatalf("song %d: mono query matched %v", i+1, matches)
+				}
+				scores[strategy] += matches[0].Score
+			}
+			t.Logf("mean score of the true song: %.1f", scores[strategy]/songs)
+		})
+	}
+
+	if scores[core.MonoDownmix] <= scores[core.SeparateChannels] {
+		t.Errorf("a downmixed index scored mono queries %.0f, no better than separate channels (%.0f)",
+			scores[core.MonoDownmix], scores[core.SeparateChannels])
+	}
+}
+
+func TestTaggedChannelsMatchStereoQueries(t *testing.T) {
+	cfg := core.DefaultConfig()
+	cfg.Channels = core.TaggedChannels
+	cfg.Address = core.AddressV2
+
+	memory := db.NewMemoryClient()
+	indexer := &core.Indexer{DB: memory, Config: cfg}
+	var target [2][]float64
+	for i := 0; i < 4; i++ {
+		left, right := widenedSong(int64(i+10), 15)
+		if _, err := indexer.IndexChannels([][]float64{left, right}, fixtures.SampleRate, db.Song{Title: fmt.Sprintf("song %d", i+1)}); err != nil {
+			t.Fatalf("IndexChannels failed: %v", err)
+		}
+		if i == 2 {
+			target = [2][]float64{left, right}
+		}
+	}
+
+	offset := 5 * fixtures.SampleRate
+	clip := [][]float64{
+		fixtures.WithNoise(target[0][offset:offset+4*fixtures.SampleRate], 10, 1),
+		fixtures.WithNoise(target[1][offset:offset+4*fixtures.SampleRate], 10, 2),
+	}
+	matches, _, err := (&core.Matcher{DB: memory, Config: cfg}).FindMatchesInChannels(clip, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("FindMatchesInChannels failed: %v", err)
+	}
+	if len(matches) == 0 || matches[0].SongId != 3 {
+		t.Fatalf("stereo query matched %v, want song 3", matches)
+	}
+}
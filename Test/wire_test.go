@@ -0,0 +1,182 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"shazoom/Test/fixtures"
+	"shazoom/api"
+	"shazoom/core"
+	"shazoom/db"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSampleWireFormatRoundTrip(t *testing.T) {
+	cfg := core.DefaultConfig()
+	clip := fixtures.Song(5, 5, fixtures.SampleRate)
+
+	sample, err := cfg.SampleFingerprints([][]float64{clip}, fixtures.SampleRate)
+	if err != nil {
+		t.Fatalf("SampleFingerprints failed: %v", err)
+	}
+
+	encoded := core.EncodeSampleFingerprints(sample, cfg.FingerprintVersion())
+	t.Logf("%d hashes in %d bytes", len(sample), len(encoded))
+	if len(encoded) > 8*len(sample)+16 {
+		t.Errorf("%d bytes for %d hashes, more than 8 a hash", len(encoded), len(sample))
+	}
+
+	decoded, version, err := core.DecodeSampleFingerprints(encoded)
+	if err != nil {
+		t.Fatalf("DecodeSampleFingerprints failed: %v", err)
+	}
+	if version != cfg.FingerprintVersion() || !reflect.DeepEqual(decoded, sample) {
+		t.Fatalf("decoded %d hashes with version %s, want %d with %s", len(decoded), version, len(sample), cfg.FingerprintVersion())
+	}
+
+	for _, damaged := range [][]byte{encoded[:len(encoded)-1], append(bytes.Clone(encoded), 0), []byte("RIFF")} {
+		if _, _, err := core.DecodeSampleFingerprints(damaged); err == nil {
+			t.Errorf("decoded a damaged message of %d bytes", len(damaged))
+		}
+	}
+}
+
+func TestFingerprintVersion(t *testing.T) {
+	base := core.DefaultConfig()
+
+	for name, change := range map[string]func(*core.Config){
+		"workers":                   func(c *core.Config) { c.Spectrogram.Workers = core.AllProcs },
+		"stop words":                func(c *core.Config) { c.StopWords.MaxDocumentFrequency = 0.3 },
+		"empty channel strategy":    func(c *core.Config) { c.Channels = "" },
+		"empty pairing":             func(c *core.Config) { c.Pairing = "" },
+		"empty peak picker":         func(c *core.Config) { c.PeakPicker = "" },
+		"zero address version":      func(c *core.Config) { c.Address = 0 },
+		"default window size":       func(c *core.Config) { c.Spectrogram.WindowSize = 1024 },
+		"bands of a linear layout":  func(c *core.Config) { c.Spectrogram.Bands = 40 },
+		"local maxima, band peaks":  func(c *core.Config) { c.LocalMaxima.PeaksPerSecond = 50 },
+		"target zone, next peaks":   func(c *core.Config) { c.TargetZone.FanOut = 9 },
+		"target of no normalising":  func(c *core.Config) { c.Preprocess.TargetDb = -14 },
+		"silence of no silence cut": func(c *core.Config) { c.Preprocess.SilenceDb = -40 },
+	} {
+		same := base
+		change(&same)
+		if same.FingerprintVersion() != base.FingerprintVersion() {
+			t.Errorf("changing the %s changed the fingerprint version", name)
+		}
+	}
+
+	for name, change := range map[string]func(*core.Config){
+		"pairing":    func(c *core.Config) { c.Pairing = core.TargetZonePairing },
+		"address":    func(c *core.Config) { c.Address = core.AddressV2 },
+		"channels":   func(c *core.Config) { c.Channels = core.MonoDownmix },
+		"preprocess": func(c *core.Config) { c.Preprocess = core.DefaultPreprocessOptions() },
+		"window":     func(c *core.Config) { c.Spectrogram.WindowSize = 2048 },
+		"local maxima": func(c *core.Config) {
+			c.PeakPicker = core.LocalMaximaPeaks
+			c.LocalMaxima.PeaksPerSecond = 50
+		},
+		"target zone": func(c *core.Config) {
+			c.Pairing = core.TargetZonePairing
+			c.TargetZone.FanOut = 9
+		},
+	} {
+		changed := base
+		change(&changed)
+		if changed.FingerprintVersion() == base.FingerprintVersion() {
+			t.Errorf("changing the %s kept the fingerprint version", name)
+		}
+	}
+
+	// settings that only count under a strategy count once it is chosen
+	zone := base
+	zone.Pairing = core.TargetZonePairing
+	wider := zone
+	wider.TargetZone.FanOut = 9
+	if wider.FingerprintVersion() == zone.FingerprintVersion() {
+		t.Error("changing the fan-out of target zone pairing kept the fingerprint version")
+	}
+}
+
+func TestRecognizeHashesEndpoint(t *testing.T) {
+	catalogue := fixtures.NewCatalogue()
+	if err := catalogue.AddSongs(30, 4, 15); err != nil {
+		t.Fatalf("AddSongs failed: %v", err)
+	}
+	memory, target := catalogue.DB, catalogue.Songs[2]
+
+	server := httptest.NewServer(api.NewServer(memory).Handler())
+	defer server.Close()
+
+	post := func(cfg core.Config, clip []float64) (int, []byte) {
+		sample, err := cfg.SampleFingerprints([][]float64{clip}, fixtures.SampleRate)
+		if err != nil {
+			t.Fatalf("SampleFingerprints failed: %v", err)
+		}
+		resp, err := http.Post(server.URL+"/recognize/hashes", "application/octet-stream",
+			bytes.NewReader(core.EncodeSampleFingerprints(sample, cfg.FingerprintVersion())))
+		if err != nil {
+			t.Fatalf("POST /recognize/hashes failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		return resp.StatusCode, body.Bytes()
+	}
+
+	offset := 6 * fixtures.SampleRate
+	clip := fixtures.WithNoise(target[offset:offset+5*fixtures.SampleRate], 10, 1)
+
+	status, body := post(core.DefaultConfig(), clip)
+	var response struct {
+		Matches []struct {
+			SongID      uint32 `json:"song_id"`
+			TimestampMs uint32 `json:"timestamp_ms"`
+		} `json:"matches"`
+	}
+	json.Unmarshal(body, &response)
+	if status != http.StatusOK || len(response.Matches) == 0 || response.Matches[0].SongID != 3 {
+		t.Fatalf("status %d, body %s, want song 3 first", status, body)
+	}
+	if got := response.Matches[0].TimestampMs; got < 5900 || got > 6100 {
+		t.Errorf("clip placed at %dms, want 6000ms", got)
+	}
+
+	other := core.DefaultConfig()
+	other.Pairing = core.TargetZonePairing
+	if status, body := post(other, clip); status != http.StatusConflict {
+		t.Errorf("a sample of another fingerprint version returned %d: %s", status, body)
+	}
+
+	resp, err := http.Post(server.URL+"/recognize/hashes", "application/octet-stream", bytes.NewReader([]byte("not hashes")))
+	if err != nil {
+		t.Fatalf("POST /recognize/hashes failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("garbage returned %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestRecognizeHashesBodyErrors(t *testing.T) {
+	handler := api.NewServer(db.NewMemoryClient()).Handler()
+
+	cases := []struct {
+		name    string
+		request *http.Request
+		want    int
+	}{
+		{"too large", httptest.NewRequest(http.MethodPost, "/recognize/hashes", bytes.NewReader(make([]byte, 2<<20))), http.StatusRequestEntityTooLarge},
+		{"broken connection", httptest.NewRequest(http.MethodPost, "/recognize/hashes", iotest.ErrReader(errors.New("connection reset"))), http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, c.request)
+		if recorder.Code != c.want {
+			t.Errorf("%s: status %d, want %d: %s", c.name, recorder.Code, c.want, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Package api serves the catalogue over HTTP. Every response is JSON; failures come
+back as {"error": "..."} with a 4xx or 5xx status.
+*/
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"shazoom/core"
+	"shazoom/db"
+	"shazoom/utils"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+
+	// maxHashesBody is the largest fingerprint message accepted, minutes of audio.
+	maxHashesBody = 1 << 20
+	maxMatches    = 10
+)
+
+// Server answers API requests from a DBClient it doesn't own; closing it is up to the
+// caller. Config is the one the catalogue was fingerprinted with.
+type Server struct {
+	DB     db.DBClient
+	Config core.Config
+}
+
+func NewServer(dbClient db.DBClient) *Server {
+	return &Server{DB: dbClient, Config: core.DefaultConfig()}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /songs", s.listSongs)
+	mux.HandleFunc("POST /recognize/hashes", s.recognizeHashes)
+	return mux
+}
+
+type songResponse struct {
+	ID           uint32    `json:"id"`
+	Title        string    `json:"title"`
+	Artist       string    `json:"artist"`
+	YouTubeID    string    `json:"youtube_id,omitempty"`
+	Album        string    `json:"album,omitempty"`
+	Duration     float64   `json:"duration"`
+	ISRC         string    `json:"isrc,omitempty"`
+	ReleaseYear  int       `json:"release_year,omitempty"`
+	Genre        string    `json:"genre,omitempty"`
+	SourcePath   string    `json:"source_path,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	Fingerprints int       `json:"fingerprints"`
+}
+
+type songPageResponse struct {
+	Songs      []songResponse `json:"songs"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// listSongs serves GET /songs?cursor=&limit=&sort=, one page of db.ListSongs.
+func (s *Server) listSongs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := defaultPageSize
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxPageSize {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		limit = parsed
+	}
+
+	sort := db.SortByID
+	if value := query.Get("sort"); value != "" {
+		parsed, err := db.ParseSongSort(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		sort = parsed
+	}
+
+	page, err := s.DB.ListSongs(query.Get("cursor"), limit, sort)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := songPageResponse{Songs: make([]songResponse, 0, len(page.Songs)), NextCursor: page.NextCursor}
+	for _, song := range page.Songs {
+		response.Songs = append(response.Songs, songResponse{
+			ID:           song.ID,
+			Title:        song.Title,
+			Artist:       song.Artist,
+			YouTubeID:    song.YouTubeID,
+			Album:        song.Album,
+			Duration:     song.Duration,
+			ISRC:         song.ISRC,
+			ReleaseYear:  song.ReleaseYear,
+			Genre:        song.Genre,
+			SourcePath:   song.SourcePath,
+			CreatedAt:    song.CreatedAt,
+			Fingerprints: song.Fingerprints,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+type matchResponse struct {
+	SongID      uint32  `json:"song_id"`
+	Title       string  `json:"title"`
+	Artist      string  `json:"artist"`
+	YouTubeID   string  `json:"youtube_id,omitempty"`
+	TimestampMs uint32  `json:"timestamp_ms"`
+	ToleranceMs uint32  `json:"tolerance_ms"`
+	Score       float64 `json:"score"`
+}
+
+type recognizeResponse struct {
+	Matches []matchResponse `json:"matches"`
+	TookMs  int64           `json:"took_ms"`
+}
+
+/*
+recognizeHashes serves POST /recognize/hashes. The body is a sample fingerprinted on
+the client and packed with core.EncodeSampleFingerprints; samples made with another
+fingerprint version than the catalogue's are refused with 409 Conflict.
+*/
+func (s *Server) recognizeHashes(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHashesBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("fingerprints must fit in %d bytes", maxHashesBody))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error reading fingerprints: %w", err))
+		return
+	}
+
+	sample, version, err := core.DecodeSampleFingerprints(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	matcher := &core.Matcher{DB: s.DB, Config: s.Config}
+	matches, took, err := matcher.FindMatchesForClient(sample, version)
+	if errors.Is(err, core.ErrIncompatibleFingerprints) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(matches) > maxMatches {
+		matches = matches[:maxMatches]
+	}
+	response := recognizeResponse{Matches: make([]matchResponse, 0, len(matches)), TookMs: took.Milliseconds()}
+	for _, match := range matches {
+		response.Matches = append(response.Matches, matchResponse{
+			SongID:      match.SongId,
+			Title:       match.SongTitle,
+			Artist:      match.SongArtist,
+			YouTubeID:   match.YoutubeID,
+			TimestampMs: match.Timestamp,
+			ToleranceMs: match.TimestampTolerance,
+			Score:       match.Score,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		utils.GetLogger().Error(fmt.Sprintf("failed to write response: %v", err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		utils.GetLogger().Error(fmt.Sprintf("request failed: %v", err))
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
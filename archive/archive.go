@@ -0,0 +1,121 @@
+/*
+Package archive moves a whole index between DBClients through a portable, versioned
+binary file, so a catalogue can go from one environment to another without a
+Postgres dump.
+
+A file is a magic string and format version followed by checksummed blocks:
+
+	file   = "SHZIDX" version:uint16 block*
+	block  = kind:byte length:uvarint payload crc:uint32
+
+All fixed size integers are little endian, crc is the CRC-32 (IEEE) of the payload.
+The blocks come in this order:
+
+	'H'  once, the Header as JSON: the fingerprint Config the index was built with
+	'S'  songs, a JSON array of song records, in song ID order
+	'F'  fingerprints, count:uvarint followed by count triples
+	'E'  once, last: songs:uvarint fingerprints:uvarint, to catch truncated files
+
+Fingerprints are sorted by song ID, address and anchor time and delta encoded as
+three uvarints per triple:
+
+	song delta | address delta | anchor time, or its delta when the address repeats
+
+The address delta is from the previous address of the same song, the full address
+after a song change. Every 'F' block starts from song 0, address 0, time 0, so each
+block decodes on its own and an import only ever holds one block in memory. Song IDs
+are those of the exporting store; the importer maps them onto the IDs its own store
+hands out.
+*/
+package archive
+
+import (
+	"errors"
+	"shazoom/core"
+	"shazoom/db"
+	"time"
+)
+
+// FormatVersion is the version Export writes. Import reads this version only.
+const FormatVersion = 1
+
+const magic = "SHZIDX"
+
+const (
+	blockHeader       = 'H'
+	blockSongs        = 'S'
+	blockFingerprints = 'F'
+	blockEnd          = 'E'
+)
+
+const (
+	// songsPerBlock and fingerprintsPerBlock bound what one block holds, and so what an
+	// import keeps in memory at a time.
+	songsPerBlock        = 1000
+	fingerprintsPerBlock = 20000
+	// maxBlockSize rejects a corrupt length before allocating for it.
+	maxBlockSize = 64 << 20
+)
+
+// ErrCorrupt is returned, wrapped, for a file that isn't a valid archive.
+var ErrCorrupt = errors.New("corrupt archive")
+
+// Header describes how the fingerprints in an archive were made. They only match
+// queries fingerprinted with the same Config.
+type Header struct {
+	FormatVersion int         `json:"format_version"`
+	Created       time.Time   `json:"created"`
+	Config        core.Config `json:"config"`
+	Songs         int         `json:"songs"`
+}
+
+// songRecord is a song as stored in an 'S' block.
+type songRecord struct {
+	ID            uint32    `json:"id"`
+	Title         string    `json:"title"`
+	Artist        string    `json:"artist"`
+	YouTubeID     string    `json:"youtube_id,omitempty"`
+	Album         string    `json:"album,omitempty"`
+	Duration      float64   `json:"duration,omitempty"`
+	ISRC          string    `json:"isrc,omitempty"`
+	ReleaseYear   int       `json:"release_year,omitempty"`
+	Genre         string    `json:"genre,omitempty"`
+	SourcePath    string    `json:"source_path,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	ContentHash   string    `json:"content_hash,omitempty"`
+	Fingerprinted bool      `json:"fingerprinted"`
+}
+
+func newSongRecord(song db.Song) songRecord {
+	return songRecord{
+		ID:            song.ID,
+		Title:         song.Title,
+		Artist:        song.Artist,
+		YouTubeID:     song.YouTubeID,
+		Album:         song.Album,
+		Duration:      song.Duration,
+		ISRC:          song.ISRC,
+		ReleaseYear:   song.ReleaseYear,
+		Genre:         song.Genre,
+		SourcePath:    song.SourcePath,
+		CreatedAt:     song.CreatedAt,
+		ContentHash:   song.ContentHash,
+		Fingerprinted: song.Fingerprinted,
+	}
+}
+
+// song is the record as a db.Song to register, without the exporting store's ID.
+func (r songRecord) song() db.Song {
+	return db.Song{
+		Title:       r.Title,
+		Artist:      r.Artist,
+		YouTubeID:   r.YouTubeID,
+		Album:       r.Album,
+		Duration:    r.Duration,
+		ISRC:        r.ISRC,
+		ReleaseYear: r.ReleaseYear,
+		Genre:       r.Genre,
+		SourcePath:  r.SourcePath,
+		ContentHash: r.ContentHash,
+	}
+}
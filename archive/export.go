@@ -0,0 +1,159 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"shazoom/core"
+	"shazoom/db"
+	"shazoom/models"
+	"time"
+)
+
+// ExportStats counts what Export wrote. Orphaned fingerprints belong to songs that are
+// no longer registered and are left out.
+type ExportStats struct {
+	Songs        int
+	Fingerprints int
+	Orphaned     int
+}
+
+// Export writes every song and fingerprint of dbClient to w. cfg is recorded in the
+// header and has to be the Config the index was built with.
+func Export(w io.Writer, dbClient db.DBClient, cfg core.Config) (ExportStats, error) {
+	var stats ExportStats
+	out := bufio.NewWriter(w)
+
+	total, err := dbClient.TotalSongs()
+	if err != nil {
+		return stats, fmt.Errorf("error counting songs: %w", err)
+	}
+
+	if _, err := out.WriteString(magic); err != nil {
+		return stats, err
+	}
+	if err := binary.Write(out, binary.LittleEndian, uint16(FormatVersion)); err != nil {
+		return stats, err
+	}
+
+	header, err := json.Marshal(Header{FormatVersion: FormatVersion, Created: time.Now().UTC(), Config: cfg, Songs: total})
+	if err != nil {
+		return stats, err
+	}
+	if err := writeBlock(out, blockHeader, header); err != nil {
+		return stats, err
+	}
+
+	exported := make(map[uint32]bool)
+	cursor := ""
+	for {
+		page, err := dbClient.ListSongs(cursor, songsPerBlock, db.SortByID)
+		if err != nil {
+			return stats, fmt.Errorf("error listing songs: %w", err)
+		}
+		if len(page.Songs) > 0 {
+			records := make([]songRecord, len(page.Songs))
+			for i, song := range page.Songs {
+				records[i] = newSongRecord(song.Song)
+				exported[song.ID] = true
+			}
+			payload, err := json.Marshal(records)
+			if err != nil {
+				return stats, err
+			}
+			if err := writeBlock(out, blockSongs, payload); err != nil {
+				return stats, err
+			}
+			stats.Songs += len(records)
+		}
+
+		cursor = page.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+
+	encoder := newTripleEncoder()
+	flush := func() error {
+		if encoder.count == 0 {
+			return nil
+		}
+		err := writeBlock(out, blockFingerprints, encoder.payload())
+		encoder = newTripleEncoder()
+		return err
+	}
+
+	err = dbClient.WalkFingerprints(func(address int64, couple models.Couple) error {
+		if !exported[couple.SongId] {
+			stats.Orphaned++
+			return nil
+		}
+		encoder.add(couple.SongId, address, couple.AnchorTime)
+		stats.Fingerprints++
+		if encoder.count == fingerprintsPerBlock {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("error exporting fingerprints: %w", err)
+	}
+	if err := flush(); err != nil {
+		return stats, err
+	}
+
+	end := binary.AppendUvarint(nil, uint64(stats.Songs))
+	end = binary.AppendUvarint(end, uint64(stats.Fingerprints))
+	if err := writeBlock(out, blockEnd, end); err != nil {
+		return stats, err
+	}
+
+	return stats, out.Flush()
+}
+
+func writeBlock(w io.Writer, kind byte, payload []byte) error {
+	block := []byte{kind}
+	block = binary.AppendUvarint(block, uint64(len(payload)))
+	block = append(block, payload...)
+	block = binary.LittleEndian.AppendUint32(block, crc32.ChecksumIEEE(payload))
+	_, err := w.Write(block)
+	return err
+}
+
+// tripleEncoder delta encodes the fingerprints of one 'F' block.
+type tripleEncoder struct {
+	buf     []byte
+	count   int
+	song    uint32
+	address int64
+	time    uint32
+}
+
+func newTripleEncoder() *tripleEncoder {
+	return &tripleEncoder{}
+}
+
+// add appends a fingerprint. Fingerprints have to come in the order WalkFingerprints
+// returns them.
+func (e *tripleEncoder) add(song uint32, address int64, anchorTime uint32) {
+	if song != e.song {
+		e.address, e.time = 0, 0
+	}
+	e.buf = binary.AppendUvarint(e.buf, uint64(song-e.song))
+	e.buf = binary.AppendUvarint(e.buf, uint64(address-e.address))
+	if address == e.address && e.count > 0 && song == e.song {
+		e.buf = binary.AppendUvarint(e.buf, uint64(anchorTime-e.time))
+	} else {
+		e.buf = binary.AppendUvarint(e.buf, uint64(anchorTime))
+	}
+
+	e.song, e.address, e.time = song, address, anchorTime
+	e.count++
+}
+
+func (e *tripleEncoder) payload() []byte {
+	return append(binary.AppendUvarint(nil, uint64(e.count)), e.buf...)
+}
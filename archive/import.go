@@ -0,0 +1,261 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"shazoom/core"
+	"shazoom/db"
+	"shazoom/models"
+)
+
+// ImportStats counts what Import did. Songs the store already had fully fingerprinted
+// are skipped together with their fingerprints.
+type ImportStats struct {
+	Header       Header
+	Songs        int
+	SkippedSongs int
+	Fingerprints int
+}
+
+// ImportOptions say what the target store expects of an archive.
+type ImportOptions struct {
+	// Config is the one the target store is queried with.
+	Config core.Config
+	// Force imports an archive fingerprinted with another FingerprintVersion than
+	// Config, whose fingerprints then won't match any query.
+	Force bool
+}
+
+func DefaultImportOptions() ImportOptions {
+	return ImportOptions{Config: core.DefaultConfig()}
+}
+
+/*
+Import reads an archive written by Export into dbClient one block at a time. Songs get
+new IDs from the store and keep nothing of the exporting store but their metadata.
+Like an ingest, each song is only marked as fingerprinted once the whole archive has
+been read, so an import cut short can simply be run again.
+
+An archive whose header Config has another FingerprintVersion than opts.Config fails
+with core.ErrIncompatibleFingerprints before anything is stored, unless opts.Force is set.
+*/
+func Import(r io.Reader, dbClient db.DBClient, opts ImportOptions) (ImportStats, error) {
+	var stats ImportStats
+	in := bufio.NewReader(r)
+
+	prefix := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(in, prefix); err != nil {
+		return stats, fmt.Errorf("%w: reading the file header: %v", ErrCorrupt, err)
+	}
+	if string(prefix[:len(magic)]) != magic {
+		return stats, fmt.Errorf("%w: not a shazoom index archive", ErrCorrupt)
+	}
+	if version := binary.LittleEndian.Uint16(prefix[len(magic):]); version != FormatVersion {
+		return stats, fmt.Errorf("archive format version %d, this build reads version %d", version, FormatVersion)
+	}
+
+	// the IDs the exporting store's songs got here, 0 for the skipped ones
+	songIDs := make(map[uint32]uint32)
+	var toMark []uint32
+	var songRecords, triples int
+
+	for index := 0; ; index++ {
+		kind, payload, err := readBlock(in)
+		if err == io.EOF {
+			return stats, fmt.Errorf("%w: truncated after %d blocks", ErrCorrupt, index)
+		}
+		if err != nil {
+			return stats, err
+		}
+		if (index == 0) != (kind == blockHeader) {
+			return stats, fmt.Errorf("%w: block %d is %q, the header has to come first and only once", ErrCorrupt, index, kind)
+		}
+
+		switch kind {
+		case blockHeader:
+			if err := json.Unmarshal(payload, &stats.Header); err != nil {
+				return stats, fmt.Errorf("%w: header: %v", ErrCorrupt, err)
+			}
+			archived, expected := stats.Header.Config.FingerprintVersion(), opts.Config.FingerprintVersion()
+			if archived != expected && !opts.Force {
+				return stats, fmt.Errorf("%w: the archive was fingerprinted as %s, the store queries %s",
+					core.ErrIncompatibleFingerprints, archived, expected)
+			}
+
+		case blockSongs:
+			var records []songRecord
+			if err := json.Unmarshal(payload, &records); err != nil {
+				return stats, fmt.Errorf("%w: songs: %v", ErrCorrupt, err)
+			}
+			for _, record := range records {
+				songRecords++
+				songID, skip, err := registerSong(dbClient, record)
+				if err != nil {
+					return stats, err
+				}
+				if skip {
+					stats.SkippedSongs++
+					songIDs[record.ID] = 0
+					continue
+				}
+				songIDs[record.ID] = songID
+				stats.Songs++
+				if record.Fingerprinted {
+					toMark = append(toMark, songID)
+				}
+			}
+
+		case blockFingerprints:
+			count, stored, err := importFingerprints(dbClient, payload, songIDs)
+			if err != nil {
+				return stats, err
+			}
+			triples += count
+			stats.Fingerprints += stored
+
+		case blockEnd:
+			reader := bytes.NewReader(payload)
+			wantSongs, err1 := binary.ReadUvarint(reader)
+			wantTriples, err2 := binary.ReadUvarint(reader)
+			if err := errors.Join(err1, err2); err != nil {
+				return stats, fmt.Errorf("%w: end block: %v", ErrCorrupt, err)
+			}
+			if int(wantSongs) != songRecords || int(wantTriples) != triples {
+				return stats, fmt.Errorf("%w: read %d songs and %d fingerprints, the archive has %d and %d",
+					ErrCorrupt, songRecords, triples, wantSongs, wantTriples)
+			}
+
+			for _, songID := range toMark {
+				if err := dbClient.MarkSongFingerprinted(songID); err != nil {
+					return stats, fmt.Errorf("error marking song %d as fingerprinted: %w", songID, err)
+				}
+			}
+			return stats, nil
+
+		default:
+			return stats, fmt.Errorf("%w: unknown block kind %q", ErrCorrupt, kind)
+		}
+	}
+}
+
+// registerSong registers record or finds the song it duplicates, which is skipped if it
+// is already fully fingerprinted.
+func registerSong(dbClient db.DBClient, record songRecord) (songID uint32, skip bool, err error) {
+	songID, err = dbClient.RegisterOrGetSong(record.song())
+	if errors.Is(err, db.ErrSongExists) {
+		existing, found, err := dbClient.GetSongByID(songID)
+		if err != nil {
+			return 0, false, fmt.Errorf("error looking up existing song %d: %w", songID, err)
+		}
+		return songID, found && existing.Fingerprinted, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("error registering %q: %w", record.Title, err)
+	}
+	return songID, false, nil
+}
+
+// importFingerprints decodes an 'F' block and stores it in as few StoreFingerprints
+// calls as its repeated addresses allow. It returns the number of triples in the
+// block and how many of them were stored.
+func importFingerprints(dbClient db.DBClient, payload []byte, songIDs map[uint32]uint32) (int, int, error) {
+	reader := bytes.NewReader(payload)
+	count, err := binary.ReadUvarint(reader)
+	if err != nil || count > uint64(len(payload)) {
+		return 0, 0, fmt.Errorf("%w: fingerprint count", ErrCorrupt)
+	}
+
+	chunk := make(map[int64]models.Couple)
+	stored := 0
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := dbClient.StoreFingerprints(chunk); err != nil {
+			return fmt.Errorf("error storing fingerprints: %w", err)
+		}
+		stored += len(chunk)
+		chunk = make(map[int64]models.Couple)
+		return nil
+	}
+
+	var song uint32
+	var address int64
+	var anchorTime uint32
+	for i := uint64(0); i < count; i++ {
+		var fields [3]uint64
+		for f := range fields {
+			if fields[f], err = binary.ReadUvarint(reader); err != nil {
+				return 0, 0, fmt.Errorf("%w: fingerprint %d: %v", ErrCorrupt, i, err)
+			}
+		}
+		songDelta, addressDelta, timeField := fields[0], fields[1], fields[2]
+
+		if songDelta != 0 {
+			address, anchorTime = 0, 0
+		}
+		song += uint32(songDelta)
+		address += int64(addressDelta)
+		if i > 0 && songDelta == 0 && addressDelta == 0 {
+			anchorTime += uint32(timeField)
+		} else {
+			anchorTime = uint32(timeField)
+		}
+
+		songID, known := songIDs[song]
+		if !known {
+			return 0, 0, fmt.Errorf("%w: fingerprint of song %d, which the archive doesn't list", ErrCorrupt, song)
+		}
+		if songID == 0 {
+			continue
+		}
+
+		// a map holds one couple per address, store what we have before overwriting
+		if _, taken := chunk[address]; taken {
+			if err := flush(); err != nil {
+				return 0, 0, err
+			}
+		}
+		chunk[address] = models.Couple{AnchorTime: anchorTime, SongId: songID}
+	}
+	if reader.Len() != 0 {
+		return 0, 0, fmt.Errorf("%w: %d stray bytes after the fingerprints", ErrCorrupt, reader.Len())
+	}
+
+	if err := flush(); err != nil {
+		return 0, 0, err
+	}
+	return int(count), stored, nil
+}
+
+func readBlock(in *bufio.Reader) (byte, []byte, error) {
+	kind, err := in.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, err := binary.ReadUvarint(in)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: block length: %v", ErrCorrupt, err)
+	}
+	if length > maxBlockSize {
+		return 0, nil, fmt.Errorf("%w: %d byte block", ErrCorrupt, length)
+	}
+
+	payload := make([]byte, length+4)
+	if _, err := io.ReadFull(in, payload); err != nil {
+		return 0, nil, fmt.Errorf("%w: truncated %q block: %v", ErrCorrupt, kind, err)
+	}
+	payload, checksum := payload[:length], binary.LittleEndian.Uint32(payload[length:])
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch in a %q block", ErrCorrupt, kind)
+	}
+
+	return kind, payload, nil
+}
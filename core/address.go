@@ -0,0 +1,226 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"shazoom/db"
+)
+
+/*
+AddressVersion identifies how a fingerprint is laid out in its int64 address.
+
+AddressLegacy is what indexes built before addresses held bin indices contain: the
+AddressV1 layout with frequencies in units of 10 Hz, wrapped to 9 bits, and no version.
+Which bin such a value came from depends on the rate each song was decoded at, so
+legacy addresses can't be migrated, only rebuilt from the audio.
+
+AddressV1 is the original 32 bit layout (see PackAddress) tagged with its version. It
+caps bins at 511 and deltas at about 16 s.
+
+AddressV2 uses the rest of the BIGINT:
+
+	| 0 | triplet (1) | version (4) | reserved (6) | extra (8) | anchor bin (12) | target bin (12) | delta ms (20) |
+
+Bins go up to 4095, so a SpectrogramOptions.WindowSize of up to 8192 fits, deltas up
+to about 17 minutes, and the extra byte is free for a band or channel id. The layout
+alone doesn't change the spectrogram: with the default 1024 window the bins stay
+about 10.8 Hz wide, the finer resolution comes from the longer window. Every layout
+sets its own version bits, so they never collide and an index can be migrated in
+place with MigrateIndex.
+*/
+type AddressVersion uint8
+
+const (
+	AddressLegacy AddressVersion = 0
+	AddressV1     AddressVersion = 1
+	AddressV2     AddressVersion = 2
+)
+
+// ErrLegacyIndex is returned, wrapped, when the store holds AddressLegacy fingerprints,
+// which no query can match any more.
+var ErrLegacyIndex = errors.New("index predates bin-based addresses; delete its fingerprints and ingest the songs again")
+
+const (
+	addressVersionShift = 58
+	addressVersionMask  = 1<<4 - 1
+
+	v2FreqBits  = 12
+	v2DeltaBits = 20
+	v2ExtraBits = 8
+)
+
+// AddressVersionOf reads the layout version out of an address.
+func AddressVersionOf(address int64) AddressVersion {
+	return AddressVersion(address >> addressVersionShift & addressVersionMask)
+}
+
+// CheckIndex returns ErrLegacyIndex if dbClient holds any AddressLegacy fingerprint.
+// Those are the only addresses with no version bits set.
+func CheckIndex(dbClient db.DBClient) error {
+	legacy, err := dbClient.HasAddressesBetween(0, 1<<addressVersionShift)
+	if err != nil {
+		return fmt.Errorf("error checking the address layout of the index: %w", err)
+	}
+	if legacy {
+		return ErrLegacyIndex
+	}
+	return nil
+}
+
+// PackAddressV2 packs a pair of peaks with the AddressV2 layout.
+func PackAddressV2(anchorBin, targetBin int, deltaMs int64, extra int) (int64, error) {
+	if err := checkV2Fields([]int{anchorBin, targetBin}, []int64{deltaMs}, extra); err != nil {
+		return 0, err
+	}
+
+	address := int64(AddressV2) << addressVersionShift
+	address |= int64(extra) << (2*v2FreqBits + v2DeltaBits)
+	address |= int64(anchorBin) << (v2FreqBits + v2DeltaBits)
+	address |= int64(targetBin) << v2DeltaBits
+	address |= deltaMs
+
+	return address, nil
+}
+
+// UnpackAddressV2 is the inverse of PackAddressV2.
+func UnpackAddressV2(address int64) (anchorBin, targetBin int, deltaMs int64, extra int) {
+	extra = int(address>>(2*v2FreqBits+v2DeltaBits)) & (1<<v2ExtraBits - 1)
+	anchorBin = int(address>>(v2FreqBits+v2DeltaBits)) & (1<<v2FreqBits - 1)
+	targetBin = int(address>>v2DeltaBits) & (1<<v2FreqBits - 1)
+	deltaMs = address & (1<<v2DeltaBits - 1)
+	return anchorBin, targetBin, deltaMs, extra
+}
+
+/*
+PackTripletAddressV2 hashes an anchor and two targets into the 58 bits below the
+version. Three 12 bit bins and two 20 bit deltas don't fit there, so unlike every other
+layout a V2 triplet can't be unpacked, only compared.
+*/
+func PackTripletAddressV2(anchorBin, firstBin, secondBin int, firstDeltaMs, secondDeltaMs int64, extra int) (int64, error) {
+	if err := checkV2Fields([]int{anchorBin, firstBin, secondBin}, []int64{firstDeltaMs, secondDeltaMs}, extra); err != nil {
+		return 0, err
+	}
+
+	var fields [16]byte
+	binary.LittleEndian.PutUint64(fields[:8], uint64(extra)<<36|uint64(anchorBin)<<24|uint64(firstBin)<<12|uint64(secondBin))
+	binary.LittleEndian.PutUint64(fields[8:], uint64(firstDeltaMs)<<v2DeltaBits|uint64(secondDeltaMs))
+
+	hash := fnv.New64a()
+	hash.Write(fields[:])
+
+	address := int64(1) << tripletFlagBit
+	address |= int64(AddressV2) << addressVersionShift
+	address |= int64(hash.Sum64() & (1<<addressVersionShift - 1))
+
+	return address, nil
+}
+
+func checkV2Fields(bins []int, deltas []int64, extra int) error {
+	const maxBin = 1 << v2FreqBits
+	const maxDelta = 1 << v2DeltaBits
+	const maxExtra = 1 << v2ExtraBits
+
+	for _, bin := range bins {
+		if bin < 0 || bin >= maxBin {
+			return fmt.Errorf("bin %d out of range [0, %d)", bin, maxBin)
+		}
+	}
+	for _, delta := range deltas {
+		if delta < 0 || delta >= maxDelta {
+			return fmt.Errorf("delta %d ms out of range [0, %d)", delta, maxDelta)
+		}
+	}
+	if extra < 0 || extra >= maxExtra {
+		return fmt.Errorf("extra %d out of range [0, %d)", extra, maxExtra)
+	}
+	return nil
+}
+
+// pairAddress packs a pair in layout v. Only V2 has room for extra, a V1 address
+// with a non-zero extra is an error.
+func (v AddressVersion) pairAddress(anchor, target Peak, extra int) (int64, error) {
+	if v == AddressV2 {
+		return PackAddressV2(anchor.Bin, target.Bin, peakDeltaMs(anchor, target), extra)
+	}
+	if extra != 0 {
+		return 0, fmt.Errorf("v%d addresses have no room for extra %d", v, extra)
+	}
+	return createAddress(anchor, target)
+}
+
+func (v AddressVersion) tripletAddress(anchor, first, second Peak, extra int) (int64, error) {
+	if v == AddressV2 {
+		return PackTripletAddressV2(anchor.Bin, first.Bin, second.Bin, peakDeltaMs(anchor, first), peakDeltaMs(anchor, second), extra)
+	}
+	if extra != 0 {
+		return 0, fmt.Errorf("v%d addresses have no room for extra %d", v, extra)
+	}
+	return createTripletAddress(anchor, first, second)
+}
+
+/*
+MigrateAddress rewrites a stored address into another layout without going back to
+the audio. V1 pairs and triplets move to V2 losslessly; V2 pairs move back to V1 as
+long as their fields fit. V2 triplets are hashes and can't be converted, and legacy
+addresses hold no bins to convert, they fail with ErrLegacyIndex.
+*/
+func MigrateAddress(address int64, to AddressVersion) (int64, error) {
+	from := AddressVersionOf(address)
+	if from == AddressLegacy {
+		return 0, fmt.Errorf("%w: address %#x", ErrLegacyIndex, address)
+	}
+	if from == to {
+		return address, nil
+	}
+
+	triplet := IsTripletAddress(address)
+
+	switch {
+	case from == AddressV1 && to == AddressV2 && triplet:
+		anchorBin, firstBin, secondBin, firstDeltaMs, secondDeltaMs := unpackTripletAddress(address)
+		return PackTripletAddressV2(anchorBin, firstBin, secondBin, firstDeltaMs, secondDeltaMs, 0)
+	case from == AddressV1 && to == AddressV2:
+		anchorBin, targetBin, deltaMs := UnpackAddress(address)
+		return PackAddressV2(anchorBin, targetBin, deltaMs, 0)
+	case from == AddressV2 && to == AddressV1 && !triplet:
+		anchorBin, targetBin, deltaMs, _ := UnpackAddressV2(address)
+		return PackAddress(anchorBin, targetBin, deltaMs)
+	}
+
+	return 0, fmt.Errorf("can't migrate a v%d address %#x to v%d", from, address, to)
+}
+
+// MigrateIndex rewrites every fingerprint of dbClient into the layout to. Switch
+// Config.Address over only once it has finished. A legacy index is refused before
+// anything is rewritten.
+func MigrateIndex(dbClient db.DBClient, to AddressVersion) (int, error) {
+	if err := CheckIndex(dbClient); err != nil {
+		return 0, err
+	}
+	return dbClient.RewriteAddresses(func(address int64) (int64, error) {
+		return MigrateAddress(address, to)
+	})
+}
+
+// DescribeAddress spells out the fields of an address for reports.
+func DescribeAddress(address int64) string {
+	version := AddressVersionOf(address)
+
+	switch {
+	case IsTripletAddress(address) && version == AddressV2:
+		return fmt.Sprintf("v2 triplet %#x", address)
+	case IsTripletAddress(address):
+		anchorBin, firstBin, secondBin, firstDeltaMs, secondDeltaMs := unpackTripletAddress(address)
+		return fmt.Sprintf("v1 triplet bins %d/%d/%d +%d/+%dms", anchorBin, firstBin, secondBin, firstDeltaMs, secondDeltaMs)
+	case version == AddressV2:
+		anchorBin, targetBin, deltaMs, extra := UnpackAddressV2(address)
+		return fmt.Sprintf("v2 bins %d/%d +%dms extra %d", anchorBin, targetBin, deltaMs, extra)
+	case version == AddressV1:
+		anchorBin, targetBin, deltaMs := UnpackAddress(address)
+		return fmt.Sprintf("v1 bins %d/%d +%dms", anchorBin, targetBin, deltaMs)
+	default:
+		return fmt.Sprintf("v%d %#x", version, address)
+	}
+}
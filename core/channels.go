@@ -0,0 +1,216 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	wav "shazoom/fileformat"
+	"shazoom/models"
+	"shazoom/utils"
+	"sync"
+)
+
+/*
+ChannelStrategy selects which signals are fingerprinted out of the channels of a
+recording. Songs at ingest and samples at query time go through the same strategy, so
+whatever a stereo song is turned into, a mono microphone sample is turned into its
+counterpart.
+*/
+type ChannelStrategy string
+
+const (
+	// SeparateChannels fingerprints every channel on its own into one untagged map.
+	// A mono query then matches whichever side it resembles more. This is what
+	// existing indexes were built with.
+	SeparateChannels ChannelStrategy = "separate"
+	// MonoDownmix fingerprints the average of the channels, which is what a query
+	// recorded in a room or downmixed by ReformatWav hears.
+	MonoDownmix ChannelStrategy = "mono"
+	// MidSide fingerprints the mid (L+R)/2 untagged like MonoDownmix, and the side
+	// (L-R)/2 with its own tag, so stereo queries can match the difference too.
+	// Needs AddressV2.
+	MidSide ChannelStrategy = "mid-side"
+	// TaggedChannels fingerprints the left and right channel under their own tag. A
+	// mono query is hashed under both. Needs AddressV2.
+	TaggedChannels ChannelStrategy = "tagged"
+)
+
+// The tags ChannelStrategy puts into the extra byte of AddressV2 addresses.
+const (
+	channelTagNone  = 0
+	channelTagLeft  = 1
+	channelTagRight = 2
+	channelTagSide  = 3
+)
+
+// channelSignal is one signal to fingerprint and the tags to hash its peaks under.
+type channelSignal struct {
+	samples []float64
+	tags    []int
+}
+
+// channelSignals applies the strategy to one or two channels.
+func (c Config) channelSignals(channels [][]float64) ([]channelSignal, error) {
+	if len(channels) != 1 && len(channels) != 2 {
+		return nil, fmt.Errorf("can't fingerprint %d channels, only mono or stereo", len(channels))
+	}
+
+	strategy := c.Channels
+	if strategy == "" {
+		strategy = SeparateChannels
+	}
+	if (strategy == MidSide || strategy == TaggedChannels) && c.Address != AddressV2 {
+		return nil, fmt.Errorf("the %s channel strategy tags addresses and needs AddressV2", strategy)
+	}
+
+	stereo := len(channels) == 2
+	switch strategy {
+	case SeparateChannels:
+		signals := make([]channelSignal, len(channels))
+		for i, channel := range channels {
+			signals[i] = channelSignal{channel, []int{channelTagNone}}
+		}
+		return signals, nil
+	case MonoDownmix:
+		return []channelSignal{{downmix(channels), []int{channelTagNone}}}, nil
+	case MidSide:
+		signals := []channelSignal{{downmix(channels), []int{channelTagNone}}}
+		if stereo {
+			signals = append(signals, channelSignal{sideChannel(channels[0], channels[1]), []int{channelTagSide}})
+		}
+		return signals, nil
+	case TaggedChannels:
+		if !stereo {
+			return []channelSignal{{channels[0], []int{channelTagLeft, channelTagRight}}}, nil
+		}
+		return []channelSignal{
+			{channels[0], []int{channelTagLeft}},
+			{channels[1], []int{channelTagRight}},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown channel strategy %q", strategy)
+}
+
+// wavChannels returns the channels of a decoded file.
+func wavChannels(wavInfo *wav.WavInfo) [][]float64 {
+	channels := [][]float64{wavInfo.LeftChannelSamples}
+	if wavInfo.Channels == 2 {
+		channels = append(channels, wavInfo.RightChannelSamples)
+	}
+	return channels
+}
+
+// downmix averages the channels sample by sample.
+func downmix(channels [][]float64) []float64 {
+	if len(channels) == 1 {
+		return channels[0]
+	}
+
+	length := len(channels[0])
+	for _, channel := range channels[1:] {
+		length = min(length, len(channel))
+	}
+
+	mono := make([]float64, length)
+	for _, channel := range channels {
+		for i := range mono {
+			mono[i] += channel[i]
+		}
+	}
+	for i := range mono {
+		mono[i] /= float64(len(channels))
+	}
+	return mono
+}
+
+func sideChannel(left, right []float64) []float64 {
+	side := make([]float64, min(len(left), len(right)))
+	for i := range side {
+		side[i] = (left[i] - right[i]) / 2
+	}
+	return side
+}
+
+/*
+channelPeaks preprocesses every signal and extracts its peaks, side by side when the
+spectrogram has more than one worker. A silent signal, like the side of a mono song,
+just has no peaks; only a recording where every signal is silent is rejected.
+*/
+func (c Config) channelPeaks(signals []channelSignal, sampleRate int) ([][]Peak, error) {
+	peaks := make([][]Peak, len(signals))
+	errs := make([]error, len(signals))
+	extract := func(i int) {
+		samples := signals[i].samples
+		preprocessed := Preprocessed{Samples: samples}
+		if c.Preprocess.enabled() {
+			var err error
+			if preprocessed, err = c.Preprocess.Preprocess(samples, sampleRate); err != nil {
+				errs[i] = err
+				return
+			}
+		}
+
+		spectro, err := c.spectrogram(preprocessed.Samples, sampleRate)
+		if err != nil {
+			errs[i] = fmt.Errorf("error creating spectrogram for channel %d: %w", i, err)
+			return
+		}
+		duration := float64(len(preprocessed.Samples)) / float64(sampleRate)
+		peaks[i] = preprocessed.remapPeakTimes(c.ExtractPeaks(spectro, duration, sampleRate))
+	}
+
+	if c.Spectrogram.workers() > 1 {
+		var wg sync.WaitGroup
+		for i := range signals {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				extract(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range signals {
+			extract(i)
+		}
+	}
+
+	silent := 0
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, ErrSilentClip):
+			silent++
+		case err != nil:
+			return nil, err
+		}
+	}
+	if silent == len(signals) {
+		return nil, errs[0]
+	}
+	return peaks, nil
+}
+
+/*
+fingerprintChannels fingerprints a recording with the channel strategy. Signals are
+merged in order, so where two collide on an address the later one wins whatever the
+scheduling.
+*/
+func (c Config) fingerprintChannels(channels [][]float64, sampleRate int, songID uint32) (map[int64]models.Couple, error) {
+	signals, err := c.channelSignals(channels)
+	if err != nil {
+		return nil, err
+	}
+
+	peaks, err := c.channelPeaks(signals, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprints := make(map[int64]models.Couple)
+	for i, signal := range signals {
+		for _, tag := range signal.tags {
+			utils.ExtendMap(fingerprints, c.fingerprintTagged(peaks[i], songID, tag))
+		}
+	}
+	return fingerprints, nil
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ChromaFeatures summarise a recording's harmony for cover matching: one 12-bin
+// pitch class vector per block, rotated so the recording's dominant pitch class is bin
+// 0. Key is the rotation, in semitones above C, that was taken out.
+type ChromaFeatures struct {
+	Key          int
+	BlockSeconds float64
+	Blocks       [][12]float64
+}
+
+/*
+Chroma folds the spectrogram of a mono downmix onto the twelve pitch classes, summed
+over blocks of BlockSeconds, log compressed and scaled so each block's loudest class is
+1. The low end is left out as the FFT bins there are wider than a semitone, and the
+top because it is mostly overtones.
+*/
+func (o CoverOptions) Chroma(channels [][]float64, sampleRate int) (ChromaFeatures, error) {
+	if len(channels) == 0 || len(channels[0]) == 0 {
+		return ChromaFeatures{}, errors.New("no samples to extract chroma from")
+	}
+
+	spectrogram, err := Spectrogram(downmix(channels), sampleRate)
+	if err != nil {
+		return ChromaFeatures{}, err
+	}
+
+	resolution := BinResolution(sampleRate)
+	classes := make([]int, windowSize/2)
+	for bin := range classes {
+		frequency := float64(bin) * resolution
+		if frequency < o.MinFreq || frequency > o.MaxFreq {
+			classes[bin] = -1
+			continue
+		}
+		// A4 is 440 Hz and pitch class 9 counting from C
+		semitones := int(math.Round(12 * math.Log2(frequency/440)))
+		classes[bin] = ((semitones+9)%12 + 12) % 12
+	}
+
+	framesPerBlock := max(1, int(math.Round(o.BlockSeconds/FrameDuration(sampleRate))))
+	features := ChromaFeatures{BlockSeconds: float64(framesPerBlock) * FrameDuration(sampleRate)}
+	for start := 0; start < len(spectrogram); start += framesPerBlock {
+		var block [12]float64
+		for _, frame := range spectrogram[start:min(start+framesPerBlock, len(spectrogram))] {
+			for bin, magnitude := range frame[:min(len(frame), len(classes))] {
+				if class := classes[bin]; class >= 0 {
+					block[class] += magnitude * magnitude
+				}
+			}
+		}
+		for class := range block {
+			block[class] = math.Log1p(block[class])
+		}
+		features.Blocks = append(features.Blocks, scaleToMax(block))
+	}
+
+	profile := features.Profile()
+	for class := range profile {
+		if profile[class] > profile[features.Key] {
+			features.Key = class
+		}
+	}
+	for i, block := range features.Blocks {
+		features.Blocks[i] = rotateChroma(block, features.Key)
+	}
+	return features, nil
+}
+
+// Profile is the mean of the blocks, the recording's pitch class distribution.
+func (f ChromaFeatures) Profile() [12]float64 {
+	var profile [12]float64
+	for _, block := range f.Blocks {
+		for class, value := range block {
+			profile[class] += value
+		}
+	}
+	return scaleToMax(profile)
+}
+
+func scaleToMax(vector [12]float64) [12]float64 {
+	var peak float64
+	for _, value := range vector {
+		peak = math.Max(peak, value)
+	}
+	if peak > 0 {
+		for i := range vector {
+			vector[i] /= peak
+		}
+	}
+	return vector
+}
+
+// rotateChroma moves pitch class shift to bin 0.
+func rotateChroma(vector [12]float64, shift int) [12]float64 {
+	var rotated [12]float64
+	for class, value := range vector {
+		rotated[((class-shift)%12+12)%12] = value
+	}
+	return rotated
+}
+
+/*
+The stored chroma format is a version byte, the key byte, the block length in ms and
+the block count as uvarints, then every block as twelve bytes, 255 for the loudest
+class. Three minutes come to about 4 KB.
+*/
+const chromaFormatVersion = 1
+
+func EncodeChroma(features ChromaFeatures) []byte {
+	buf := []byte{chromaFormatVersion, byte(features.Key)}
+	buf = binary.AppendUvarint(buf, uint64(math.Round(features.BlockSeconds*1000)))
+	buf = binary.AppendUvarint(buf, uint64(len(features.Blocks)))
+	for _, block := range features.Blocks {
+		for _, value := range block {
+			buf = append(buf, byte(math.Round(255*math.Max(0, math.Min(1, value)))))
+		}
+	}
+	return buf
+}
+
+func DecodeChroma(data []byte) (ChromaFeatures, error) {
+	if len(data) < 2 || data[0] != chromaFormatVersion {
+		return ChromaFeatures{}, errors.New("not chroma features of a known version")
+	}
+	features := ChromaFeatures{Key: int(data[1])}
+
+	rest := data[2:]
+	blockMs, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return ChromaFeatures{}, errors.New("truncated chroma features")
+	}
+	rest = rest[n:]
+	count, n := binary.Uvarint(rest)
+	if n <= 0 || count*12 != uint64(len(rest[n:])) {
+		return ChromaFeatures{}, fmt.Errorf("chroma features with %d bytes for %d blocks", len(rest), count)
+	}
+	rest = rest[n:]
+
+	features.BlockSeconds = float64(blockMs) / 1000
+	features.Blocks = make([][12]float64, count)
+	for i := range features.Blocks {
+		for class := range features.Blocks[i] {
+			features.Blocks[i][class] = float64(rest[12*i+class]) / 255
+		}
+	}
+	return features, nil
+}
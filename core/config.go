@@ -0,0 +1,57 @@
+package core
+
+import "fmt"
+
+// Config selects the algorithms that turn audio into fingerprints. A catalogue has to
+// be queried with the same Config it was indexed with, otherwise the hashes of the
+// sample never line up with the stored ones.
+type Config struct {
+	Spectrogram SpectrogramOptions
+	PeakPicker  PeakPicker
+	LocalMaxima LocalMaximaOptions
+	Pairing     Pairing
+	TargetZone  TargetZoneOptions
+	// Address is the hash layout. Move an existing index over with MigrateIndex first.
+	Address AddressVersion
+	// Preprocess cleans every signal up before its spectrogram is taken.
+	Preprocess PreprocessOptions
+	// Channels is how the channels of a recording are turned into fingerprinted signals.
+	Channels ChannelStrategy
+	// StopWords leaves out addresses shared by too much of the catalogue.
+	StopWords StopWordOptions
+}
+
+// DefaultConfig is what the package level functions use.
+func DefaultConfig() Config {
+	return Config{
+		Spectrogram: SpectrogramOptions{
+			Magnitude: LinearMagnitude,
+			Frequency: LinearFrequency,
+		},
+		PeakPicker:  BandPeaks,
+		LocalMaxima: DefaultLocalMaximaOptions(),
+		Pairing:     NextPeaksPairing,
+		TargetZone:  DefaultTargetZoneOptions(),
+		Address:     AddressV1,
+		Channels:    SeparateChannels,
+		StopWords:   DefaultStopWordOptions(),
+	}
+}
+
+func (c Config) spectrogram(sample []float64, sampleRate int) ([][]float64, error) {
+	if c.Spectrogram.Frequency != MelFrequency && c.Spectrogram.Frequency != BarkFrequency &&
+		c.Spectrogram.fftSize() > windowSize && c.Address != AddressV2 {
+		return nil, fmt.Errorf("a %d sample window has more bins than AddressV1 holds, it needs AddressV2", c.Spectrogram.fftSize())
+	}
+	return SpectrogramWithOptions(sample, sampleRate, c.Spectrogram)
+}
+
+// ExtractPeaks runs the configured peak picker on a spectrogram computed with c.Spectrogram.
+func (c Config) ExtractPeaks(spectrogram [][]float64, audioDuration float64, sampleRate int) []Peak {
+	switch c.PeakPicker {
+	case LocalMaximaPeaks:
+		return ExtractLocalMaxima(spectrogram, sampleRate, c.Spectrogram, c.LocalMaxima)
+	default:
+		return ExtractPeaksWithOptions(spectrogram, audioDuration, sampleRate, c.Spectrogram)
+	}
+}
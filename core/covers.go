@@ -0,0 +1,332 @@
+package core
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"shazoom/db"
+	"sort"
+)
+
+// CoverOptions configure chroma extraction and cover matching.
+type CoverOptions struct {
+	// MinFreq and MaxFreq bound the spectrum folded onto pitch classes, in Hz.
+	MinFreq float64
+	MaxFreq float64
+	// BlockSeconds is the length of one chroma vector, about a beat.
+	BlockSeconds float64
+	// Candidates is how many songs, closest by pitch class profile in any key, are
+	// aligned with DTW. The others are only compared by profile.
+	Candidates int
+	// MinScore is the similarity, 1 for the same harmony block by block, a result needs.
+	MinScore float64
+	// MaxResults caps the results of a query.
+	MaxResults int
+}
+
+func DefaultCoverOptions() CoverOptions {
+	return CoverOptions{
+		MinFreq:      100,
+		MaxFreq:      2000,
+		BlockSeconds: 0.5,
+		Candidates:   50,
+		MinScore:     0.5,
+		MaxResults:   10,
+	}
+}
+
+/*
+CoverIndex is a second, optional recognition mode for covers, live versions and
+re-recordings, which share a song's harmony but none of its landmark hashes. Songs
+are stored as key normalised chroma sequences in their own table next to the
+fingerprints, and queries are aligned against them with subsequence DTW in all twelve
+transpositions, which also absorbs tempo differences of up to a factor of two.
+*/
+type CoverIndex struct {
+	DB      db.DBClient
+	Options CoverOptions
+}
+
+func NewCoverIndex(dbClient db.DBClient) *CoverIndex {
+	return &CoverIndex{DB: dbClient, Options: DefaultCoverOptions()}
+}
+
+// SimilarRecording is a cover index match. Start and End are where in the song the
+// query's harmony was found, in seconds, and Transposition how many semitones the
+// query sits above the song.
+type SimilarRecording struct {
+	SongID        uint32
+	Title         string
+	Artist        string
+	Score         float64
+	Transposition int
+	Start         float64
+	End           float64
+}
+
+// Add stores the chroma of a song's audio in the cover index, replacing what it had.
+func (c *CoverIndex) Add(songID uint32, channels [][]float64, sampleRate int) error {
+	features, err := c.Options.Chroma(channels, sampleRate)
+	if err != nil {
+		return err
+	}
+	if err := c.DB.StoreChroma(songID, EncodeChroma(features)); err != nil {
+		return fmt.Errorf("error storing the chroma of song %d: %w", songID, err)
+	}
+	return nil
+}
+
+// AddFile decodes an audio file of any format and adds it as songID.
+func (c *CoverIndex) AddFile(songID uint32, path string) error {
+	wavInfo, err := decodeSong(path)
+	if err != nil {
+		return err
+	}
+	return c.Add(songID, wavChannels(wavInfo), wavInfo.SampleRate)
+}
+
+// FindSimilarToFile decodes an audio file of any format and queries the cover index.
+func (c *CoverIndex) FindSimilarToFile(path string) ([]SimilarRecording, error) {
+	wavInfo, err := decodeSong(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.FindSimilar(wavChannels(wavInfo), wavInfo.SampleRate)
+}
+
+/*
+FindSimilar returns the songs whose harmony the recording follows, best first. Every
+stored profile is compared with the query's, keeping only the Candidates closest, and
+only their chroma sequences are read back and aligned block by block. Queries shorter
+than the song match the part they cover.
+*/
+func (c *CoverIndex) FindSimilar(channels [][]float64, sampleRate int) ([]SimilarRecording, error) {
+	if c.Options.Candidates <= 0 || c.Options.MaxResults <= 0 {
+		return nil, fmt.Errorf("candidates and max results must be positive, got %d and %d", c.Options.Candidates, c.Options.MaxResults)
+	}
+
+	query, err := c.Options.Chroma(channels, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	queryProfile := query.Profile()
+
+	closest := &profileHeap{}
+	err = c.DB.WalkChroma(func(songID uint32, data []byte) error {
+		features, err := DecodeChroma(data)
+		if err != nil {
+			return fmt.Errorf("chroma of song %d: %w", songID, err)
+		}
+
+		profile := features.Profile()
+		best := math.Inf(-1)
+		for shift := 0; shift < 12; shift++ {
+			best = math.Max(best, chromaSimilarity(rotateChroma(queryProfile, shift), profile))
+		}
+
+		heap.Push(closest, profileScore{songID, best})
+		if closest.Len() > c.Options.Candidates {
+			heap.Pop(closest)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	songIDs := make([]uint32, closest.Len())
+	for i, candidate := range *closest {
+		songIDs[i] = candidate.songID
+	}
+	sort.Slice(songIDs, func(i, j int) bool { return songIDs[i] < songIDs[j] })
+	stored, err := c.DB.GetChroma(songIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error reading candidate chroma: %w", err)
+	}
+
+	var rotated [12][][12]float64
+	for shift := range rotated {
+		rotated[shift] = make([][12]float64, len(query.Blocks))
+		for i, block := range query.Blocks {
+			rotated[shift][i] = rotateChroma(block, shift)
+		}
+	}
+
+	var results []SimilarRecording
+	for _, songID := range songIDs {
+		data, ok := stored[songID]
+		// deleted since the walk
+		if !ok {
+			continue
+		}
+		features, err := DecodeChroma(data)
+		if err != nil {
+			return nil, fmt.Errorf("chroma of song %d: %w", songID, err)
+		}
+
+		best := SimilarRecording{SongID: songID, Score: math.Inf(-1)}
+		for shift := 0; shift < 12; shift++ {
+			score, start, end := alignChroma(rotated[shift], features.Blocks)
+			if score > best.Score {
+				best.Score = score
+				// shift undoes the rest of the query's transposition once both are
+				// normalised to their own keys
+				best.Transposition = ((query.Key-features.Key+shift+6)%12+12)%12 - 6
+				best.Start = float64(start) * features.BlockSeconds
+				best.End = float64(end+1) * features.BlockSeconds
+			}
+		}
+		if best.Score >= c.Options.MinScore {
+			results = append(results, best)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].SongID < results[j].SongID
+	})
+	if len(results) > c.Options.MaxResults {
+		results = results[:c.Options.MaxResults]
+	}
+
+	kept := results[:0]
+	for _, result := range results {
+		song, found, err := c.DB.GetSongByID(result.SongID)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up song %d: %w", result.SongID, err)
+		}
+		// chroma left behind by a deleted song
+		if !found {
+			continue
+		}
+		result.Title, result.Artist = song.Title, song.Artist
+		kept = append(kept, result)
+	}
+	return kept, nil
+}
+
+// profileScore is how close a song's pitch class profile is to the query's.
+type profileScore struct {
+	songID uint32
+	score  float64
+}
+
+// profileHeap is a min-heap of profile scores, so the weakest of the closest songs
+// so far is the one dropped. Ties keep the lower song ID.
+type profileHeap []profileScore
+
+func (h profileHeap) Len() int { return len(h) }
+func (h profileHeap) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score < h[j].score
+	}
+	return h[i].songID > h[j].songID
+}
+func (h profileHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *profileHeap) Push(x any)   { *h = append(*h, x.(profileScore)) }
+func (h *profileHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// chromaSimilarity is the cosine of two chroma vectors with their means taken out, so
+// flat noise scores 0 rather than close to 1. Silence scores 0.
+func chromaSimilarity(a, b [12]float64) float64 {
+	var meanA, meanB float64
+	for i := range a {
+		meanA += a[i] / 12
+		meanB += b[i] / 12
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		x, y := a[i]-meanA, b[i]-meanB
+		dot += x * y
+		normA += x * x
+		normB += y * y
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+/*
+alignChroma finds the stretch of reference the query follows best with subsequence
+DTW: the path may start and end anywhere in reference but has to cover all of query.
+Steps of (1,1), (1,2) and (2,1) blocks keep the tempo ratio between a half and double,
+and the cells a step jumps over are still paid for. It returns the mean similarity
+along the path and the reference blocks it starts and ends on. A step reaches back at
+most two query blocks, so only three rows of the cost matrix are kept, each cell
+carrying the reference block its path started on.
+*/
+func alignChroma(query, reference [][12]float64) (score float64, start, end int) {
+	n, m := len(query), len(reference)
+	if n == 0 || m == 0 {
+		return math.Inf(-1), 0, 0
+	}
+
+	distance := func(i, j int) float64 {
+		return 1 - chromaSimilarity(query[i], reference[j])
+	}
+
+	type cell struct {
+		cost   float64
+		length int
+		start  int
+	}
+	unreachable := cell{cost: math.Inf(1)}
+
+	// rows[i%3] is query block i
+	var rows [3][]cell
+	for r := range rows {
+		rows[r] = make([]cell, m)
+		for j := range rows[r] {
+			rows[r][j] = unreachable
+		}
+	}
+	for j := 0; j < m; j++ {
+		rows[0][j] = cell{cost: distance(0, j), length: 1, start: j}
+	}
+
+	for i := 1; i < n; i++ {
+		row, previous, twoBack := rows[i%3], rows[(i-1)%3], rows[(i+1)%3]
+		row[0] = unreachable
+		for j := 1; j < m; j++ {
+			d := distance(i, j)
+			best := unreachable
+			consider := func(from cell, extra float64, cells int) {
+				if cost := from.cost + extra + d; cost < best.cost {
+					best = cell{cost: cost, length: from.length + cells, start: from.start}
+				}
+			}
+
+			consider(previous[j-1], 0, 1)
+			if j >= 2 {
+				consider(previous[j-2], distance(i, j-1), 2)
+			}
+			if i >= 2 {
+				consider(twoBack[j-1], distance(i-1, j), 2)
+			}
+			row[j] = best
+		}
+	}
+
+	bestMean := math.Inf(1)
+	for j, last := range rows[(n-1)%3] {
+		if last.length == 0 {
+			continue
+		}
+		if mean := last.cost / float64(last.length); mean < bestMean {
+			bestMean, start, end = mean, last.start, j
+		}
+	}
+	if math.IsInf(bestMean, 1) {
+		return math.Inf(-1), 0, 0
+	}
+	return 1 - bestMean, start, end
+}
@@ -0,0 +1,327 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"shazoom/db"
+	"shazoom/models"
+	"sort"
+)
+
+// DuplicateOptions configure FindDuplicates.
+type DuplicateOptions struct {
+	// MinScore is the timing score a pair of songs needs to be compared at all.
+	MinScore float64
+	// MinOverlap is the share of at least one of the two songs, normally the shorter,
+	// that has to be found in the other.
+	MinOverlap float64
+	// An offset needs MinVotes hashes, and MinOffsetShare of the votes of the pair's
+	// best offset, before the audio aligned at it counts as shared. Chance hits are
+	// spread over every offset and don't get there.
+	MinVotes       int
+	MinOffsetShare float64
+}
+
+func DefaultDuplicateOptions() DuplicateOptions {
+	return DuplicateOptions{
+		MinScore:       20,
+		MinOverlap:     0.6,
+		MinVotes:       10,
+		MinOffsetShare: 0.1,
+	}
+}
+
+// DuplicatePair is two songs with audio in common, SongA the lower ID.
+type DuplicatePair struct {
+	SongA uint32
+	SongB uint32
+	Score float64
+	// OffsetMs is where SongA's start falls in SongB, negative when SongA starts with
+	// audio SongB doesn't have.
+	OffsetMs int32
+	// OverlapA and OverlapB are the shares of each song found in the other. A radio
+	// edit of an album version has OverlapA near 1 and a lower OverlapB, or the other
+	// way round.
+	OverlapA float64
+	OverlapB float64
+
+	coveredA int
+	coveredB int
+}
+
+// DuplicateCluster is a set of songs linked by duplicate pairs, ordered by ID.
+type DuplicateCluster struct {
+	Songs []db.Song
+	Pairs []DuplicatePair
+}
+
+/*
+FindDuplicates queries every stored song's own fingerprints against the index and
+groups songs sharing most of their audio: re-uploads under another title, remasters,
+radio edits. Overlap is counted in seconds of each song covered by hashes aligned at
+any well supported offset, so an edit with a section cut out still overlaps fully.
+Fingerprints are streamed one song at a time; songs no longer registered are ignored.
+*/
+func (m *Matcher) FindDuplicates(opts DuplicateOptions) ([]DuplicateCluster, error) {
+	// seconds of each song with at least one fingerprint, its length as the index sees it
+	seconds := make(map[uint32]int)
+	var pairs []DuplicatePair
+
+	var current uint32
+	sample := make(map[int64]uint32)
+	covered := make(map[uint32]bool)
+	flush := func() error {
+		if len(sample) == 0 {
+			return nil
+		}
+		found, err := m.songDuplicates(current, sample, opts)
+		if err != nil {
+			return fmt.Errorf("error comparing song %d: %w", current, err)
+		}
+		pairs = append(pairs, found...)
+		seconds[current] = len(covered)
+
+		sample = make(map[int64]uint32)
+		covered = make(map[uint32]bool)
+		return nil
+	}
+
+	err := m.DB.WalkFingerprints(func(address int64, couple models.Couple) error {
+		if couple.SongId != current {
+			if err := flush(); err != nil {
+				return err
+			}
+			current = couple.SongId
+		}
+		// walked in time order, so a repeated address keeps its first anchor
+		if _, seen := sample[address]; !seen {
+			sample[address] = couple.AnchorTime
+		}
+		covered[couple.AnchorTime/1000] = true
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	songs := make(map[uint32]db.Song)
+	registered := func(songID uint32) (bool, error) {
+		if _, ok := songs[songID]; ok {
+			return true, nil
+		}
+		song, found, err := m.DB.GetSongByID(songID)
+		if err != nil {
+			return false, fmt.Errorf("error looking up song %d: %w", songID, err)
+		}
+		if found {
+			songs[songID] = song
+		}
+		return found, nil
+	}
+
+	// union-find over the pairs that overlap enough
+	parent := make(map[uint32]uint32)
+	var root func(uint32) uint32
+	root = func(songID uint32) uint32 {
+		if p, ok := parent[songID]; ok && p != songID {
+			parent[songID] = root(p)
+			return parent[songID]
+		}
+		parent[songID] = songID
+		return songID
+	}
+
+	var kept []DuplicatePair
+	for _, pair := range pairs {
+		if seconds[pair.SongA] == 0 || seconds[pair.SongB] == 0 {
+			continue
+		}
+		pair.OverlapA = math.Min(1, float64(pair.coveredA)/float64(seconds[pair.SongA]))
+		pair.OverlapB = math.Min(1, float64(pair.coveredB)/float64(seconds[pair.SongB]))
+		if math.Max(pair.OverlapA, pair.OverlapB) < opts.MinOverlap {
+			continue
+		}
+
+		foundA, err := registered(pair.SongA)
+		if err != nil {
+			return nil, err
+		}
+		foundB, err := registered(pair.SongB)
+		if err != nil {
+			return nil, err
+		}
+		if !foundA || !foundB {
+			continue
+		}
+
+		kept = append(kept, pair)
+		parent[root(pair.SongB)] = root(pair.SongA)
+	}
+
+	byRoot := make(map[uint32]*DuplicateCluster)
+	for _, pair := range kept {
+		r := root(pair.SongA)
+		if byRoot[r] == nil {
+			byRoot[r] = &DuplicateCluster{}
+		}
+		byRoot[r].Pairs = append(byRoot[r].Pairs, pair)
+	}
+
+	clusters := make([]DuplicateCluster, 0, len(byRoot))
+	for songID := range parent {
+		if cluster := byRoot[root(songID)]; cluster != nil {
+			cluster.Songs = append(cluster.Songs, songs[songID])
+		}
+	}
+	for _, cluster := range byRoot {
+		sort.Slice(cluster.Songs, func(i, j int) bool { return cluster.Songs[i].ID < cluster.Songs[j].ID })
+		sort.Slice(cluster.Pairs, func(i, j int) bool {
+			a, b := cluster.Pairs[i], cluster.Pairs[j]
+			return a.SongA < b.SongA || (a.SongA == b.SongA && a.SongB < b.SongB)
+		})
+		clusters = append(clusters, *cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Songs[0].ID < clusters[j].Songs[0].ID })
+
+	return clusters, nil
+}
+
+// songDuplicates matches one song against the songs stored after it, leaving the
+// overlap shares to be worked out once every song's length is known.
+func (m *Matcher) songDuplicates(songID uint32, sample map[int64]uint32, opts DuplicateOptions) ([]DuplicatePair, error) {
+	addresses := make([]int64, 0, len(sample))
+	for address := range sample {
+		addresses = append(addresses, address)
+	}
+	couples, err := m.getCouples(addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := CollectMatches(sample, couples)
+	var pairs []DuplicatePair
+	for other, timing := range analyzeRelativeTiming(matches) {
+		if other <= songID || timing.Score < opts.MinScore {
+			continue
+		}
+
+		bin := func(pair [2]uint32) int64 {
+			delta := int64(pair[1]) - int64(pair[0])
+			return int64(math.Floor(float64(delta) / offsetBinWidthMs))
+		}
+		votes := make(map[int64]int)
+		for _, pair := range matches[other] {
+			votes[bin(pair)]++
+		}
+		support := func(b int64) int {
+			return votes[b-1] + votes[b] + votes[b+1]
+		}
+		best := 0
+		for b := range votes {
+			best = max(best, support(b))
+		}
+		threshold := max(float64(opts.MinVotes), opts.MinOffsetShare*float64(best))
+
+		coveredA := make(map[uint32]bool)
+		coveredB := make(map[uint32]bool)
+		for _, pair := range matches[other] {
+			if float64(support(bin(pair))) < threshold {
+				continue
+			}
+			coveredA[pair[0]/1000] = true
+			coveredB[pair[1]/1000] = true
+		}
+
+		pairs = append(pairs, DuplicatePair{
+			SongA:    songID,
+			SongB:    other,
+			Score:    timing.Score,
+			OffsetMs: timing.OffsetMs,
+			coveredA: len(coveredA),
+			coveredB: len(coveredB),
+		})
+	}
+	return pairs, nil
+}
+
+// MergeStats counts what MergeDuplicates removed.
+type MergeStats struct {
+	Songs        int
+	Fingerprints int
+}
+
+/*
+MergeDuplicates keeps one song of a duplicate cluster and deletes the others with their
+fingerprints. Metadata the kept song lacks, an album or ISRC say, is taken from the
+first duplicate that has it before the duplicates go.
+*/
+func MergeDuplicates(dbClient db.DBClient, keep uint32, duplicates []uint32) (MergeStats, error) {
+	var stats MergeStats
+
+	kept, found, err := dbClient.GetSongByID(keep)
+	if err != nil {
+		return stats, fmt.Errorf("error looking up song %d: %w", keep, err)
+	}
+	if !found {
+		return stats, fmt.Errorf("song %d doesn't exist", keep)
+	}
+
+	merged := kept
+	var others []db.Song
+	for _, songID := range duplicates {
+		if songID == keep {
+			return stats, errors.New("the song to keep is also listed as a duplicate")
+		}
+		song, found, err := dbClient.GetSongByID(songID)
+		if err != nil {
+			return stats, fmt.Errorf("error looking up song %d: %w", songID, err)
+		}
+		if !found {
+			return stats, fmt.Errorf("song %d doesn't exist", songID)
+		}
+		others = append(others, song)
+
+		merged.Album = cmp.Or(merged.Album, song.Album)
+		merged.ISRC = cmp.Or(merged.ISRC, song.ISRC)
+		merged.ReleaseYear = cmp.Or(merged.ReleaseYear, song.ReleaseYear)
+		merged.Genre = cmp.Or(merged.Genre, song.Genre)
+		merged.YouTubeID = cmp.Or(merged.YouTubeID, song.YouTubeID)
+	}
+
+	if merged != kept {
+		if err := dbClient.UpdateSongMetadata(merged); err != nil {
+			return stats, fmt.Errorf("error updating song %d: %w", keep, err)
+		}
+	}
+
+	for _, song := range others {
+		deleted, err := DeleteSong(dbClient, song.ID)
+		stats.Fingerprints += deleted
+		if err != nil {
+			return stats, err
+		}
+		stats.Songs++
+	}
+	return stats, nil
+}
+
+// DeleteSong deletes a song with its fingerprints and chroma, and returns how many
+// fingerprints went. The song goes last, so a failure never leaves anything orphaned.
+func DeleteSong(dbClient db.DBClient, songID uint32) (int, error) {
+	deleted, err := dbClient.DeleteSongFingerprints(songID)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting the fingerprints of song %d: %w", songID, err)
+	}
+	if err := dbClient.DeleteChroma(songID); err != nil {
+		return deleted, fmt.Errorf("error deleting the chroma of song %d: %w", songID, err)
+	}
+	if err := dbClient.DeleteSongByID(songID); err != nil {
+		return deleted, fmt.Errorf("error deleting song %d: %w", songID, err)
+	}
+	return deleted, nil
+}
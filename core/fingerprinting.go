@@ -2,9 +2,9 @@ package core
 
 import (
     "fmt"
+    "math"
     wav "shazoom/fileformat"
     "shazoom/models"
-    "shazoom/utils"
 )
 
 const (
@@ -13,13 +13,22 @@ const (
     targetZoneSize = 5
 )
 
+// Fingerprint pairs the peaks with NextPeaksPairing.
 func Fingerprint(peaks []Peak, songID uint32) map[int64]models.Couple {
+    return fingerprintNextPeaks(peaks, songID, AddressV1, 0)
+}
+
+func fingerprintNextPeaks(peaks []Peak, songID uint32, version AddressVersion, extra int) map[int64]models.Couple {
     fingerprints := map[int64]models.Couple{}
     for i, anchor := range peaks {
         for j := i + 1; j < len(peaks) && j <= i+targetZoneSize; j++ {
             target := peaks[j]
 
-            address64 := createAddress(anchor, target) 
+            // pairs that don't fit the address layout (e.g. too far apart) are dropped
+            address64, err := version.pairAddress(anchor, target, extra)
+            if err != nil {
+                continue
+            }
             anchorTimeMs := uint32(anchor.Time * 1000)
 
             fingerprints[address64] = models.Couple{
@@ -32,43 +41,79 @@ func Fingerprint(peaks []Peak, songID uint32) map[int64]models.Couple {
     return fingerprints
 }
 
-func createAddress(anchor, target Peak) int64 {
-    anchorFreqBin := uint32(anchor.Freq / 10) 
-    targetFreqBin := uint32(target.Freq / 10)
+func createAddress(anchor, target Peak) (int64, error) {
+    return PackAddress(anchor.Bin, target.Bin, peakDeltaMs(anchor, target))
+}
+
+// peakDeltaMs is the time from anchor to target, rounded to the millisecond.
+func peakDeltaMs(anchor, target Peak) int64 {
+    return int64(math.Round((target.Time - anchor.Time) * 1000))
+}
 
-    deltaMsRaw := uint32((target.Time - anchor.Time) * 1000)
+/*
+PackAddress packs a pair of peaks into the low 32 bits of an address:
 
-    anchorFreqBits := anchorFreqBin & ((1 << maxFreqBits) - 1) 
-    targetFreqBits := targetFreqBin & ((1 << maxFreqBits) - 1) 
-    deltaBits := deltaMsRaw & ((1 << maxDeltaBits) - 1)        
+    | anchor bin (9 bits) | target bin (9 bits) | delta ms (14 bits) |
 
-    address32 := (anchorFreqBits << 23) | (targetFreqBits << 14) | deltaBits
+Bins are spectrogram column indices, so the full 0-511 range of a 1024 point FFT fits
+without wrapping. Values that don't fit are rejected instead of being masked. The
+version bits are set to AddressV1, which keeps the address apart from the Hz/10
+addresses indexes used to hold in the same 32 bits.
+*/
+func PackAddress(anchorBin, targetBin int, deltaMs int64) (int64, error) {
+    const maxBin = 1 << maxFreqBits
+    const maxDelta = 1 << maxDeltaBits
 
-    return int64(address32)
+    if anchorBin < 0 || anchorBin >= maxBin {
+        return 0, fmt.Errorf("anchor bin %d out of range [0, %d)", anchorBin, maxBin)
+    }
+    if targetBin < 0 || targetBin >= maxBin {
+        return 0, fmt.Errorf("target bin %d out of range [0, %d)", targetBin, maxBin)
+    }
+    if deltaMs < 0 || deltaMs >= maxDelta {
+        return 0, fmt.Errorf("delta %d ms out of range [0, %d)", deltaMs, maxDelta)
+    }
+
+    address32 := uint32(anchorBin)<<(maxFreqBits+maxDeltaBits) | uint32(targetBin)<<maxDeltaBits | uint32(deltaMs)
+
+    return int64(AddressV1)<<addressVersionShift | int64(address32), nil
+}
+
+// UnpackAddress is the inverse of PackAddress.
+func UnpackAddress(address int64) (anchorBin, targetBin int, deltaMs int64) {
+    anchorBin = int(address>>(maxFreqBits+maxDeltaBits)) & (1<<maxFreqBits - 1)
+    targetBin = int(address>>maxDeltaBits) & (1<<maxFreqBits - 1)
+    deltaMs = address & (1<<maxDeltaBits - 1)
+    return anchorBin, targetBin, deltaMs
 }
 
 func GenerateFingerprintsFromSamples(samples []float64, sampleRate int, songID uint32) (map[int64]models.Couple, error) {
+    return DefaultConfig().GenerateFingerprintsFromSamples(samples, sampleRate, songID)
+}
+
+func (c Config) GenerateFingerprintsFromSamples(samples []float64, sampleRate int, songID uint32) (map[int64]models.Couple, error) {
     if len(samples) == 0 {
         return nil, fmt.Errorf("samples slice is empty")
     }
 
-    duration := float64(len(samples)) / float64(sampleRate)
+    return c.fingerprintChannels([][]float64{samples}, sampleRate, songID)
+}
 
-    fingerprints := make(map[int64]models.Couple)
+func GenerateFingerprints(songFilePath string, songID uint32) (map[int64]models.Couple, error) {
+    return DefaultConfig().GenerateFingerprints(songFilePath, songID)
+}
 
-    spectro, err := Spectrogram(samples, sampleRate)
+func (c Config) GenerateFingerprints(songFilePath string, songID uint32) (map[int64]models.Couple, error) {
+    wavInfo, err := decodeSong(songFilePath)
     if err != nil {
-        return nil, fmt.Errorf("error creating spectrogram: %w", err)
+        return nil, err
     }
 
-    peaks := ExtractPeaks(spectro, duration, sampleRate)
-
-    utils.ExtendMap(fingerprints, Fingerprint(peaks, songID))
-
-    return fingerprints, nil
+    return c.fingerprintWav(wavInfo, songID)
 }
 
-func GenerateFingerprints(songFilePath string, songID uint32) (map[int64]models.Couple, error) {
+// decodeSong converts any audio file to a stereo 16-bit WAV and reads it back.
+func decodeSong(songFilePath string) (*wav.WavInfo, error) {
     wavFilePath, err := wav.ConvertToWAV(songFilePath, 2) 
     if err != nil {
         return nil, fmt.Errorf("error converting input file to WAV: %w", err)
@@ -79,25 +124,9 @@ func GenerateFingerprints(songFilePath string, songID uint32) (map[int64]models.
         return nil, fmt.Errorf("error reading WAV info: %w", err)
     }
 
-    fingerprints := make(map[int64]models.Couple)
-
-    spectro, err := Spectrogram(wavInfo.LeftChannelSamples, wavInfo.SampleRate)
-    if err != nil {
-        return nil, fmt.Errorf("error creating spectrogram: %w", err)
-    }
-
-    peaks := ExtractPeaks(spectro, wavInfo.Duration, wavInfo.SampleRate)
-    utils.ExtendMap(fingerprints, Fingerprint(peaks, songID))
-
-    if wavInfo.Channels == 2 {
-        spectro, err = Spectrogram(wavInfo.RightChannelSamples, wavInfo.SampleRate)
-        if err != nil {
-            return nil, fmt.Errorf("error creating spectrogram for right channel: %w", err)
-        }
-
-        peaks = ExtractPeaks(spectro, wavInfo.Duration, wavInfo.SampleRate)
-        utils.ExtendMap(fingerprints, Fingerprint(peaks, songID))
-    }
+    return wavInfo, nil
+}
 
-    return fingerprints, nil
+func (c Config) fingerprintWav(wavInfo *wav.WavInfo, songID uint32) (map[int64]models.Couple, error) {
+    return c.fingerprintChannels(wavChannels(wavInfo), wavInfo.SampleRate, songID)
 }
\ No newline at end of file
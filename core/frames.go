@@ -0,0 +1,175 @@
+package core
+
+import (
+	"math"
+	"math/cmplx"
+	"runtime"
+	"sync"
+)
+
+// AllProcs as SpectrogramOptions.Workers uses one worker per runtime.GOMAXPROCS.
+const AllProcs = -1
+
+// workers resolves the Workers option to a goroutine count of at least 1.
+func (o SpectrogramOptions) workers() int {
+	switch {
+	case o.Workers == AllProcs:
+		return runtime.GOMAXPROCS(0)
+	case o.Workers > 1:
+		return o.Workers
+	default:
+		return 1
+	}
+}
+
+// fftSize resolves the WindowSize option.
+func (o SpectrogramOptions) fftSize() int {
+	if o.WindowSize > 0 {
+		return o.WindowSize
+	}
+	return windowSize
+}
+
+// FrameDuration is the time between the starts of two frames of a spectrogram
+// computed with these options, in seconds.
+func (o SpectrogramOptions) FrameDuration(sampleRate int) float64 {
+	return float64(o.fftSize()/2) / EffectiveSampleRate(sampleRate)
+}
+
+// BinResolution is the width of one FFT bin in Hz with these options.
+func (o SpectrogramOptions) BinResolution(sampleRate int) float64 {
+	return EffectiveSampleRate(sampleRate) / float64(o.fftSize())
+}
+
+/*
+fftPlan is an in-place iterative FFT of a fixed size with its twiddle factors and
+bit-reversal table worked out once. Every butterfly computes exactly what recursiveFFT
+computes for the same element, with twiddles from the same formula, so its output is
+bit for bit that of FFT. A plan owns its buffers and must not be shared between
+goroutines.
+*/
+type fftPlan struct {
+	n        int
+	reversed []int
+	// twiddles holds the factors of each stage back to back, n/2 of size n last
+	twiddles []complex128
+	buffer   []complex128
+	frame    []float64
+}
+
+func newFFTPlan(n int) *fftPlan {
+	plan := &fftPlan{
+		n:        n,
+		reversed: make([]int, n),
+		buffer:   make([]complex128, n),
+		frame:    make([]float64, n),
+	}
+
+	bits := 0
+	for 1<<bits < n {
+		bits++
+	}
+	for i := range plan.reversed {
+		reversed := 0
+		for b := 0; b < bits; b++ {
+			if i&(1<<b) != 0 {
+				reversed |= 1 << (bits - 1 - b)
+			}
+		}
+		plan.reversed[i] = reversed
+	}
+
+	for size := 2; size <= n; size *= 2 {
+		for k := 0; k < size/2; k++ {
+			plan.twiddles = append(plan.twiddles, complex(math.Cos(-2*math.Pi*float64(k)/float64(size)), math.Sin(-2*math.Pi*float64(k)/float64(size))))
+		}
+	}
+
+	return plan
+}
+
+// transform runs the FFT of input, which must have n samples, into the plan's buffer.
+func (p *fftPlan) transform(input []float64) []complex128 {
+	for i, v := range input {
+		p.buffer[p.reversed[i]] = complex(v, 0)
+	}
+
+	offset := 0
+	for size := 2; size <= p.n; size *= 2 {
+		half := size / 2
+		twiddles := p.twiddles[offset : offset+half]
+		for start := 0; start < p.n; start += size {
+			for k := 0; k < half; k++ {
+				even := p.buffer[start+k]
+				odd := twiddles[k] * p.buffer[start+k+half]
+				p.buffer[start+k] = even + odd
+				p.buffer[start+k+half] = even - odd
+			}
+		}
+		offset += half
+	}
+
+	return p.buffer
+}
+
+// magnitudes windows the frame starting at start and returns the magnitudes of the
+// lower half of its spectrum in a new slice.
+func (p *fftPlan) magnitudes(sample []float64, start int, window []float64) []float64 {
+	copy(p.frame, sample[start:start+p.n])
+	for j := range window {
+		p.frame[j] *= window[j]
+	}
+
+	spectrum := p.transform(p.frame)
+
+	magnitude := make([]float64, p.n/2)
+	for j := range magnitude {
+		magnitude[j] = cmplx.Abs(spectrum[j])
+	}
+	return magnitude
+}
+
+/*
+spectrogramFrames computes every frame of sample under window, half a window apart. The frames
+are split into contiguous runs, one per worker, and each worker writes only its own
+rows with its own plan, so the result is the same for any number of workers.
+*/
+func spectrogramFrames(sample []float64, window []float64, workers int) [][]float64 {
+	size := len(window)
+	hop := size / 2
+
+	frames := 0
+	if len(sample) >= size {
+		frames = (len(sample)-size)/hop + 1
+	}
+	spectrogram := make([][]float64, frames)
+
+	if workers > frames {
+		workers = frames
+	}
+	if workers <= 1 {
+		plan := newFFTPlan(size)
+		for i := range spectrogram {
+			spectrogram[i] = plan.magnitudes(sample, i*hop, window)
+		}
+		return spectrogram
+	}
+
+	var wg sync.WaitGroup
+	chunk := (frames + workers - 1) / workers
+	for first := 0; first < frames; first += chunk {
+		last := min(first+chunk, frames)
+
+		wg.Add(1)
+		go func(first, last int) {
+			defer wg.Done()
+			plan := newFFTPlan(size)
+			for i := first; i < last; i++ {
+				spectrogram[i] = plan.magnitudes(sample, i*hop, window)
+			}
+		}(first, last)
+	}
+	wg.Wait()
+
+	return spectrogram
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"image/png"
@@ -48,4 +49,47 @@ func SpectrogramToImage(spectrogram [][]complex128, outputPath string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// MagnitudesToImage renders a spectrogram computed with opts as a heat map, one row per
+// frame and one column per bin or band. Linear magnitudes are scaled by the loudest
+// value like SpectrogramToImage, log scaled ones are stretched between their minimum
+// and maximum so the whole dB range stays visible.
+func MagnitudesToImage(spectrogram [][]float64, opts SpectrogramOptions, outputPath string) error {
+	if len(spectrogram) == 0 || len(spectrogram[0]) == 0 {
+		return fmt.Errorf("spectrogram is empty")
+	}
+
+	numWindows := len(spectrogram)
+	numFreqBins := len(spectrogram[0])
+
+	minValue, maxValue := math.Inf(1), math.Inf(-1)
+	for _, frame := range spectrogram {
+		for _, value := range frame {
+			minValue = math.Min(minValue, value)
+			maxValue = math.Max(maxValue, value)
+		}
+	}
+	if !opts.Logarithmic() {
+		minValue = 0
+	}
+
+	img := image.NewGray(image.Rect(0, 0, numFreqBins, numWindows))
+	for i, frame := range spectrogram {
+		for j, value := range frame {
+			var intensity uint8
+			if maxValue > minValue {
+				intensity = uint8(math.Floor(255 * (value - minValue) / (maxValue - minValue)))
+			}
+			img.SetGray(j, i, color.Gray{Y: intensity})
+		}
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return png.Encode(file, img)
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"shazoom/db"
+	wav "shazoom/fileformat"
+	"shazoom/models"
+	"shazoom/utils"
+	"strconv"
+	"strings"
+)
+
+// Indexer registers songs and stores their fingerprints. Like Matcher, its Config
+// has to be the one the catalogue is queried with. When Covers is set, songs are
+// added to that cover index as well. NewIndexer computes spectrograms on every CPU,
+// which changes nothing stored.
+type Indexer struct {
+	DB     db.DBClient
+	Config Config
+	Covers *CoverIndex
+}
+
+func NewIndexer(dbClient db.DBClient) *Indexer {
+	config := DefaultConfig()
+	config.Spectrogram.Workers = AllProcs
+	return &Indexer{DB: dbClient, Config: config}
+}
+
+// IndexResult describes what indexing a song did. Skipped is set when the song was
+// already registered and fully fingerprinted, in which case nothing was stored.
+type IndexResult struct {
+	SongID       uint32
+	Fingerprints int
+	Skipped      bool
+}
+
+/*
+IndexFile decodes an audio file and indexes it as song. Fields of song left empty are
+filled from the file's tags where it has them, and a title still missing is the file
+name. The content hash is taken from the decoded PCM, so the same recording is
+recognised under any title.
+*/
+func (ix *Indexer) IndexFile(songFilePath string, song db.Song) (IndexResult, error) {
+	wavInfo, err := decodeSong(songFilePath)
+	if err != nil {
+		return IndexResult{}, err
+	}
+
+	metadata, err := wav.GetMetadata(songFilePath)
+	if err != nil {
+		utils.GetLogger().Info(fmt.Sprintf("no tags read from %s: %v", songFilePath, err))
+	} else {
+		song = SongFromTags(metadata, song)
+	}
+	if song.Title == "" {
+		song.Title = strings.TrimSuffix(filepath.Base(songFilePath), filepath.Ext(songFilePath))
+	}
+
+	song.ContentHash = ContentHash(wavInfo.Data)
+	song.Duration = wavInfo.Duration
+	if song.SourcePath == "" {
+		song.SourcePath = songFilePath
+	}
+
+	return ix.index(song, wavChannels(wavInfo), wavInfo.SampleRate, func(songID uint32) (map[int64]models.Couple, error) {
+		return ix.Config.fingerprintWav(wavInfo, songID)
+	})
+}
+
+// IndexSamples indexes mono samples as song, hashing them as 16-bit PCM.
+func (ix *Indexer) IndexSamples(samples []float64, sampleRate int, song db.Song) (IndexResult, error) {
+	return ix.IndexChannels([][]float64{samples}, sampleRate, song)
+}
+
+// IndexChannels indexes decoded mono or stereo audio as song, hashing it as
+// interleaved 16-bit PCM like IndexFile does.
+func (ix *Indexer) IndexChannels(channels [][]float64, sampleRate int, song db.Song) (IndexResult, error) {
+	if len(channels) == 0 || len(channels[0]) == 0 {
+		return IndexResult{}, fmt.Errorf("no samples to index")
+	}
+
+	interleaved := make([]float64, 0, len(channels)*len(channels[0]))
+	for i := range channels[0] {
+		for _, channel := range channels {
+			if i < len(channel) {
+				interleaved = append(interleaved, channel[i])
+			}
+		}
+	}
+	pcm, err := utils.FloatsToBytes(interleaved, 16)
+	if err != nil {
+		return IndexResult{}, err
+	}
+	song.ContentHash = ContentHash(pcm)
+	song.Duration = float64(len(channels[0])) / float64(sampleRate)
+
+	return ix.index(song, channels, sampleRate, func(songID uint32) (map[int64]models.Couple, error) {
+		return ix.Config.fingerprintChannels(channels, sampleRate, songID)
+	})
+}
+
+/*
+index registers the song or finds the one it duplicates. A duplicate that was fully
+fingerprinted is skipped; one whose ingestion was interrupted is fingerprinted again,
+which is safe because storing a fingerprint twice is a no-op. The song is only marked
+as fingerprinted once every fingerprint is stored.
+*/
+func (ix *Indexer) index(song db.Song, channels [][]float64, sampleRate int, fingerprint func(songID uint32) (map[int64]models.Couple, error)) (IndexResult, error) {
+	songID, err := ix.DB.RegisterOrGetSong(song)
+	if errors.Is(err, db.ErrSongExists) {
+		existing, found, err := ix.DB.GetSongByID(songID)
+		if err != nil {
+			return IndexResult{}, fmt.Errorf("error looking up existing song %d: %w", songID, err)
+		}
+		if found && existing.Fingerprinted {
+			return IndexResult{SongID: songID, Skipped: true}, nil
+		}
+	} else if err != nil {
+		return IndexResult{}, fmt.Errorf("error registering %q: %w", song.Title, err)
+	}
+
+	fingerprints, err := fingerprint(songID)
+	if err != nil {
+		return IndexResult{}, fmt.Errorf("error fingerprinting %q: %w", song.Title, err)
+	}
+
+	fingerprints, err = ix.dropStopWords(fingerprints)
+	if err != nil {
+		return IndexResult{}, fmt.Errorf("error filtering stop words of %q: %w", song.Title, err)
+	}
+
+	if err := ix.DB.StoreFingerprints(fingerprints); err != nil {
+		return IndexResult{}, fmt.Errorf("error storing fingerprints of %q: %w", song.Title, err)
+	}
+
+	if ix.Covers != nil {
+		if err := ix.Covers.Add(songID, channels, sampleRate); err != nil {
+			return IndexResult{}, fmt.Errorf("error adding %q to the cover index: %w", song.Title, err)
+		}
+	}
+
+	if err := ix.DB.MarkSongFingerprinted(songID); err != nil {
+		return IndexResult{}, fmt.Errorf("error marking %q as fingerprinted: %w", song.Title, err)
+	}
+
+	return IndexResult{SongID: songID, Fingerprints: len(fingerprints)}, nil
+}
+
+// SongFromTags fills the fields of song that are still empty from the format and
+// stream tags ffprobe found. GetMetadata has already lower-cased the tag names.
+func SongFromTags(metadata wav.FFMPEGMetaData, song db.Song) db.Song {
+	tags := map[string]string{}
+	for _, stream := range metadata.Streams {
+		for name, value := range stream.Tags {
+			tags[strings.ToLower(name)] = value
+		}
+	}
+	// container tags win over stream tags
+	for name, value := range metadata.Format.Tags {
+		tags[strings.ToLower(name)] = value
+	}
+
+	tag := func(names ...string) string {
+		for _, name := range names {
+			if value := strings.TrimSpace(tags[name]); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	fill := func(field *string, names ...string) {
+		if *field == "" {
+			*field = tag(names...)
+		}
+	}
+	fill(&song.Title, "title")
+	fill(&song.Artist, "artist", "album_artist")
+	fill(&song.Album, "album")
+	fill(&song.Genre, "genre")
+	// ID3 keeps the ISRC in TSRC, Vorbis comments and MP4 call it ISRC
+	fill(&song.ISRC, "isrc", "tsrc")
+
+	if song.ReleaseYear == 0 {
+		song.ReleaseYear = releaseYear(tag("date", "year", "tdrc", "tyer", "originaldate"))
+	}
+
+	if song.Duration == 0 {
+		if duration, err := strconv.ParseFloat(metadata.Format.Duration, 64); err == nil {
+			song.Duration = duration
+		}
+	}
+
+	return song
+}
+
+// releaseYear takes the year out of dates like "1999", "1999-03-01" or "1999/03".
+func releaseYear(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(date[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+// ContentHash is the hex SHA-256 of decoded audio.
+func ContentHash(pcm []byte) string {
+	sum := sha256.Sum256(pcm)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"fmt"
+	"shazoom/models"
+	"sort"
+)
+
+// Pairing selects how anchors are paired with target peaks.
+type Pairing string
+
+const (
+	// NextPeaksPairing pairs every anchor with the next targetZoneSize peaks in list
+	// order. Peaks from the same frame pair with a delta of 0. This is what existing
+	// indexes were built with.
+	NextPeaksPairing Pairing = "next-peaks"
+	// TargetZonePairing pairs an anchor only with peaks inside a window of time and
+	// frequency ahead of it.
+	TargetZonePairing Pairing = "target-zone"
+)
+
+const (
+	tripletFlagBit   = 62
+	tripletFreqBits  = maxFreqBits
+	tripletDeltaBits = maxDeltaBits
+)
+
+// TargetZoneOptions bound the zone a TargetZonePairing anchor looks for targets in.
+type TargetZoneOptions struct {
+	// Targets start MinDeltaMs after the anchor and end MaxDeltaMs after it, inclusive.
+	// A MinDeltaMs above zero keeps peaks of the same frame from pairing.
+	MinDeltaMs int
+	MaxDeltaMs int
+	// FreqWindow is how many bins above or below the anchor a target may be. 0 allows any.
+	FreqWindow int
+	// FanOut is the number of targets, nearest in time first, paired with each anchor.
+	FanOut int
+	// Triplets hashes the anchor with two targets at once instead of one, which is far
+	// more specific at the price of needing three peaks to survive instead of two.
+	Triplets bool
+}
+
+func DefaultTargetZoneOptions() TargetZoneOptions {
+	return TargetZoneOptions{
+		MinDeltaMs: 40,
+		MaxDeltaMs: 2000,
+		FreqWindow: 128,
+		FanOut:     5,
+	}
+}
+
+// Fingerprint pairs the peaks with the configured strategy and address layout.
+func (c Config) Fingerprint(peaks []Peak, songID uint32) map[int64]models.Couple {
+	return c.fingerprintTagged(peaks, songID, 0)
+}
+
+// fingerprintTagged is Fingerprint with tag in the extra byte of every address.
+func (c Config) fingerprintTagged(peaks []Peak, songID uint32, tag int) map[int64]models.Couple {
+	switch c.Pairing {
+	case TargetZonePairing:
+		return fingerprintTargetZone(peaks, songID, c.TargetZone, c.Address, tag)
+	default:
+		return fingerprintNextPeaks(peaks, songID, c.Address, tag)
+	}
+}
+
+// FingerprintTargetZone pairs every anchor with up to opts.FanOut peaks of its target zone,
+// or with every two of them when opts.Triplets is set.
+func FingerprintTargetZone(peaks []Peak, songID uint32, opts TargetZoneOptions) map[int64]models.Couple {
+	return fingerprintTargetZone(peaks, songID, opts, AddressV1, 0)
+}
+
+func fingerprintTargetZone(peaks []Peak, songID uint32, opts TargetZoneOptions, version AddressVersion, extra int) map[int64]models.Couple {
+	sorted := make([]Peak, len(peaks))
+	copy(sorted, peaks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Time != sorted[j].Time {
+			return sorted[i].Time < sorted[j].Time
+		}
+		return sorted[i].Bin < sorted[j].Bin
+	})
+
+	fingerprints := map[int64]models.Couple{}
+	var zone []Peak
+
+	for i, anchor := range sorted {
+		zone = targetZone(sorted, i, opts, zone[:0])
+		couple := models.Couple{
+			AnchorTime: uint32(anchor.Time * 1000),
+			SongId:     songID,
+		}
+
+		if !opts.Triplets {
+			for _, target := range zone {
+				address, err := version.pairAddress(anchor, target, extra)
+				if err != nil {
+					continue
+				}
+				fingerprints[address] = couple
+			}
+			continue
+		}
+
+		for a := 0; a < len(zone); a++ {
+			for b := a + 1; b < len(zone); b++ {
+				address, err := version.tripletAddress(anchor, zone[a], zone[b], extra)
+				if err != nil {
+					continue
+				}
+				fingerprints[address] = couple
+			}
+		}
+	}
+
+	return fingerprints
+}
+
+// targetZone appends the first opts.FanOut peaks after sorted[anchorIdx] that fall
+// inside its zone to zone.
+func targetZone(sorted []Peak, anchorIdx int, opts TargetZoneOptions, zone []Peak) []Peak {
+	anchor := sorted[anchorIdx]
+
+	for j := anchorIdx + 1; j < len(sorted) && len(zone) < opts.FanOut; j++ {
+		target := sorted[j]
+
+		deltaMs := peakDeltaMs(anchor, target)
+		if deltaMs > int64(opts.MaxDeltaMs) {
+			break
+		}
+		if deltaMs < int64(opts.MinDeltaMs) {
+			continue
+		}
+		if opts.FreqWindow > 0 && abs(target.Bin-anchor.Bin) > opts.FreqWindow {
+			continue
+		}
+
+		zone = append(zone, target)
+	}
+
+	return zone
+}
+
+func createTripletAddress(anchor, first, second Peak) (int64, error) {
+	return PackTripletAddress(anchor.Bin, first.Bin, second.Bin, peakDeltaMs(anchor, first), peakDeltaMs(anchor, second))
+}
+
+/*
+PackTripletAddress packs an anchor and two targets into one address:
+
+	| 1 | anchor bin (9) | first bin (9) | second bin (9) | first delta ms (14) | second delta ms (14) |
+
+The flag at bit 62 keeps triplet addresses apart from pair addresses, and the version
+bits are set like PackAddress sets them. This is the AddressV1 triplet; see
+PackTripletAddressV2.
+*/
+func PackTripletAddress(anchorBin, firstBin, secondBin int, firstDeltaMs, secondDeltaMs int64) (int64, error) {
+	const maxBin = 1 << tripletFreqBits
+	const maxDelta = 1 << tripletDeltaBits
+
+	for _, bin := range []int{anchorBin, firstBin, secondBin} {
+		if bin < 0 || bin >= maxBin {
+			return 0, fmt.Errorf("bin %d out of range [0, %d)", bin, maxBin)
+		}
+	}
+	for _, delta := range []int64{firstDeltaMs, secondDeltaMs} {
+		if delta < 0 || delta >= maxDelta {
+			return 0, fmt.Errorf("delta %d ms out of range [0, %d)", delta, maxDelta)
+		}
+	}
+
+	address := int64(1) << tripletFlagBit
+	address |= int64(AddressV1) << addressVersionShift
+	address |= int64(anchorBin) << (2*tripletFreqBits + 2*tripletDeltaBits)
+	address |= int64(firstBin) << (tripletFreqBits + 2*tripletDeltaBits)
+	address |= int64(secondBin) << (2 * tripletDeltaBits)
+	address |= firstDeltaMs << tripletDeltaBits
+	address |= secondDeltaMs
+
+	return address, nil
+}
+
+func unpackTripletAddress(address int64) (anchorBin, firstBin, secondBin int, firstDeltaMs, secondDeltaMs int64) {
+	anchorBin = int(address>>(2*tripletFreqBits+2*tripletDeltaBits)) & (1<<tripletFreqBits - 1)
+	firstBin = int(address>>(tripletFreqBits+2*tripletDeltaBits)) & (1<<tripletFreqBits - 1)
+	secondBin = int(address>>(2*tripletDeltaBits)) & (1<<tripletFreqBits - 1)
+	firstDeltaMs = address >> tripletDeltaBits & (1<<tripletDeltaBits - 1)
+	secondDeltaMs = address & (1<<tripletDeltaBits - 1)
+	return anchorBin, firstBin, secondBin, firstDeltaMs, secondDeltaMs
+}
+
+// IsTripletAddress reports whether address was built by PackTripletAddress.
+func IsTripletAddress(address int64) bool {
+	return address>>tripletFlagBit&1 == 1
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
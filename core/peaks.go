@@ -0,0 +1,178 @@
+package core
+
+import (
+	"sort"
+)
+
+// PeakPicker names a constellation extractor. Songs have to be queried with the
+// picker they were indexed with, otherwise the hashes never line up.
+type PeakPicker string
+
+const (
+	// BandPeaks is ExtractPeaks: the loudest bin of six fixed bands per frame, kept
+	// when it beats the average of the six.
+	BandPeaks PeakPicker = "bands"
+	// LocalMaximaPeaks is ExtractLocalMaxima: 2-D local maxima with an adaptive
+	// threshold and a cap on peaks per second.
+	LocalMaximaPeaks PeakPicker = "local-maxima"
+)
+
+type LocalMaximaOptions struct {
+	// TimeRadius and FreqRadius size the neighbourhood (in frames and bins on either
+	// side) a peak has to be the maximum of.
+	TimeRadius int
+	FreqRadius int
+	// ThresholdFactor is how many times louder than the mean of its neighbourhood a
+	// peak has to be, so flat noise floors don't produce peaks.
+	ThresholdFactor float64
+	// PeaksPerSecond caps the density: in every second only the strongest peaks are kept.
+	PeaksPerSecond int
+}
+
+func DefaultLocalMaximaOptions() LocalMaximaOptions {
+	return LocalMaximaOptions{
+		TimeRadius:      3,
+		FreqRadius:      10,
+		ThresholdFactor: 3,
+		PeaksPerSecond:  20,
+	}
+}
+
+/*
+ExtractLocalMaxima finds the points of the spectrogram that are the maximum of their
+(2*TimeRadius+1) x (2*FreqRadius+1) neighbourhood and at least ThresholdFactor times
+its mean (or the equivalent number of dB above it on a log scaled spectrogram). Unlike
+ExtractPeaks this adapts to the level of the signal: a loud frame does not
+automatically yield peaks in every band, and a quiet passage yields peaks only where
+something actually stands out. The survivors are thinned to PeaksPerSecond per
+one second window, strongest first, and returned in time order.
+*/
+func ExtractLocalMaxima(spectrogram [][]float64, sampleRate int, spectro SpectrogramOptions, opts LocalMaximaOptions) []Peak {
+	numFrames := len(spectrogram)
+	if numFrames < 1 {
+		return []Peak{}
+	}
+	numBins := len(spectrogram[0])
+
+	frameDuration := spectro.FrameDuration(sampleRate)
+	binFreqs := spectro.BinFrequencies(sampleRate)
+
+	localMax := maxFilter(spectrogram, opts.TimeRadius, opts.FreqRadius)
+	sums := prefixSums(spectrogram)
+
+	type candidate struct {
+		frame, bin int
+		mag        float64
+	}
+
+	windows := make([][]candidate, int(float64(numFrames)*frameDuration)+1)
+
+	for frameIdx, frame := range spectrogram {
+		// bin 0 is the DC offset, not a note
+		for bin := 1; bin < numBins; bin++ {
+			mag := frame[bin]
+			if mag <= 0 || mag < localMax[frameIdx][bin] {
+				continue
+			}
+
+			mean := sums.boxMean(frameIdx-opts.TimeRadius, bin-opts.FreqRadius, frameIdx+opts.TimeRadius, bin+opts.FreqRadius)
+			if !spectro.exceeds(mag, mean, opts.ThresholdFactor) {
+				continue
+			}
+
+			w := int(float64(frameIdx) * frameDuration)
+			windows[w] = append(windows[w], candidate{frameIdx, bin, mag})
+		}
+	}
+
+	var peaks []Peak
+	for _, window := range windows {
+		if opts.PeaksPerSecond > 0 && len(window) > opts.PeaksPerSecond {
+			sort.Slice(window, func(i, j int) bool { return window[i].mag > window[j].mag })
+			window = window[:opts.PeaksPerSecond]
+		}
+
+		sort.Slice(window, func(i, j int) bool {
+			if window[i].frame != window[j].frame {
+				return window[i].frame < window[j].frame
+			}
+			return window[i].bin < window[j].bin
+		})
+
+		for _, c := range window {
+			peaks = append(peaks, Peak{
+				Time: float64(c.frame) * frameDuration,
+				Freq: binFreqs[c.bin],
+				Bin:  c.bin,
+			})
+		}
+	}
+
+	return peaks
+}
+
+// maxFilter returns, for every cell, the maximum over its neighbourhood. The filter is
+// separable, so it runs along frequency first and then along time.
+func maxFilter(spectrogram [][]float64, timeRadius, freqRadius int) [][]float64 {
+	numFrames, numBins := len(spectrogram), len(spectrogram[0])
+
+	alongFreq := make([][]float64, numFrames)
+	for t, frame := range spectrogram {
+		alongFreq[t] = make([]float64, numBins)
+		for f := range frame {
+			lo, hi := max(0, f-freqRadius), min(numBins-1, f+freqRadius)
+			m := frame[lo]
+			for k := lo + 1; k <= hi; k++ {
+				if frame[k] > m {
+					m = frame[k]
+				}
+			}
+			alongFreq[t][f] = m
+		}
+	}
+
+	result := make([][]float64, numFrames)
+	for t := range result {
+		result[t] = make([]float64, numBins)
+		lo, hi := max(0, t-timeRadius), min(numFrames-1, t+timeRadius)
+		for f := 0; f < numBins; f++ {
+			m := alongFreq[lo][f]
+			for k := lo + 1; k <= hi; k++ {
+				if alongFreq[k][f] > m {
+					m = alongFreq[k][f]
+				}
+			}
+			result[t][f] = m
+		}
+	}
+
+	return result
+}
+
+// summedArea is a 2-D prefix sum table: cell [t+1][f+1] holds the sum of all
+// magnitudes in frames <= t and bins <= f.
+type summedArea [][]float64
+
+func prefixSums(spectrogram [][]float64) summedArea {
+	numFrames, numBins := len(spectrogram), len(spectrogram[0])
+
+	sums := make(summedArea, numFrames+1)
+	sums[0] = make([]float64, numBins+1)
+	for t, frame := range spectrogram {
+		sums[t+1] = make([]float64, numBins+1)
+		for f, mag := range frame {
+			sums[t+1][f+1] = mag + sums[t][f+1] + sums[t+1][f] - sums[t][f]
+		}
+	}
+
+	return sums
+}
+
+// boxMean is the mean magnitude of the frames t0..t1 and bins f0..f1, clipped to the table.
+func (s summedArea) boxMean(t0, f0, t1, f1 int) float64 {
+	t0, f0 = max(0, t0), max(0, f0)
+	t1, f1 = min(len(s)-2, t1), min(len(s[0])-2, f1)
+
+	sum := s[t1+1][f1+1] - s[t0][f1+1] - s[t1+1][f0] + s[t0][f0]
+	return sum / float64((t1-t0+1)*(f1-f0+1))
+}
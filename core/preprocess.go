@@ -0,0 +1,363 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrSilentClip is returned, wrapped, for a recording with too little sound above the
+// silence threshold to fingerprint.
+var ErrSilentClip = errors.New("clip is silent")
+
+// Normalization selects how Preprocess levels a recording.
+type Normalization string
+
+const (
+	NoNormalization Normalization = ""
+	// RMSNormalization scales the recording to TargetDb dBFS RMS.
+	RMSNormalization Normalization = "rms"
+	// LoudnessNormalization scales it to TargetDb LUFS, measured like ITU-R BS.1770:
+	// K-weighted, in gated 400 ms blocks, so quiet passages don't drag the level down.
+	LoudnessNormalization Normalization = "lufs"
+)
+
+/*
+PreprocessOptions clean a recording up before its spectrogram is taken. The zero value
+does nothing, which is what existing indexes were built with. Silence is detected
+before normalisation, on the level the recording arrived at, so a boosted noise floor
+is never mistaken for sound.
+*/
+type PreprocessOptions struct {
+	// RemoveDC subtracts the mean, which a cheap microphone or sound card adds.
+	RemoveDC bool
+
+	Normalize Normalization
+	// TargetDb is the level Normalize aims for, in dBFS or LUFS.
+	TargetDb float64
+
+	// TrimSilence cuts leading and trailing stretches quieter than SilenceDb.
+	TrimSilence bool
+	// SilenceDb is the RMS level of a 20 ms window, in dBFS, below which it is silent.
+	// 0 means -60 dBFS, since a threshold at full scale would call everything silent.
+	SilenceDb float64
+	// MinGap also cuts silent stretches inside the recording that last at least
+	// MinGap seconds. 0 only trims the ends.
+	MinGap float64
+	// MinSound is the number of seconds above SilenceDb a recording needs, less is
+	// rejected with ErrSilentClip. It applies even when nothing is trimmed.
+	MinSound float64
+}
+
+const defaultSilenceDb = -60
+
+// DefaultPreprocessOptions turns every step on with levels that suit microphone
+// recordings and decoded songs alike.
+func DefaultPreprocessOptions() PreprocessOptions {
+	return PreprocessOptions{
+		RemoveDC:    true,
+		Normalize:   LoudnessNormalization,
+		TargetDb:    -23,
+		TrimSilence: true,
+		SilenceDb:   defaultSilenceDb,
+		MinGap:      1,
+		MinSound:    0.5,
+	}
+}
+
+func (o PreprocessOptions) enabled() bool {
+	return o.RemoveDC || o.Normalize != NoNormalization || o.TrimSilence || o.MinSound > 0
+}
+
+func (o PreprocessOptions) silenceDb() float64 {
+	if o.SilenceDb != 0 {
+		return o.SilenceDb
+	}
+	return defaultSilenceDb
+}
+
+// SilentStretch is a stretch Preprocess cut out, in seconds of the original recording.
+type SilentStretch struct {
+	Start float64
+	End   float64
+}
+
+// Preprocessed is a recording after Preprocess together with what was done to it.
+type Preprocessed struct {
+	Samples []float64
+	// Trimmed lists the stretches cut out, in order.
+	Trimmed []SilentStretch
+	// Gain is the factor the samples were multiplied by to normalise them.
+	Gain float64
+}
+
+// LeadingSilence is how many seconds were cut from the start of the recording.
+func (p Preprocessed) LeadingSilence() float64 {
+	if len(p.Trimmed) > 0 && p.Trimmed[0].Start == 0 {
+		return p.Trimmed[0].End
+	}
+	return 0
+}
+
+// OriginalTime maps a time in the preprocessed samples back onto the recording, by
+// adding the length of every stretch cut before it.
+func (p Preprocessed) OriginalTime(seconds float64) float64 {
+	for _, stretch := range p.Trimmed {
+		if seconds < stretch.Start {
+			break
+		}
+		seconds += stretch.End - stretch.Start
+	}
+	return seconds
+}
+
+// Preprocess runs the enabled steps over a mono recording. It never modifies samples.
+func (o PreprocessOptions) Preprocess(samples []float64, sampleRate int) (Preprocessed, error) {
+	processed := make([]float64, len(samples))
+	copy(processed, samples)
+
+	if o.RemoveDC {
+		removeDC(processed)
+	}
+
+	result := Preprocessed{Samples: processed, Gain: 1}
+
+	if o.TrimSilence || o.MinSound > 0 {
+		sound := audibleStretches(processed, sampleRate, o.silenceDb())
+
+		var audible float64
+		for _, stretch := range sound {
+			audible += stretch.End - stretch.Start
+		}
+		if audible < o.MinSound || len(sound) == 0 {
+			return Preprocessed{}, fmt.Errorf("%w: %.2fs above %.0f dBFS", ErrSilentClip, audible, o.silenceDb())
+		}
+
+		if o.TrimSilence {
+			result.Samples, result.Trimmed = trimSilence(processed, sampleRate, sound, o.MinGap)
+		}
+	}
+
+	var level float64
+	switch o.Normalize {
+	case NoNormalization:
+		return result, nil
+	case RMSNormalization:
+		level = rmsDb(result.Samples)
+	case LoudnessNormalization:
+		level = loudness(result.Samples, sampleRate)
+	default:
+		return Preprocessed{}, fmt.Errorf("unknown normalization %q", o.Normalize)
+	}
+
+	if math.IsInf(level, -1) {
+		return Preprocessed{}, fmt.Errorf("%w: no measurable level", ErrSilentClip)
+	}
+	result.Gain = math.Pow(10, (o.TargetDb-level)/20)
+	for i := range result.Samples {
+		result.Samples[i] *= result.Gain
+	}
+
+	return result, nil
+}
+
+func removeDC(samples []float64) {
+	if len(samples) == 0 {
+		return
+	}
+	var mean float64
+	for _, s := range samples {
+		mean += s
+	}
+	mean /= float64(len(samples))
+	for i := range samples {
+		samples[i] -= mean
+	}
+}
+
+func rmsDb(samples []float64) float64 {
+	var power float64
+	for _, s := range samples {
+		power += s * s
+	}
+	if len(samples) > 0 {
+		power /= float64(len(samples))
+	}
+	return 10 * math.Log10(power)
+}
+
+// silenceWindow is the length of the windows silence is measured over, in seconds.
+const silenceWindow = 0.02
+
+// audibleStretches returns the runs of windows louder than thresholdDb, in seconds.
+func audibleStretches(samples []float64, sampleRate int, thresholdDb float64) []SilentStretch {
+	window := max(1, int(silenceWindow*float64(sampleRate)))
+
+	var stretches []SilentStretch
+	inSound := false
+	for start := 0; start < len(samples); start += window {
+		end := min(start+window, len(samples))
+		loud := rmsDb(samples[start:end]) >= thresholdDb
+
+		switch {
+		case loud && !inSound:
+			stretches = append(stretches, SilentStretch{Start: float64(start) / float64(sampleRate)})
+			inSound = true
+		case !loud && inSound:
+			stretches[len(stretches)-1].End = float64(start) / float64(sampleRate)
+			inSound = false
+		}
+	}
+	if inSound {
+		stretches[len(stretches)-1].End = float64(len(samples)) / float64(sampleRate)
+	}
+	return stretches
+}
+
+// trimSilence cuts the silence before the first and after the last audible stretch,
+// and the gaps between them of at least minGap seconds when minGap is set.
+func trimSilence(samples []float64, sampleRate int, sound []SilentStretch, minGap float64) ([]float64, []SilentStretch) {
+	toSample := func(seconds float64) int {
+		return min(len(samples), int(math.Round(seconds*float64(sampleRate))))
+	}
+
+	keep := []SilentStretch{sound[0]}
+	for _, stretch := range sound[1:] {
+		last := &keep[len(keep)-1]
+		if minGap <= 0 || stretch.Start-last.End < minGap {
+			last.End = stretch.End
+			continue
+		}
+		keep = append(keep, stretch)
+	}
+
+	var trimmed []float64
+	var cut []SilentStretch
+	previousEnd := 0
+	for _, stretch := range keep {
+		start, end := toSample(stretch.Start), toSample(stretch.End)
+		if start > previousEnd {
+			cut = append(cut, SilentStretch{float64(previousEnd) / float64(sampleRate), float64(start) / float64(sampleRate)})
+		}
+		trimmed = append(trimmed, samples[start:end]...)
+		previousEnd = end
+	}
+	if previousEnd < len(samples) {
+		cut = append(cut, SilentStretch{float64(previousEnd) / float64(sampleRate), float64(len(samples)) / float64(sampleRate)})
+	}
+
+	return trimmed, cut
+}
+
+/*
+loudness measures samples in LUFS the way ITU-R BS.1770 does for one channel: the
+signal is K-weighted (a high shelf for the head and a high-pass that discounts the
+lowest bass), cut into 400 ms blocks overlapping by 75%, and the blocks quieter than
+-70 LUFS, then those more than 10 LU below the mean of the rest, are gated out.
+Recordings shorter than a block are measured as one block.
+*/
+func loudness(samples []float64, sampleRate int) float64 {
+	weighted := kWeighting(samples, float64(sampleRate))
+
+	block := int(0.4 * float64(sampleRate))
+	step := max(1, block/4)
+	if block > len(weighted) {
+		block = len(weighted)
+	}
+
+	var powers []float64
+	for start := 0; start+block <= len(weighted) && block > 0; start += step {
+		var power float64
+		for _, s := range weighted[start : start+block] {
+			power += s * s
+		}
+		powers = append(powers, power/float64(block))
+	}
+
+	lufs := func(power float64) float64 {
+		return -0.691 + 10*math.Log10(power)
+	}
+	gatedMean := func(threshold float64) (float64, int) {
+		var sum float64
+		var n int
+		for _, power := range powers {
+			if lufs(power) > threshold {
+				sum += power
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, 0
+		}
+		return sum / float64(n), n
+	}
+
+	mean, n := gatedMean(-70)
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	mean, _ = gatedMean(lufs(mean) - 10)
+	return lufs(mean)
+}
+
+// biquad is a second order IIR filter in direct form I.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+func (f biquad) apply(input []float64) []float64 {
+	output := make([]float64, len(input))
+	var x1, x2, y1, y2 float64
+	for i, x := range input {
+		y := f.b0*x + f.b1*x1 + f.b2*x2 - f.a1*y1 - f.a2*y2
+		x2, x1 = x1, x
+		y2, y1 = y1, y
+		output[i] = y
+	}
+	return output
+}
+
+// kWeighting designs the two BS.1770 pre-filters for any sample rate with the
+// bilinear transform, which gives back the coefficients the standard lists at 48 kHz.
+func kWeighting(samples []float64, sampleRate float64) []float64 {
+	// high shelf: +4 dB above about 1.7 kHz
+	const shelfGainDb, shelfHz, shelfQ = 3.99984385397, 1681.97445095, 0.7071752369554193
+	k := math.Tan(math.Pi * shelfHz / sampleRate)
+	vh := math.Pow(10, shelfGainDb/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	// high-pass at about 38 Hz
+	const passHz, passQ = 38.1354709701, 0.5003270373238773
+	k = math.Tan(math.Pi * passHz / sampleRate)
+	a0 = 1 + k/passQ + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/passQ + k*k) / a0,
+	}
+
+	return highPass.apply(shelf.apply(samples))
+}
+
+// remapPeakTimes moves peaks found in preprocessed samples back onto the timeline of
+// the recording, so anchor times and deltas are those of the untrimmed audio.
+func (p Preprocessed) remapPeakTimes(peaks []Peak) []Peak {
+	if len(p.Trimmed) == 0 {
+		return peaks
+	}
+	// OriginalTime never decreases, so the peaks stay in order
+	for i := range peaks {
+		peaks[i].Time = p.OriginalTime(peaks[i].Time)
+	}
+	return peaks
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"sort"
+)
+
+const (
+	// streakToleranceMs is the largest gap between neighbouring deltas of a streak.
+	streakToleranceMs = 3
+	// offsetBinWidthMs is the width of the offset buckets duplicate detection votes into.
+	offsetBinWidthMs = 50
+)
+
+// TimingScore is the outcome of the timing analysis for one candidate song.
+type TimingScore struct {
+	Score float64
+	// OffsetMs is the dominant dbTime - sampleTime, i.e. where in the song the sample starts.
+	OffsetMs int32
+	// ToleranceMs is how far the true offset may sit from OffsetMs in either direction.
+	ToleranceMs int32
+}
+
+// TimingScorer turns the (sampleTime, dbTime) pairs collected for each candidate
+// song into a score, the higher the more likely the sample came from that song.
+type TimingScorer func(matches map[uint32][][2]uint32) map[uint32]TimingScore
+
+/*
+for each song in the database, we increase the count of the score
+if the delta between the sampleTime and the songTime is consistent for all/most of the anchor peaks.
+And then the song with the most consistent time delta will gain the highest score.
+StreakScorer does this; HistogramScorer is no more accurate over TestScorerAccuracy's
+grid at any bin width, so it stays opt-in.
+*/
+func analyzeRelativeTiming(matches map[uint32][][2]uint32) map[uint32]TimingScore {
+	return StreakScorer(streakToleranceMs)(matches)
+}
+
+/*
+HistogramScorer votes every delta (dbTime - sampleTime) of a song into a bucket
+binWidthMs wide. A clip cut from the song puts most of its hits into the bucket of
+the true offset, while random collisions scatter over the whole song, so the score
+is the height of the tallest bucket. With neighbours > 0 the buckets on either side
+of the peak are added as well, which catches offsets sitting right on a bin edge.
+
+The reported offset is the median of the deltas that voted for the winning window,
+and the tolerance is half the width of that window.
+*/
+func HistogramScorer(binWidthMs int32, neighbours int) TimingScorer {
+	if binWidthMs <= 0 {
+		binWidthMs = 1
+	}
+	if neighbours < 0 {
+		neighbours = 0
+	}
+	span := int32(neighbours)
+
+	return func(matches map[uint32][][2]uint32) map[uint32]TimingScore {
+		scores := make(map[uint32]TimingScore)
+
+		for songId, times := range matches {
+			if len(times) == 0 {
+				continue
+			}
+
+			deltas := make([]int32, len(times))
+			histogram := make(map[int32]int)
+			for i, timePair := range times {
+				deltas[i] = int32(timePair[1]) - int32(timePair[0])
+				histogram[offsetBin(deltas[i], binWidthMs)]++
+			}
+
+			maxVotes := 0
+			var peakBin int32
+			for bin := range histogram {
+				votes := histogram[bin]
+				for k := int32(1); k <= span; k++ {
+					votes += histogram[bin-k] + histogram[bin+k]
+				}
+				if votes > maxVotes || (votes == maxVotes && bin < peakBin) {
+					maxVotes = votes
+					peakBin = bin
+				}
+			}
+
+			voters := make([]int32, 0, maxVotes)
+			for _, delta := range deltas {
+				if bin := offsetBin(delta, binWidthMs); bin >= peakBin-span && bin <= peakBin+span {
+					voters = append(voters, delta)
+				}
+			}
+
+			scores[songId] = TimingScore{
+				Score:       float64(maxVotes),
+				OffsetMs:    medianDelta(voters),
+				ToleranceMs: binWidthMs * (2*span + 1) / 2,
+			}
+		}
+
+		return scores
+	}
+}
+
+// offsetBin floors the delta into its bucket, so negative deltas don't share bucket 0.
+func offsetBin(delta, binWidthMs int32) int32 {
+	bin := delta / binWidthMs
+	if delta%binWidthMs != 0 && delta < 0 {
+		bin--
+	}
+	return bin
+}
+
+func medianDelta(deltas []int32) int32 {
+	if len(deltas) == 0 {
+		return 0
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+	return deltas[len(deltas)/2]
+}
+
+// StreakScorer is the default scorer: it sorts the deltas and counts the longest run
+// where neighbouring deltas differ by at most toleranceMs.
+func StreakScorer(toleranceMs int32) TimingScorer {
+	return func(matches map[uint32][][2]uint32) map[uint32]TimingScore {
+		scores := make(map[uint32]TimingScore)
+
+		for songId, times := range matches {
+			n := len(times)
+			if n == 0 {
+				continue
+			}
+
+			// collect deltas: dbTime - sampleTime
+			deltas := make([]int32, n)
+			for i, timePair := range times {
+				sampleTime := int32(timePair[0])
+				dbTime := int32(timePair[1])
+				deltas[i] = dbTime - sampleTime
+			}
+
+			sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+
+			// find longest streak where neighbouring deltas differ <= tolerance
+			maxStreak, maxStart := 1, 0
+			streak, start := 1, 0
+			for i := 1; i < n; i++ {
+				if deltas[i]-deltas[i-1] <= toleranceMs {
+					streak++
+				} else {
+					if streak > maxStreak {
+						maxStreak, maxStart = streak, start
+					}
+					streak, start = 1, i
+				}
+			}
+			if streak > maxStreak {
+				maxStreak, maxStart = streak, start
+			}
+
+			run := deltas[maxStart : maxStart+maxStreak]
+			scores[songId] = TimingScore{
+				Score:       float64(maxStreak),
+				OffsetMs:    run[len(run)/2],
+				ToleranceMs: (run[len(run)-1] - run[0] + 1) / 2,
+			}
+		}
+
+		return scores
+	}
+}
@@ -0,0 +1,388 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// SegmentOptions configure RecognizeSegments.
+type SegmentOptions struct {
+	// Windows of WindowSeconds are recognised every HopSeconds.
+	WindowSeconds float64
+	HopSeconds    float64
+	// MinScore is the score a window's best match needs to count.
+	MinScore float64
+	// MaxDriftMs is how far the offset of a window may sit from its segment's and
+	// still continue it, which absorbs the scorer's tolerance and small tempo drift.
+	MaxDriftMs float64
+	// MaxGapWindows unmatched windows in a row, a voice over or a scratch, don't end a
+	// segment when the song carries on at the same offset after them. Windows matching
+	// another song count as unmatched until there are more of them than that.
+	MaxGapWindows int
+	// MinWindows drops segments matched by fewer windows, which are usually chance.
+	MinWindows int
+}
+
+func DefaultSegmentOptions() SegmentOptions {
+	return SegmentOptions{
+		WindowSeconds: 10,
+		HopSeconds:    5,
+		MinScore:      10,
+		MaxDriftMs:    500,
+		MaxGapWindows: 2,
+		MinWindows:    2,
+	}
+}
+
+// Segment is a stretch of a long recording recognised as one song.
+type Segment struct {
+	SongID uint32
+	Title  string
+	Artist string
+	// Start and End are in seconds of the recording.
+	Start float64
+	End   float64
+	// SongOffset is where in the song the segment starts, in seconds.
+	SongOffset float64
+	// Confidence is the share of the windows between the segment's first and last
+	// that matched it, Score their mean score.
+	Confidence float64
+	Score      float64
+	Windows    int
+}
+
+// windowMatch is the best match of one window, with the part of the window whose
+// hashes voted for it. Times are in ms from the window's start until Add moves them
+// to the stream's.
+type windowMatch struct {
+	songID    uint32
+	score     float64
+	alignment float64
+	first     float64
+	last      float64
+}
+
+/*
+RecognizeSegments slides a window over a long recording such as a radio log or a DJ
+mix and turns the windows into a tracklist. Consecutive windows whose best match is
+the same song at the same alignment (where the song's start falls in the recording)
+are merged into one segment. A segment spans the hashes that voted for it, not the
+whole windows, so its boundaries are finer than the hop.
+*/
+func (m *Matcher) RecognizeSegments(channels [][]float64, sampleRate int, opts SegmentOptions) ([]Segment, error) {
+	if opts.WindowSeconds <= 0 || opts.HopSeconds <= 0 {
+		return nil, fmt.Errorf("window and hop must be positive, got %gs and %gs", opts.WindowSeconds, opts.HopSeconds)
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no channels to recognise")
+	}
+
+	window := int(opts.WindowSeconds * float64(sampleRate))
+	hop := int(opts.HopSeconds * float64(sampleRate))
+	length := len(channels[0])
+
+	// the whole recording is at hand, so overlaps are split below rather than
+	// trimmed as they are found
+	tracker := &SegmentTracker{matcher: m, opts: opts}
+	var segments []Segment
+	for start := 0; start < length; start += hop {
+		end := min(start+window, length)
+		// a tail shorter than the hop is covered by the window before it
+		if end-start < hop && start > 0 {
+			break
+		}
+
+		slices := make([][]float64, len(channels))
+		for i, channel := range channels {
+			slices[i] = channel[start:min(end, len(channel))]
+		}
+
+		_, ended, err := tracker.Add(slices, sampleRate, float64(start)/float64(sampleRate))
+		if err != nil {
+			return nil, err
+		}
+		if ended != nil {
+			segments = append(segments, *ended)
+		}
+	}
+
+	if ended := tracker.Close(); ended != nil {
+		segments = append(segments, *ended)
+	}
+
+	// overlapping windows can let two segments claim the same stretch, split it
+	for i := 1; i < len(segments); i++ {
+		if previous := &segments[i-1]; segments[i].Start < previous.End {
+			boundary := (segments[i].Start + previous.End) / 2
+			previous.End = boundary
+			segments[i].SongOffset = math.Max(0, segments[i].SongOffset+boundary-segments[i].Start)
+			segments[i].Start = boundary
+		}
+	}
+	return segments, nil
+}
+
+// RecognizeSegmentsInFile decodes an audio file of any format and segments it.
+func (m *Matcher) RecognizeSegmentsInFile(path string, opts SegmentOptions) ([]Segment, error) {
+	wavInfo, err := decodeSong(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.RecognizeSegments(wavChannels(wavInfo), wavInfo.SampleRate, opts)
+}
+
+/*
+SegmentTracker is RecognizeSegments for audio that arrives over time, a broadcast say.
+It is fed one window at a time and reports a segment once MinWindows windows confirm
+it, and again when it ends. The end of a segment has been reported by the time the
+next one is confirmed, so one that starts inside it, as the windows overlap, starts
+where it was reported to end instead of at the midpoint.
+
+Another song taking over a confirmed segment is only believed once it has lasted more
+than MaxGapWindows windows, and the segment it starts then begins with its first
+window. A takeover still that short when the stream is closed is dropped as a gap.
+*/
+type SegmentTracker struct {
+	matcher *Matcher
+	opts    SegmentOptions
+	windows int
+	current *openSegment
+	// pending collects the windows matching another song than a confirmed current
+	pending *openSegment
+	lastEnd float64
+	// streaming trims overlaps to lastEnd, RecognizeSegments splits them afterwards
+	streaming bool
+}
+
+type openSegment struct {
+	segment   Segment
+	alignment float64
+	firstIdx  int
+	lastIdx   int
+	scoreSum  float64
+	confirmed bool
+}
+
+func (m *Matcher) NewSegmentTracker(opts SegmentOptions) *SegmentTracker {
+	return &SegmentTracker{matcher: m, opts: opts, streaming: true}
+}
+
+/*
+Add recognises the window of channels that starts start seconds into the stream.
+started is the segment this window confirmed and ended the one that ended before it;
+either or both may be nil.
+*/
+func (t *SegmentTracker) Add(channels [][]float64, sampleRate int, start float64) (started, ended *Segment, err error) {
+	match, err := t.matcher.matchWindow(channels, sampleRate, t.opts.MinScore)
+	if err != nil {
+		return nil, nil, fmt.Errorf("window at %.1fs: %w", start, err)
+	}
+	if match != nil {
+		match.alignment += 1000 * start
+		match.first += 1000 * start
+		match.last += 1000 * start
+	}
+
+	index := t.windows
+	t.windows++
+
+	if t.current != nil && index-t.current.lastIdx-1 > t.opts.MaxGapWindows {
+		pending := t.pending
+		ended = t.Close()
+		t.current = pending
+	}
+
+	switch {
+	case match == nil:
+	case t.current.continues(match, t.opts.MaxDriftMs):
+		t.current.add(match, index)
+		t.pending = nil
+	case t.current != nil && t.current.confirmed:
+		// a gap until it lasts longer than MaxGapWindows
+		if !t.pending.continues(match, t.opts.MaxDriftMs) {
+			t.pending = newOpenSegment(match, index)
+		}
+		t.pending.add(match, index)
+	default:
+		if closed := t.Close(); closed != nil {
+			ended = closed
+		}
+		t.current = newOpenSegment(match, index)
+		t.current.add(match, index)
+	}
+
+	current := t.current
+	if current != nil && !current.confirmed && current.segment.Windows >= max(1, t.opts.MinWindows) {
+		current.confirmed = true
+		if t.streaming {
+			current.segment.Start = math.Max(current.segment.Start, t.lastEnd)
+		}
+
+		song, found, err := t.matcher.DB.GetSongByID(current.segment.SongID)
+		if err != nil {
+			return nil, ended, fmt.Errorf("error looking up song %d: %w", current.segment.SongID, err)
+		}
+		if found {
+			current.segment.Title = song.Title
+			current.segment.Artist = song.Artist
+		}
+
+		segment := current.finish()
+		started = &segment
+	}
+	return started, ended, nil
+}
+
+// Close ends the open segment, at the end of the stream, and returns it if it was
+// confirmed.
+func (t *SegmentTracker) Close() *Segment {
+	current := t.current
+	t.current, t.pending = nil, nil
+	if current == nil || !current.confirmed {
+		return nil
+	}
+
+	segment := current.finish()
+	t.lastEnd = segment.End
+	return &segment
+}
+
+func newOpenSegment(match *windowMatch, index int) *openSegment {
+	return &openSegment{
+		segment:   Segment{SongID: match.songID, Start: match.first / 1000, End: match.last / 1000},
+		alignment: match.alignment,
+		firstIdx:  index,
+	}
+}
+
+// continues reports whether match is the same song at the same alignment as o, which
+// may be nil.
+func (o *openSegment) continues(match *windowMatch, maxDriftMs float64) bool {
+	return o != nil && o.segment.SongID == match.songID && math.Abs(match.alignment-o.alignment) <= maxDriftMs
+}
+
+func (o *openSegment) add(match *windowMatch, index int) {
+	o.lastIdx = index
+	o.scoreSum += match.score
+	o.segment.Windows++
+	o.segment.Start = math.Min(o.segment.Start, match.first/1000)
+	o.segment.End = math.Max(o.segment.End, match.last/1000)
+}
+
+func (o *openSegment) finish() Segment {
+	segment := o.segment
+	segment.Score = o.scoreSum / float64(segment.Windows)
+	segment.Confidence = float64(segment.Windows) / float64(o.lastIdx-o.firstIdx+1)
+	segment.SongOffset = math.Max(0, segment.Start*1000-o.alignment) / 1000
+	return segment
+}
+
+// matchWindow recognises one window. It returns nil when nothing scores MinScore.
+func (m *Matcher) matchWindow(channels [][]float64, sampleRate int, minScore float64) (*windowMatch, error) {
+	sample, err := m.Config.SampleFingerprints(channels, sampleRate)
+	if errors.Is(err, ErrSilentClip) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]int64, 0, len(sample))
+	for address := range sample {
+		addresses = append(addresses, address)
+	}
+	couples, err := m.getCouples(addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := CollectMatches(sample, couples)
+	var best *windowMatch
+	var bestTiming TimingScore
+	for songID, timing := range analyzeRelativeTiming(matches) {
+		if timing.Score < minScore {
+			continue
+		}
+		if best == nil || timing.Score > best.score || (timing.Score == best.score && songID < best.songID) {
+			best = &windowMatch{songID: songID, score: timing.Score}
+			bestTiming = timing
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	// the recording time, relative to the window, where the song starts
+	best.alignment = -float64(bestTiming.OffsetMs)
+	best.first, best.last = math.Inf(1), math.Inf(-1)
+	for _, pair := range matches[best.songID] {
+		delta := int32(pair[1]) - int32(pair[0])
+		if abs(int(delta-bestTiming.OffsetMs)) > int(bestTiming.ToleranceMs) {
+			continue
+		}
+		best.first = math.Min(best.first, float64(pair[0]))
+		best.last = math.Max(best.last, float64(pair[0]))
+	}
+	return best, nil
+}
+
+type timelineSegment struct {
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	SongID     uint32  `json:"song_id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	SongOffset float64 `json:"song_offset"`
+	Confidence float64 `json:"confidence"`
+	Score      float64 `json:"score"`
+}
+
+// WriteTimeline writes segments as {"segments": [...]}, times in seconds.
+func WriteTimeline(w io.Writer, segments []Segment) error {
+	timeline := struct {
+		Segments []timelineSegment `json:"segments"`
+	}{Segments: make([]timelineSegment, 0, len(segments))}
+
+	for _, s := range segments {
+		timeline.Segments = append(timeline.Segments, timelineSegment{
+			Start:      math.Round(s.Start*1000) / 1000,
+			End:        math.Round(s.End*1000) / 1000,
+			SongID:     s.SongID,
+			Title:      s.Title,
+			Artist:     s.Artist,
+			SongOffset: math.Round(s.SongOffset*1000) / 1000,
+			Confidence: math.Round(s.Confidence*100) / 100,
+			Score:      s.Score,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(timeline)
+}
+
+// WriteCueSheet writes segments as a CUE sheet for audioFile, one track per segment.
+func WriteCueSheet(w io.Writer, segments []Segment, audioFile string) error {
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+	}
+
+	var sheet strings.Builder
+	fmt.Fprintf(&sheet, "FILE %s WAVE\n", quote(audioFile))
+	for i, s := range segments {
+		// CUE times are minutes, seconds and frames of 1/75 s
+		frames := int(math.Round(s.Start * 75))
+		fmt.Fprintf(&sheet, "  TRACK %02d AUDIO\n", i+1)
+		fmt.Fprintf(&sheet, "    TITLE %s\n", quote(s.Title))
+		fmt.Fprintf(&sheet, "    PERFORMER %s\n", quote(s.Artist))
+		fmt.Fprintf(&sheet, "    REM CONFIDENCE %.2f\n", s.Confidence)
+		fmt.Fprintf(&sheet, "    INDEX 01 %02d:%02d:%02d\n", frames/75/60, frames/75%60, frames%75)
+	}
+
+	_, err := io.WriteString(w, sheet.String())
+	return err
+}
@@ -3,8 +3,10 @@ package core
 import (
 	"fmt"
 	"shazoom/db"
+	"shazoom/models"
 	"shazoom/utils"
 	"sort"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,32 +15,70 @@ type Match struct {
 	SongTitle  string
 	SongArtist string
 	YoutubeID  string
-	Timestamp  uint32
-	Score      float64
+	// Timestamp is where in the song the sample starts, in ms, give or take TimestampTolerance.
+	Timestamp          uint32
+	TimestampTolerance uint32
+	Score              float64
+}
+
+// Matcher runs queries against any DBClient. The package level FindMatches functions
+// open a client with db.NewDBClient for the duration of a single query. Config has to
+// match the one the catalogue was fingerprinted with, and queries fail with
+// ErrLegacyIndex on an index built before addresses held bins.
+type Matcher struct {
+	DB     db.DBClient
+	Config Config
+	// indexChecked is set once CheckIndex has passed, so it runs once per Matcher.
+	indexChecked atomic.Bool
+}
+
+func NewMatcher(dbClient db.DBClient) *Matcher {
+	return &Matcher{DB: dbClient, Config: DefaultConfig()}
 }
 
 func FindMatches(audioSample []float64, audioDuration float64, sampleRate int) ([]Match, time.Duration, error) {
 	startTime := time.Now()
 
-	spectrogram, err := Spectrogram(audioSample, sampleRate)
+	dbClient, err := db.NewDBClient()
 	if err != nil {
-		return nil, time.Since(startTime), fmt.Errorf("failed to generate spectrogram for samples: %v", err)
+		return nil, time.Since(startTime), err
 	}
+	defer dbClient.Close()
 
-	peaks := ExtractPeaks(spectrogram, audioDuration, sampleRate)
+	matches, _, err := NewMatcher(dbClient).FindMatches(audioSample, audioDuration, sampleRate)
+	return matches, time.Since(startTime), err
+}
 
-	sampleFingerprint := Fingerprint(peaks, utils.GenerateUniqueID())
+func FindMatchesUsingFingerPrints(sample map[int64]uint32) ([]Match, time.Duration, error) {
+	startTime := time.Now()
 
-	sampleFingerprintMap := make(map[int64]uint32)
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
+	defer dbClient.Close()
 
-	for address32, couple := range sampleFingerprint {
-		address64 := int64(address32)
-		sampleFingerprintMap[address64] = couple.AnchorTime
+	matches, _, err := NewMatcher(dbClient).FindMatchesUsingFingerPrints(sample)
+	return matches, time.Since(startTime), err
+}
+
+func (m *Matcher) FindMatches(audioSample []float64, audioDuration float64, sampleRate int) ([]Match, time.Duration, error) {
+	return m.FindMatchesInChannels([][]float64{audioSample}, sampleRate)
+}
+
+// FindMatchesInChannels queries with a recording that kept its channels. They are
+// fingerprinted with the same ChannelStrategy as the songs were.
+func (m *Matcher) FindMatchesInChannels(channels [][]float64, sampleRate int) ([]Match, time.Duration, error) {
+	startTime := time.Now()
+
+	sampleFingerprintMap, err := m.Config.SampleFingerprints(channels, sampleRate)
+	if err != nil {
+		return nil, time.Since(startTime), fmt.Errorf("failed to fingerprint the sample: %w", err)
 	}
 
-	fmt.Printf("Generated %d fingerprints from the recorded sample.\n", len(sampleFingerprint))
+	utils.GetLogger().Debug(fmt.Sprintf("generated %d fingerprints from the recorded sample", len(sampleFingerprintMap)))
 
-	matches, _, err := FindMatchesUsingFingerPrints(sampleFingerprintMap)
+	matches, _, err := m.FindMatchesUsingFingerPrints(sampleFingerprintMap)
 	if err != nil {
 		return nil, time.Since(startTime), err
 	}
@@ -46,54 +86,32 @@ func FindMatches(audioSample []float64, audioDuration float64, sampleRate int) (
 	return matches, time.Since(startTime), nil
 }
 
-func FindMatchesUsingFingerPrints(sample map[int64]uint32) ([]Match, time.Duration, error) {
+func (m *Matcher) FindMatchesUsingFingerPrints(sample map[int64]uint32) ([]Match, time.Duration, error) {
 	startTime := time.Now()
-	logger := utils.GetLogger()
 
 	addresses := make([]int64, 0, len(sample))
 	for address := range sample {
 		addresses = append(addresses, address)
 	}
 
-	dbClient, err := db.NewDBClient()
+	couples, err := m.getCouples(addresses)
 	if err != nil {
 		return nil, time.Since(startTime), err
 	}
-	defer dbClient.Close()
 
-	m, err := dbClient.GetCouples(addresses)
-	if err != nil {
-		return nil, time.Since(startTime), err
-	}
+	matches := CollectMatches(sample, couples)
 
-	timestamps := map[uint32]uint32{}
-	targetZones := map[uint32]map[uint32]int{}
-	matches := map[uint32][][2]uint32{}
-
-	for address, couples := range m {
-		for _, couple := range couples {
-			matches[couple.SongId] = append(
-				matches[couple.SongId],
-				[2]uint32{sample[address], couple.AnchorTime},
-			)
-
-			if existingTime, ok := timestamps[couple.SongId]; !ok || couple.AnchorTime < existingTime {
-				timestamps[couple.SongId] = couple.AnchorTime
-			}
-
-			if _, ok := targetZones[couple.SongId]; !ok {
-				targetZones[couple.SongId] = make(map[uint32]int)
-			}
+	scores := analyzeRelativeTiming(matches)
 
-			targetZones[couple.SongId][couple.AnchorTime]++
-		}
-	}
+	return rankCandidates(m.DB, scores), time.Since(startTime), nil
+}
 
-	scores := analyzeRelativeTiming(matches)
+// rankCandidates attaches the song metadata to every scored song and sorts them best first.
+func rankCandidates(dbClient db.DBClient, scores map[uint32]TimingScore) []Match {
+	logger := utils.GetLogger()
 
 	var selectedCandidates []Match
-
-	for songId, points := range scores {
+	for songId, timing := range scores {
 		song, songExists, err := dbClient.GetSongByID(songId)
 		if !songExists {
 			logger.Info(fmt.Sprintf("song provided (%v) doesn't exist in our DB :(", songId))
@@ -104,7 +122,21 @@ func FindMatchesUsingFingerPrints(sample map[int64]uint32) ([]Match, time.Durati
 			logger.Info(fmt.Sprintf("failed to fetch the song by ID (%v): %v", songId, err))
 		}
 
-		match := Match{songId, song.Title, song.Artist, song.YouTubeID, timestamps[songId], points}
+		// a sample recorded with some lead-in before the song starts yields a negative offset
+		position := timing.OffsetMs
+		if position < 0 {
+			position = 0
+		}
+
+		match := Match{
+			SongId:             songId,
+			SongTitle:          song.Title,
+			SongArtist:         song.Artist,
+			YoutubeID:          song.YouTubeID,
+			Timestamp:          uint32(position),
+			TimestampTolerance: uint32(timing.ToleranceMs),
+			Score:              timing.Score,
+		}
 		selectedCandidates = append(selectedCandidates, match)
 	}
 
@@ -112,58 +144,27 @@ func FindMatchesUsingFingerPrints(sample map[int64]uint32) ([]Match, time.Durati
 		return selectedCandidates[i].Score > selectedCandidates[j].Score
 	})
 
-	return selectedCandidates, time.Since(startTime), nil
+	return selectedCandidates
 }
 
-/*
-	for each song in the database, we increase the count of the score
-	if the delta between the sampleTime and the songTime is consistent for all/most of the anchor peaks.
-	And then the song with the most consistent time delta will gain the highest score.
-*/
-func analyzeRelativeTiming(matches map[uint32][][2]uint32) map[uint32]float64 {
-    scores := make(map[uint32]float64)
-
-    const tolerance int32 = 3
-
-    for songId, times := range matches {
-        n := len(times)
-        if n == 0 {
-            continue
-        }
-        if n == 1 {
-            scores[songId] = 1
-            continue
-        }
-
-        // collect deltas: dbTime - sampleTime
-        deltas := make([]int32, n)
-        for i, timePair := range times {
-            sampleTime := int32(timePair[0])
-            dbTime := int32(timePair[1])
-            deltas[i] = dbTime - sampleTime
-        }
-
-        sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
-
-        // find longest streak where neighbouring deltas differ <= tolerance
-        maxStreak := 1
-        streak := 1
-        for i := 1; i < n; i++ {
-            if deltas[i]-deltas[i-1] <= tolerance {
-                streak++
-            } else {
-                if streak > maxStreak {
-                    maxStreak = streak
-                }
-                streak = 1
-            }
-        }
-        if streak > maxStreak {
-            maxStreak = streak
-        }
-
-        scores[songId] = float64(maxStreak)
-    }
-
-    return scores
+// CollectMatches groups every (sampleTime, dbTime) pair found for the sample's
+// addresses by the song the database couple belongs to.
+func CollectMatches(sample map[int64]uint32, couples map[int64][]models.Couple) map[uint32][][2]uint32 {
+	matches := map[uint32][][2]uint32{}
+
+	for address, songCouples := range couples {
+		sampleTime, ok := sample[address]
+		if !ok {
+			continue
+		}
+
+		for _, couple := range songCouples {
+			matches[couple.SongId] = append(
+				matches[couple.SongId],
+				[2]uint32{sampleTime, couple.AnchorTime},
+			)
+		}
+	}
+
+	return matches
 }
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"shazoom/db"
+	"shazoom/models"
+	"time"
+)
+
+// ShiftKind says which dimensions of the sample a ShiftSearch rescales.
+type ShiftKind int
+
+const (
+	// SpeedShift undoes a recording played back faster or slower, which moves
+	// tempo and pitch together (radio speed-up, vinyl at the wrong speed).
+	SpeedShift ShiftKind = iota
+	// TempoShift undoes a time stretch that kept the pitch (DJ key lock).
+	TempoShift
+	// PitchShift undoes a transposition that kept the tempo.
+	PitchShift
+)
+
+// ShiftSearch configures the tempo/pitch robust query mode. Instead of changing the
+// fingerprints in the database, the peaks of the sample are rescaled by every factor
+// in [1-MaxShift, 1+MaxShift] in steps of Step, each rescaled copy is hashed the usual
+// way and the best alignment over all copies wins.
+type ShiftSearch struct {
+	MaxShift float64
+	Step     float64
+	Kinds    []ShiftKind
+}
+
+// DefaultShiftSearch covers ±10% speed, tempo and pitch changes in 1% steps.
+func DefaultShiftSearch() ShiftSearch {
+	return ShiftSearch{
+		MaxShift: 0.10,
+		Step:     0.01,
+		Kinds:    []ShiftKind{SpeedShift, TempoShift, PitchShift},
+	}
+}
+
+// shiftVariant maps the sample back onto the original: peak times are multiplied by
+// tempo and peak frequencies divided by pitch.
+type shiftVariant struct {
+	tempo float64
+	pitch float64
+}
+
+func (s ShiftSearch) variants() []shiftVariant {
+	variants := []shiftVariant{{1, 1}}
+	if s.MaxShift <= 0 || s.Step <= 0 {
+		return variants
+	}
+
+	steps := int(math.Round(s.MaxShift / s.Step))
+	for i := -steps; i <= steps; i++ {
+		if i == 0 {
+			continue
+		}
+		factor := 1 + float64(i)*s.Step
+
+		for _, kind := range s.Kinds {
+			switch kind {
+			case SpeedShift:
+				variants = append(variants, shiftVariant{factor, factor})
+			case TempoShift:
+				variants = append(variants, shiftVariant{factor, 1})
+			case PitchShift:
+				variants = append(variants, shiftVariant{1, factor})
+			}
+		}
+	}
+
+	return variants
+}
+
+// rescalePeaks applies a variant and snaps the result back onto the frame and bin grid,
+// so the hashed deltas and frequencies line up with the ones stored at ingest.
+func rescalePeaks(peaks []Peak, variant shiftVariant, frameDuration float64, binFreqs []float64) []Peak {
+	rescaled := make([]Peak, len(peaks))
+	for i, peak := range peaks {
+		frame := math.Round(peak.Time * variant.tempo / frameDuration)
+		bin := NearestBin(binFreqs, peak.Freq/variant.pitch)
+		rescaled[i] = Peak{Time: frame * frameDuration, Freq: binFreqs[bin], Bin: bin}
+	}
+	return rescaled
+}
+
+// ShiftedSampleFingerprints fingerprints the sample once per variant of the search.
+// The first entry is always the unshifted sample.
+func ShiftedSampleFingerprints(audioSample []float64, audioDuration float64, sampleRate int, search ShiftSearch) ([]map[int64]uint32, error) {
+	return DefaultConfig().ShiftedSampleFingerprints(audioSample, audioDuration, sampleRate, search)
+}
+
+func (c Config) ShiftedSampleFingerprints(audioSample []float64, audioDuration float64, sampleRate int, search ShiftSearch) ([]map[int64]uint32, error) {
+	// the spectrogram has no frames until the downsampled sample fills a window
+	downsampleRatio := float64(sampleRate) / EffectiveSampleRate(sampleRate)
+	if float64(len(audioSample)) <= float64(c.Spectrogram.fftSize()-1)*downsampleRatio {
+		return nil, fmt.Errorf("sample is too short to fingerprint")
+	}
+
+	signals, err := c.channelSignals([][]float64{audioSample})
+	if err != nil {
+		return nil, err
+	}
+
+	peaks, err := c.channelPeaks(signals, sampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate spectrogram for samples: %v", err)
+	}
+
+	frameDuration := c.Spectrogram.FrameDuration(sampleRate)
+	binFreqs := c.Spectrogram.BinFrequencies(sampleRate)
+
+	variants := search.variants()
+	fingerprints := make([]map[int64]uint32, 0, len(variants))
+	for _, variant := range variants {
+		sample := make(map[int64]uint32)
+		for i, signal := range signals {
+			rescaled := rescalePeaks(peaks[i], variant, frameDuration, binFreqs)
+			for _, tag := range signal.tags {
+				for address, couple := range c.fingerprintTagged(rescaled, 0, tag) {
+					sample[address] = couple.AnchorTime
+				}
+			}
+		}
+		fingerprints = append(fingerprints, sample)
+	}
+
+	return fingerprints, nil
+}
+
+// ScoreShiftedMatches scores every variant against the couples found in the database
+// and keeps, per song, the variant that aligned best.
+func ScoreShiftedMatches(variants []map[int64]uint32, couples map[int64][]models.Couple) map[uint32]TimingScore {
+	best := make(map[uint32]TimingScore)
+	for _, sample := range variants {
+		for songId, timing := range analyzeRelativeTiming(CollectMatches(sample, couples)) {
+			if existing, ok := best[songId]; !ok || timing.Score > existing.Score {
+				best[songId] = timing
+			}
+		}
+	}
+	return best
+}
+
+// FindMatchesWithShiftSearch is FindMatches for samples that may be sped up, slowed
+// down or transposed by a few percent. It costs one database round trip like the
+// normal path, but scores the sample once per variant of the search.
+func FindMatchesWithShiftSearch(audioSample []float64, audioDuration float64, sampleRate int, search ShiftSearch) ([]Match, time.Duration, error) {
+	startTime := time.Now()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
+	defer dbClient.Close()
+
+	matches, _, err := NewMatcher(dbClient).FindMatchesWithShiftSearch(audioSample, audioDuration, sampleRate, search)
+	return matches, time.Since(startTime), err
+}
+
+func (m *Matcher) FindMatchesWithShiftSearch(audioSample []float64, audioDuration float64, sampleRate int, search ShiftSearch) ([]Match, time.Duration, error) {
+	startTime := time.Now()
+
+	variants, err := m.Config.ShiftedSampleFingerprints(audioSample, audioDuration, sampleRate, search)
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
+
+	seen := make(map[int64]bool)
+	var addresses []int64
+	for _, sample := range variants {
+		for address := range sample {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+
+	couples, err := m.getCouples(addresses)
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
+
+	scores := ScoreShiftedMatches(variants, couples)
+
+	return rankCandidates(m.DB, scores), time.Since(startTime), nil
+}
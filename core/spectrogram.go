@@ -4,7 +4,6 @@ import (
     "errors"
     "fmt"
     "math"
-    "math/cmplx"
 )
 
 const (
@@ -15,7 +14,40 @@ const (
     windowType = "hanning"      
 )
 
+/*
+EffectiveSampleRate is the rate of the signal the FFT actually sees. Downsample
+averages over an integer number of input samples, so the output rate is the input
+rate divided by that integer, which is not sampleRate/dspRatio rounded down when
+sampleRate isn't a multiple of dspRatio. Every bin-to-Hz and frame-to-time
+conversion goes through this.
+*/
+func EffectiveSampleRate(sampleRate int) float64 {
+    targetSampleRate := sampleRate / dspRatio
+    if targetSampleRate <= 0 {
+        return float64(sampleRate)
+    }
+    ratio := sampleRate / targetSampleRate
+    return float64(sampleRate) / float64(ratio)
+}
+
+// FrameDuration is the time between the starts of two spectrogram frames with the
+// default window, in seconds.
+func FrameDuration(sampleRate int) float64 {
+    return SpectrogramOptions{}.FrameDuration(sampleRate)
+}
+
+// BinResolution is the width of one FFT bin in Hz with the default window.
+func BinResolution(sampleRate int) float64 {
+    return SpectrogramOptions{}.BinResolution(sampleRate)
+}
+
+// Spectrogram returns the linear magnitude spectrogram, one row per frame.
 func Spectrogram(sample []float64, sampleRate int) ([][]float64, error) {
+    return SpectrogramWithOptions(sample, sampleRate, SpectrogramOptions{})
+}
+
+// SpectrogramWithOptions is Spectrogram followed by the transforms selected in opts.
+func SpectrogramWithOptions(sample []float64, sampleRate int, opts SpectrogramOptions) ([][]float64, error) {
     filteredSample := LowPassFilter(maxFreq, float64(sampleRate), sample)
 
     downsampledSample, err := Downsample(filteredSample, sampleRate, sampleRate/dspRatio)
@@ -23,9 +55,14 @@ func Spectrogram(sample []float64, sampleRate int) ([][]float64, error) {
         return nil, fmt.Errorf("couldn't downsample audio sample: %v", err)
     }
 
-    window := make([]float64, windowSize)
+    size := opts.fftSize()
+    if size < 2 || size&(size-1) != 0 {
+        return nil, fmt.Errorf("window size %d is not a power of two", size)
+    }
+
+    window := make([]float64, size)
     for i := range window {
-        theta := 2 * math.Pi * float64(i) / float64(windowSize-1)
+        theta := 2 * math.Pi * float64(i) / float64(size-1)
         switch windowType {
         case "hamming":
             window[i] = 0.54 - 0.46*math.Cos(theta)
@@ -34,29 +71,9 @@ func Spectrogram(sample []float64, sampleRate int) ([][]float64, error) {
         }
     }
 
-    spectrogram := make([][]float64, 0)
-
-    for start := 0; start+windowSize <= len(downsampledSample); start += hopSize {
-        end := start + windowSize
-
-        frame := make([]float64, windowSize)
-        copy(frame, downsampledSample[start:end])
+    spectrogram := spectrogramFrames(downsampledSample, window, opts.workers())
 
-        for j := range window {
-            frame[j] *= window[j]
-        }
-
-        fftResult := FFT(frame)
-
-        magnitude := make([]float64, len(fftResult)/2)
-        for j := range magnitude {
-            magnitude[j] = cmplx.Abs(fftResult[j])
-        }
-
-        spectrogram = append(spectrogram, magnitude)
-    }
-
-    return spectrogram, nil
+    return opts.transform(spectrogram, sampleRate), nil
 }
 
 func LowPassFilter(cutoffFrequency, sampleRate float64, input []float64) []float64 {
@@ -113,9 +130,19 @@ func Downsample(input []float64, originalSampleRate, targetSampleRate int) ([]fl
 type Peak struct {
     Freq float64 
     Time float64 
+    // Bin is the spectrogram column the peak was found in, which the address is built from.
+    Bin int
 }
 
+// ExtractPeaks picks the constellation of a linear spectrogram. Peaks are timed from
+// the hop between frames; audioDuration is no longer needed for that and is ignored.
 func ExtractPeaks(spectrogram [][]float64, audioDuration float64, sampleRate int) []Peak {
+    return ExtractPeaksWithOptions(spectrogram, audioDuration, sampleRate, SpectrogramOptions{})
+}
+
+// ExtractPeaksWithOptions is ExtractPeaks for a spectrogram computed with opts. The
+// bands keep their frequency ranges whatever the column layout.
+func ExtractPeaksWithOptions(spectrogram [][]float64, audioDuration float64, sampleRate int, opts SpectrogramOptions) []Peak {
     if len(spectrogram) < 1 {
         return []Peak{}
     }
@@ -125,15 +152,28 @@ func ExtractPeaks(spectrogram [][]float64, audioDuration float64, sampleRate int
         freqIdx int
     }
 
-    bands := []struct{ min, max int }{
+    binBands := []struct{ min, max int }{
         {0, 10}, {10, 20}, {20, 40}, {40, 80}, {80, 160}, {160, 512},
     }
 
     var peaks []Peak
-    frameDuration := audioDuration / float64(len(spectrogram))
-
-    effectiveSampleRate := float64(sampleRate) / float64(dspRatio)
-    freqResolution := effectiveSampleRate / float64(windowSize)
+    frameDuration := opts.FrameDuration(sampleRate)
+    // the bands are bins of the default window, whatever window opts uses
+    freqResolution := BinResolution(sampleRate)
+
+    // the bands are defined on FFT bins, find the same frequency ranges in the columns
+    binFreqs := opts.BinFrequencies(sampleRate)
+    var bands []struct{ min, max int }
+    for _, band := range binBands {
+        lo := NearestBin(binFreqs, float64(band.min)*freqResolution)
+        hi := NearestBin(binFreqs, float64(band.max)*freqResolution)
+        if band.max >= windowSize/2 {
+            hi = len(binFreqs)
+        }
+        if hi > lo {
+            bands = append(bands, struct{ min, max int }{lo, hi})
+        }
+    }
 
     for frameIdx, frame := range spectrogram {
         var maxMags []float64
@@ -167,9 +207,9 @@ func ExtractPeaks(spectrogram [][]float64, audioDuration float64, sampleRate int
         for i, value := range maxMags {
             if value > avg {
                 peakTime := float64(frameIdx) * frameDuration
-                peakFreq := float64(freqIndices[i]) * freqResolution
+                peakFreq := binFreqs[freqIndices[i]]
 
-                peaks = append(peaks, Peak{Time: peakTime, Freq: peakFreq})
+                peaks = append(peaks, Peak{Time: peakTime, Freq: peakFreq, Bin: freqIndices[i]})
             }
         }
     }
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"math"
+	"sort"
+)
+
+// MagnitudeScale is the compression applied to the spectrogram magnitudes.
+type MagnitudeScale string
+
+// FrequencyScale is the layout of the spectrogram columns.
+type FrequencyScale string
+
+const (
+	// LinearMagnitude keeps the raw |FFT| values.
+	LinearMagnitude MagnitudeScale = "linear"
+	// LogMagnitude is ln(1 + |FFT|), a gentle compression that stays non-negative.
+	LogMagnitude MagnitudeScale = "log"
+	// DecibelMagnitude is 20·log10(|FFT|) lifted by decibelFloor and clipped at 0,
+	// so silence is 0 and every 20 units is a factor of ten in amplitude.
+	DecibelMagnitude MagnitudeScale = "db"
+
+	// LinearFrequency keeps one column per FFT bin.
+	LinearFrequency FrequencyScale = "linear"
+	// MelFrequency sums the bins into triangular bands evenly spaced on the mel scale.
+	MelFrequency FrequencyScale = "mel"
+	// BarkFrequency does the same on the Bark scale of critical bands.
+	BarkFrequency FrequencyScale = "bark"
+)
+
+const (
+	decibelFloor        = 100.0
+	defaultPerceptBands = 96
+)
+
+// SpectrogramOptions describes how the magnitudes of a spectrogram are transformed
+// after the FFT: bins are first aggregated into perceptual bands, then every frame is
+// optionally normalised to its loudest column, and finally the values are compressed.
+// The zero value is the plain linear spectrogram.
+type SpectrogramOptions struct {
+	Magnitude MagnitudeScale
+	Frequency FrequencyScale
+	// Bands is the number of mel or Bark bands, ignored for LinearFrequency.
+	Bands int
+	// WindowSize is the FFT length in downsampled samples, a power of two. 0 is the
+	// default 1024, bins about 10.8 Hz wide at 44.1 kHz. 2048 and 4096 halve and
+	// quarter the bin width and stretch every frame in time by as much. Their linear
+	// bins don't fit an AddressV1 address, so they need AddressV2.
+	WindowSize int
+	// NormalizeFrames divides every frame by its maximum, which takes out the overall
+	// level and most of the playback volume.
+	NormalizeFrames bool
+	// Workers is the number of goroutines computing frames, and whether the channels
+	// of a stereo song are fingerprinted side by side. 0 and 1 do everything on the
+	// calling goroutine, AllProcs scales with GOMAXPROCS. The output doesn't depend on it.
+	Workers int
+}
+
+// Logarithmic reports whether magnitudes are on a log scale, where thresholds are
+// differences rather than ratios.
+func (o SpectrogramOptions) Logarithmic() bool {
+	return o.Magnitude == LogMagnitude || o.Magnitude == DecibelMagnitude
+}
+
+// ratioOnScale converts a magnitude ratio into the equivalent step on this scale.
+func (o SpectrogramOptions) ratioOnScale(ratio float64) float64 {
+	switch o.Magnitude {
+	case DecibelMagnitude:
+		return 20 * math.Log10(ratio)
+	case LogMagnitude:
+		return math.Log(ratio)
+	default:
+		return ratio
+	}
+}
+
+// exceeds reports whether value stands out from reference by at least ratio, as a
+// ratio on linear scales and as the matching difference on log scales.
+func (o SpectrogramOptions) exceeds(value, reference, ratio float64) bool {
+	if o.Logarithmic() {
+		return value-reference >= o.ratioOnScale(ratio)
+	}
+	return value >= ratio*reference
+}
+
+func (o SpectrogramOptions) bands() int {
+	if o.Bands > 0 {
+		return o.Bands
+	}
+	return defaultPerceptBands
+}
+
+// BinFrequencies returns the frequency in Hz of every column of a spectrogram computed
+// with these options: bin centres for LinearFrequency, band centres otherwise.
+func (o SpectrogramOptions) BinFrequencies(sampleRate int) []float64 {
+	freqResolution := o.BinResolution(sampleRate)
+
+	switch o.Frequency {
+	case MelFrequency, BarkFrequency:
+		_, centres := o.filterbank(sampleRate)
+		return centres
+	default:
+		freqs := make([]float64, o.fftSize()/2)
+		for i := range freqs {
+			freqs[i] = float64(i) * freqResolution
+		}
+		return freqs
+	}
+}
+
+// NearestBin returns the column whose frequency is closest to freq.
+func NearestBin(binFreqs []float64, freq float64) int {
+	i := sort.SearchFloat64s(binFreqs, freq)
+	if i == 0 {
+		return 0
+	}
+	if i == len(binFreqs) {
+		return len(binFreqs) - 1
+	}
+	if freq-binFreqs[i-1] < binFreqs[i]-freq {
+		return i - 1
+	}
+	return i
+}
+
+// transform applies the options to a linear magnitude spectrogram in place where it can.
+func (o SpectrogramOptions) transform(spectrogram [][]float64, sampleRate int) [][]float64 {
+	if o.Frequency == MelFrequency || o.Frequency == BarkFrequency {
+		filters, _ := o.filterbank(sampleRate)
+		for i, frame := range spectrogram {
+			spectrogram[i] = applyFilterbank(frame, filters)
+		}
+	}
+
+	for _, frame := range spectrogram {
+		if o.NormalizeFrames {
+			normalizeFrame(frame)
+		}
+
+		switch o.Magnitude {
+		case LogMagnitude:
+			for j, mag := range frame {
+				frame[j] = math.Log1p(mag)
+			}
+		case DecibelMagnitude:
+			for j, mag := range frame {
+				frame[j] = math.Max(0, 20*math.Log10(mag)+decibelFloor)
+			}
+		}
+	}
+
+	return spectrogram
+}
+
+func normalizeFrame(frame []float64) {
+	maxMag := 0.0
+	for _, mag := range frame {
+		maxMag = math.Max(maxMag, mag)
+	}
+	if maxMag == 0 {
+		return
+	}
+	for j := range frame {
+		frame[j] /= maxMag
+	}
+}
+
+// triangularFilter weights a contiguous run of FFT bins starting at first.
+type triangularFilter struct {
+	first   int
+	weights []float64
+}
+
+func applyFilterbank(frame []float64, filters []triangularFilter) []float64 {
+	out := make([]float64, len(filters))
+	for i, filter := range filters {
+		for k, w := range filter.weights {
+			out[i] += w * frame[filter.first+k]
+		}
+	}
+	return out
+}
+
+/*
+filterbank lays out triangular filters with centres evenly spaced on the mel or Bark
+scale between 0 Hz and the Nyquist frequency of the downsampled signal. Each filter
+rises from the centre of its lower neighbour to its own centre and falls to the centre
+of its upper neighbour, so neighbouring bands overlap by half.
+*/
+func (o SpectrogramOptions) filterbank(sampleRate int) ([]triangularFilter, []float64) {
+	toScale, fromScale := hzToMel, melToHz
+	if o.Frequency == BarkFrequency {
+		toScale, fromScale = hzToBark, barkToHz
+	}
+
+	numBins := o.fftSize() / 2
+	freqResolution := o.BinResolution(sampleRate)
+	nyquist := EffectiveSampleRate(sampleRate) / 2
+	bands := o.bands()
+
+	lo, hi := toScale(0), toScale(nyquist)
+	edges := make([]float64, bands+2)
+	for i := range edges {
+		edges[i] = fromScale(lo + (hi-lo)*float64(i)/float64(bands+1))
+	}
+
+	filters := make([]triangularFilter, bands)
+	centres := make([]float64, bands)
+	for b := 0; b < bands; b++ {
+		left, centre, right := edges[b], edges[b+1], edges[b+2]
+		centres[b] = centre
+
+		first := int(math.Ceil(left / freqResolution))
+		last := min(numBins-1, int(math.Floor(right/freqResolution)))
+		filter := triangularFilter{first: first}
+		for k := first; k <= last; k++ {
+			f := float64(k) * freqResolution
+			var w float64
+			if f <= centre {
+				w = (f - left) / (centre - left)
+			} else {
+				w = (right - f) / (right - centre)
+			}
+			filter.weights = append(filter.weights, math.Max(0, w))
+		}
+
+		// low bands can be narrower than a bin, give them the nearest one
+		if len(filter.weights) == 0 {
+			filter = triangularFilter{first: min(numBins-1, int(math.Round(centre/freqResolution))), weights: []float64{1}}
+		}
+		filters[b] = filter
+	}
+
+	return filters, centres
+}
+
+func hzToMel(f float64) float64 { return 2595 * math.Log10(1+f/700) }
+func melToHz(m float64) float64 { return 700 * (math.Pow(10, m/2595) - 1) }
+
+// Traunmüller's approximation of the Bark scale
+func hzToBark(f float64) float64 { return 26.81*f/(1960+f) - 0.53 }
+func barkToHz(z float64) float64 { return 1960 * (z + 0.53) / (26.28 - z) }
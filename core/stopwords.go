@@ -0,0 +1,126 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"shazoom/db"
+	"shazoom/models"
+)
+
+/*
+StopWordOptions drop addresses that too many songs share, like stop words in text
+search. Drones, hum and sustained notes hash to the same few addresses all over a
+catalogue; a query hitting one pulls in couples from every song that has it, which
+costs time and only adds to the chance scores. Filtering is off by default.
+*/
+type StopWordOptions struct {
+	// MaxDocumentFrequency is the share of the catalogue's songs, in (0, 1], above which
+	// an address is ignored at query time. 0 turns filtering off.
+	MaxDocumentFrequency float64
+	// MinSongs keeps small catalogues usable: an address is never a stop word while
+	// MinSongs songs or fewer have it.
+	MinSongs int
+	// AtIngest also leaves stop words out when storing a song, which keeps the index
+	// small. Unlike query time filtering it can't be undone by changing the threshold.
+	AtIngest bool
+}
+
+func DefaultStopWordOptions() StopWordOptions {
+	return StopWordOptions{MinSongs: 2}
+}
+
+func (o StopWordOptions) enabled() bool {
+	return o.MaxDocumentFrequency > 0
+}
+
+// threshold is the number of songs above which an address is a stop word.
+func (o StopWordOptions) threshold(dbClient db.DBClient) (int, error) {
+	songs, err := dbClient.TotalSongs()
+	if err != nil {
+		return 0, fmt.Errorf("error counting songs: %w", err)
+	}
+
+	threshold := int(math.Ceil(o.MaxDocumentFrequency * float64(songs)))
+	if threshold < o.MinSongs {
+		threshold = o.MinSongs
+	}
+	return threshold, nil
+}
+
+// StopWords returns the addresses among addresses that dbClient's catalogue treats as
+// stop words under o.
+func (o StopWordOptions) StopWords(dbClient db.DBClient, addresses []int64) (map[int64]bool, error) {
+	stopWords := make(map[int64]bool)
+	if !o.enabled() || len(addresses) == 0 {
+		return stopWords, nil
+	}
+
+	threshold, err := o.threshold(dbClient)
+	if err != nil {
+		return nil, err
+	}
+
+	frequencies, err := dbClient.AddressFrequencies(addresses)
+	if err != nil {
+		return nil, fmt.Errorf("error reading address frequencies: %w", err)
+	}
+
+	for address, songs := range frequencies {
+		if songs > threshold {
+			stopWords[address] = true
+		}
+	}
+	return stopWords, nil
+}
+
+// getCouples looks up the couples of addresses, leaving out the stop words. The first
+// lookup of a Matcher makes sure the index isn't a legacy one.
+func (m *Matcher) getCouples(addresses []int64) (map[int64][]models.Couple, error) {
+	if !m.indexChecked.Load() {
+		if err := CheckIndex(m.DB); err != nil {
+			return nil, err
+		}
+		m.indexChecked.Store(true)
+	}
+
+	stopWords, err := m.Config.StopWords.StopWords(m.DB, addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stopWords) > 0 {
+		kept := make([]int64, 0, len(addresses)-len(stopWords))
+		for _, address := range addresses {
+			if !stopWords[address] {
+				kept = append(kept, address)
+			}
+		}
+		addresses = kept
+	}
+
+	return m.DB.GetCouples(addresses)
+}
+
+// dropStopWords removes the fingerprints of a song about to be stored whose address
+// is already a stop word, when the Indexer is configured to.
+func (ix *Indexer) dropStopWords(fingerprints map[int64]models.Couple) (map[int64]models.Couple, error) {
+	options := ix.Config.StopWords
+	if !options.AtIngest {
+		return fingerprints, nil
+	}
+
+	addresses := make([]int64, 0, len(fingerprints))
+	for address := range fingerprints {
+		addresses = append(addresses, address)
+	}
+
+	stopWords, err := options.StopWords(ix.DB, addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	for address := range stopWords {
+		delete(fingerprints, address)
+	}
+	return fingerprints, nil
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// ErrIncompatibleFingerprints is returned, wrapped, for sample fingerprints made with
+// a Config other than the one a Matcher queries with.
+var ErrIncompatibleFingerprints = errors.New("incompatible fingerprint version")
+
+/*
+FingerprintVersion identifies the hashes a Config produces. ConfigHash covers the
+settings that change an address or anchor time, and only while they are in effect:
+LocalMaxima counts only with LocalMaximaPeaks, TargetZone only with TargetZonePairing,
+and empty strategies hash like the defaults they stand for. Workers and the stop word
+filter are left out. Two Configs with the same version fingerprint interchangeably.
+The hash covers fingerprintRevision as well, so fingerprints of an older build never
+pass for current ones.
+*/
+type FingerprintVersion struct {
+	Address    AddressVersion
+	ConfigHash uint32
+}
+
+func (v FingerprintVersion) String() string {
+	return fmt.Sprintf("v%d/%08x", v.Address, v.ConfigHash)
+}
+
+/*
+fingerprintRevision has to be bumped whenever the fingerprinting code changes what an
+address or anchor time means for the same settings.
+
+	1: addresses held frequencies in units of 10 Hz
+	2: addresses hold spectrogram bins
+*/
+const fingerprintRevision = 2
+
+func (c Config) FingerprintVersion() FingerprintVersion {
+	hash := fnv.New32a()
+	for _, setting := range c.hashedSettings() {
+		fmt.Fprintln(hash, setting)
+	}
+	return FingerprintVersion{Address: c.addressVersion(), ConfigHash: hash.Sum32()}
+}
+
+// addressVersion is c.Address with the zero value read as the AddressV1 it pairs as.
+func (c Config) addressVersion() AddressVersion {
+	if c.Address == AddressV2 {
+		return AddressV2
+	}
+	return AddressV1
+}
+
+/*
+hashedSettings lists the settings FingerprintVersion covers as name=value lines, every
+default spelled out. A new Config field changes no version until it is added here,
+which it has to be as soon as it changes the hashes.
+*/
+func (c Config) hashedSettings() []string {
+	var settings []string
+	add := func(name string, value any) {
+		settings = append(settings, fmt.Sprintf("%s=%v", name, value))
+	}
+
+	add("revision", fingerprintRevision)
+
+	spectro := c.Spectrogram
+	add("spectrogram.window", spectro.fftSize())
+	add("spectrogram.magnitude", orDefault(spectro.Magnitude, LinearMagnitude))
+	frequency := orDefault(spectro.Frequency, LinearFrequency)
+	add("spectrogram.frequency", frequency)
+	if frequency == MelFrequency || frequency == BarkFrequency {
+		add("spectrogram.bands", spectro.bands())
+	}
+	add("spectrogram.normalize", spectro.NormalizeFrames)
+
+	picker := orDefault(c.PeakPicker, BandPeaks)
+	add("peaks", picker)
+	if picker == LocalMaximaPeaks {
+		add("peaks.time-radius", c.LocalMaxima.TimeRadius)
+		add("peaks.freq-radius", c.LocalMaxima.FreqRadius)
+		add("peaks.threshold", c.LocalMaxima.ThresholdFactor)
+		add("peaks.per-second", c.LocalMaxima.PeaksPerSecond)
+	}
+
+	pairing := orDefault(c.Pairing, NextPeaksPairing)
+	add("pairing", pairing)
+	if pairing == TargetZonePairing {
+		add("pairing.min-delta", c.TargetZone.MinDeltaMs)
+		add("pairing.max-delta", c.TargetZone.MaxDeltaMs)
+		add("pairing.freq-window", c.TargetZone.FreqWindow)
+		add("pairing.fan-out", c.TargetZone.FanOut)
+		add("pairing.triplets", c.TargetZone.Triplets)
+	}
+
+	add("address", c.addressVersion())
+	add("channels", orDefault(c.Channels, SeparateChannels))
+
+	pre := c.Preprocess
+	if pre.enabled() {
+		add("preprocess.remove-dc", pre.RemoveDC)
+		add("preprocess.normalize", pre.Normalize)
+		if pre.Normalize != NoNormalization {
+			add("preprocess.target", pre.TargetDb)
+		}
+		add("preprocess.trim", pre.TrimSilence)
+		if pre.TrimSilence || pre.MinSound > 0 {
+			add("preprocess.silence", pre.silenceDb())
+			add("preprocess.min-sound", pre.MinSound)
+		}
+		if pre.TrimSilence {
+			add("preprocess.min-gap", pre.MinGap)
+		}
+	}
+
+	return settings
+}
+
+func orDefault[T ~string](value, fallback T) T {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+/*
+SampleFingerprints fingerprints a recording the way a query does and returns the
+sample map FindMatchesUsingFingerPrints takes: address to anchor time in ms. Clients
+run it locally and send the result with EncodeSampleFingerprints instead of the audio.
+*/
+func (c Config) SampleFingerprints(channels [][]float64, sampleRate int) (map[int64]uint32, error) {
+	// the song ID of a query's couples is never looked at, only the anchor times
+	fingerprints, err := c.fingerprintChannels(channels, sampleRate, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sample := make(map[int64]uint32, len(fingerprints))
+	for address, couple := range fingerprints {
+		sample[address] = couple.AnchorTime
+	}
+	return sample, nil
+}
+
+/*
+The sample wire format is built for phones on a slow link:
+
+	"SHZQ" format:byte address version:byte config hash:uint32 count:uvarint pairs
+
+little endian, with count pairs of uvarints sorted by address:
+
+	address delta | anchor time ms
+
+A five second sample comes to about 2 KB, against close to 600 KB of 16-bit mono WAV
+sent as base64.
+*/
+const (
+	sampleWireMagic   = "SHZQ"
+	sampleWireVersion = 1
+	// maxWireSample bounds the hashes one request may carry, far above what a
+	// minute of audio produces.
+	maxWireSample = 200000
+)
+
+// EncodeSampleFingerprints packs a sample for the wire.
+func EncodeSampleFingerprints(sample map[int64]uint32, version FingerprintVersion) []byte {
+	addresses := make([]int64, 0, len(sample))
+	for address := range sample {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	buf := []byte(sampleWireMagic)
+	buf = append(buf, sampleWireVersion, byte(version.Address))
+	buf = binary.LittleEndian.AppendUint32(buf, version.ConfigHash)
+	buf = binary.AppendUvarint(buf, uint64(len(addresses)))
+
+	var previous int64
+	for _, address := range addresses {
+		buf = binary.AppendUvarint(buf, uint64(address-previous))
+		buf = binary.AppendUvarint(buf, uint64(sample[address]))
+		previous = address
+	}
+	return buf
+}
+
+// DecodeSampleFingerprints unpacks what EncodeSampleFingerprints wrote.
+func DecodeSampleFingerprints(data []byte) (map[int64]uint32, FingerprintVersion, error) {
+	const prefix = len(sampleWireMagic) + 2 + 4
+
+	if len(data) < prefix || string(data[:len(sampleWireMagic)]) != sampleWireMagic {
+		return nil, FingerprintVersion{}, errors.New("not a sample fingerprint message")
+	}
+	if format := data[len(sampleWireMagic)]; format != sampleWireVersion {
+		return nil, FingerprintVersion{}, fmt.Errorf("wire format %d, only %d is understood", format, sampleWireVersion)
+	}
+	version := FingerprintVersion{
+		Address:    AddressVersion(data[len(sampleWireMagic)+1]),
+		ConfigHash: binary.LittleEndian.Uint32(data[len(sampleWireMagic)+2:]),
+	}
+
+	rest := data[prefix:]
+	next := func() (uint64, error) {
+		value, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return 0, errors.New("truncated sample fingerprint message")
+		}
+		rest = rest[n:]
+		return value, nil
+	}
+
+	count, err := next()
+	if err != nil {
+		return nil, version, err
+	}
+	if count > maxWireSample {
+		return nil, version, fmt.Errorf("%d hashes in one sample, at most %d are accepted", count, maxWireSample)
+	}
+	// every hash takes at least two bytes
+	if count > uint64(len(rest)/2) {
+		return nil, version, errors.New("truncated sample fingerprint message")
+	}
+
+	sample := make(map[int64]uint32, count)
+	var address int64
+	for i := uint64(0); i < count; i++ {
+		delta, err := next()
+		if err != nil {
+			return nil, version, err
+		}
+		anchorTime, err := next()
+		if err != nil {
+			return nil, version, err
+		}
+		if i > 0 && delta == 0 {
+			return nil, version, fmt.Errorf("address %#x repeated", address)
+		}
+		address += int64(delta)
+		sample[address] = uint32(anchorTime)
+	}
+	if len(rest) != 0 {
+		return nil, version, fmt.Errorf("%d stray bytes after the hashes", len(rest))
+	}
+
+	return sample, version, nil
+}
+
+// FindMatchesForClient checks that a client's sample was fingerprinted like the
+// catalogue before matching it.
+func (m *Matcher) FindMatchesForClient(sample map[int64]uint32, version FingerprintVersion) ([]Match, time.Duration, error) {
+	if want := m.Config.FingerprintVersion(); version != want {
+		return nil, 0, fmt.Errorf("%w: the sample is %s, this catalogue is %s", ErrIncompatibleFingerprints, version, want)
+	}
+	return m.FindMatchesUsingFingerPrints(sample)
+}
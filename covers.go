@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"shazoom/core"
+	"shazoom/db"
+	"text/tabwriter"
+)
+
+func init() {
+	commands["covers"] = command{summary: "find covers and live versions by harmony, or build their index", run: runCovers}
+}
+
+func runCovers(args []string) error {
+	defaults := core.DefaultCoverOptions()
+	flags := flag.NewFlagSet("covers", flag.ExitOnError)
+	index := flags.Bool("index", false, "add every song to the cover index from its source file")
+	minScore := flags.Float64("min-score", defaults.MinScore, "similarity a result needs, 1 is the same harmony throughout")
+	limit := flags.Int("limit", defaults.MaxResults, "most results to show")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: shazoom covers [flags] <audio file>")
+		fmt.Fprintln(os.Stderr, "       shazoom covers -index")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *index == (flags.NArg() == 1) || flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("-limit must be positive, got %d", *limit)
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	covers := core.NewCoverIndex(dbClient)
+	covers.Options.MinScore, covers.Options.MaxResults = *minScore, *limit
+
+	if *index {
+		return indexCovers(dbClient, covers)
+	}
+
+	results, err := covers.FindSimilarToFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Println("no similar recordings found")
+		return nil
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tTITLE\tARTIST\tSCORE\tKEY\tAT")
+	for _, r := range results {
+		fmt.Fprintf(table, "%d\t%s\t%s\t%.2f\t%+d\t%s-%s\n", r.SongID, r.Title, r.Artist, r.Score, r.Transposition,
+			formatDuration(r.Start), formatDuration(r.End))
+	}
+	return table.Flush()
+}
+
+// indexCovers backfills the cover index from the files songs were ingested from.
+func indexCovers(dbClient db.DBClient, covers *core.CoverIndex) error {
+	var added, skipped int
+	cursor := ""
+	for {
+		page, err := dbClient.ListSongs(cursor, 100, db.SortByID)
+		if err != nil {
+			return err
+		}
+
+		for _, song := range page.Songs {
+			if song.SourcePath == "" {
+				skipped++
+				continue
+			}
+			if err := covers.AddFile(song.ID, song.SourcePath); err != nil {
+				fmt.Fprintf(os.Stderr, "skipped song %d %q: %v\n", song.ID, song.Title, err)
+				skipped++
+				continue
+			}
+			added++
+		}
+
+		cursor = page.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "added %d songs to the cover index, skipped %d without a readable source file\n", added, skipped)
+	return nil
+}
@@ -1,9 +1,12 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"shazoom/models"
 	"shazoom/utils"
+	"time"
 	"github.com/joho/godotenv"
 )
 
@@ -11,27 +14,90 @@ type DBClient interface {
 	Close() error
 	StoreFingerprints(fingerprints map[int64]models.Couple) error
 	GetCouples(addresses []int64) (map[int64][]models.Couple, error)
+	// AddressFrequencies returns the document frequency of each address: how many
+	// songs have at least one fingerprint there. Unknown addresses are left out.
+	AddressFrequencies(addresses []int64) (map[int64]int, error)
+	// HasAddressesBetween reports whether any fingerprint has an address in [low, high).
+	HasAddressesBetween(low, high int64) (bool, error)
+	// WalkFingerprints calls fn for every stored fingerprint, ordered by song ID, then
+	// address, then anchor time, and stops at the first error fn returns. Fingerprints
+	// are streamed, the index never has to fit in memory.
+	WalkFingerprints(fn func(address int64, couple models.Couple) error) error
+	// RewriteAddresses replaces every stored address with rewrite(address), all or
+	// nothing, and returns how many fingerprints changed. Used to migrate hash layouts.
+	RewriteAddresses(rewrite func(address int64) (int64, error)) (int, error)
 
 	TotalSongs() (int, error)
+	// Stats reports the size and health of the index, with the topAddresses most
+	// shared addresses. topAddresses must not be negative.
+	Stats(topAddresses int) (StoreStats, error)
 	RegisterSong(songTitle, songArtist, ytID string) (uint32, error)
+	// RegisterOrGetSong registers song unless one with the same key, YouTube ID or
+	// content hash is already there, in which case it returns that song's ID and
+	// ErrSongExists.
+	RegisterOrGetSong(song Song) (uint32, error)
+	// MarkSongFingerprinted records that every fingerprint of the song is stored.
+	MarkSongFingerprinted(songID uint32) error
 	GetSong(filterKey string, value interface{}) (Song, bool, error)
 	GetSongByID(songID uint32) (Song, bool, error)
 	GetSongByYTID(ytID string) (Song, bool, error)
 	GetSongByKey(key string) (Song, bool, error)
+	// SearchSongs returns songs whose title or artist contains query, ignoring case,
+	// ordered by title, artist and ID. limit must be positive and offset non-negative.
+	SearchSongs(query string, limit, offset int) ([]Song, error)
+	// ListSongs returns up to limit songs after cursor in sort order, with their
+	// fingerprint counts. Pass "" to start and the page's NextCursor to continue.
+	ListSongs(cursor string, limit int, sort SongSort) (SongPage, error)
+	// UpdateSongMetadata overwrites the album, ISRC, release year, genre and YouTube ID
+	// of song.ID. Title and artist, which the song's key derives from, stay.
+	UpdateSongMetadata(song Song) error
 	DeleteSongByID(songID uint32) error
+	// DeleteSongFingerprints deletes every fingerprint of a song, which DeleteSongByID
+	// leaves behind, and returns how many there were.
+	DeleteSongFingerprints(songID uint32) (int, error)
+
+	// StoreChroma saves a song's encoded chroma features for cover matching, replacing
+	// any it had. The cover index is kept apart from the fingerprints.
+	StoreChroma(songID uint32, features []byte) error
+	// WalkChroma calls fn with the chroma features of every song that has them, in
+	// song ID order, and stops at the first error fn returns.
+	WalkChroma(fn func(songID uint32, features []byte) error) error
+	// GetChroma returns the chroma features of songIDs. Songs without any are left out.
+	GetChroma(songIDs []uint32) (map[uint32][]byte, error)
+	DeleteChroma(songID uint32) error
 	DeleteCollection(collectionName string) error
 }
 
+// ErrSongExists is returned, wrapped, when a registration hits a song that is already there.
+var ErrSongExists = errors.New("song already exists")
+
 type Song struct {
+	// ID is filled in by the store; it is ignored when registering.
+	ID        uint32
 	Title     string
 	Artist    string
 	YouTubeID string
+	Album     string
+	// Duration of the decoded audio in seconds.
+	Duration    float64
+	ISRC        string
+	ReleaseYear int
+	Genre       string
+	// SourcePath is the file the song was ingested from.
+	SourcePath string
+	// CreatedAt is set by the store when the song is registered.
+	CreatedAt time.Time
+	// ContentHash is the hex SHA-256 of the decoded audio, empty when unknown.
+	ContentHash string
+	// Fingerprinted is set once ingestion stored all of the song's fingerprints.
+	Fingerprinted bool
 }
 
 func setupTestEnv() {
 	err := godotenv.Load("../.env")
 	if err != nil {
-		fmt.Printf("Warning: Could not load .env file: %v. Relying on shell exports.", err)
+		// on stderr, since stdout may be carrying an export
+		fmt.Fprintf(os.Stderr, "Warning: Could not load .env file: %v. Relying on shell exports.\n", err)
 	}
 
 	DB_HOST := utils.GetEnv("DB_HOST")
@@ -48,7 +114,7 @@ func setupTestEnv() {
 	}
 	for key, val := range vars {
 		if val == "" {
-			fmt.Printf("FATAL: Required env %s is not set or is empty.", key)
+			fmt.Fprintf(os.Stderr, "FATAL: Required env %s is not set or is empty.\n", key)
 		}
 	}
 }
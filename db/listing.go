@@ -0,0 +1,127 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCursor is returned, wrapped, for a cursor ListSongs didn't hand out.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SongSort is the order ListSongs walks the catalogue in. Ties are broken by ID.
+type SongSort string
+
+const (
+	SortByID      SongSort = "id"
+	SortByTitle   SongSort = "title"
+	SortByArtist  SongSort = "artist"
+	SortByCreated SongSort = "created"
+)
+
+// ParseSongSort checks that name is one of the SongSort values.
+func ParseSongSort(name string) (SongSort, error) {
+	if sort := SongSort(name); sort.valid() {
+		return sort, nil
+	}
+	return "", fmt.Errorf("unknown sort %q, use id, title, artist or created", name)
+}
+
+func (s SongSort) valid() bool {
+	switch s {
+	case SortByID, SortByTitle, SortByArtist, SortByCreated:
+		return true
+	}
+	return false
+}
+
+// cursorTimeLayout has a fixed width, so creation times sort as strings too.
+const cursorTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// key is the value of song a listing in this order is sorted by, before the ID.
+func (s SongSort) key(song Song) string {
+	switch s {
+	case SortByTitle:
+		return song.Title
+	case SortByArtist:
+		return song.Artist
+	case SortByCreated:
+		return song.CreatedAt.UTC().Format(cursorTimeLayout)
+	}
+	return ""
+}
+
+// SongListing is a song together with the number of fingerprints stored for it.
+type SongListing struct {
+	Song
+	Fingerprints int
+}
+
+// SongPage is one page of ListSongs. NextCursor is empty on the last page.
+type SongPage struct {
+	Songs      []SongListing
+	NextCursor string
+}
+
+/*
+songCursor is the position after the last song of a page: its sort key and ID. A
+page starts strictly after it, so songs added or removed between two calls never
+shift the pages that follow, unlike an offset. Cursors are opaque to callers.
+*/
+type songCursor struct {
+	Sort SongSort `json:"s"`
+	Key  string   `json:"k"`
+	ID   uint32   `json:"id"`
+}
+
+func cursorAfter(song Song, sort SongSort) string {
+	return songCursor{Sort: sort, Key: sort.key(song), ID: song.ID}.encode()
+}
+
+func (c songCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeSongCursor reads a cursor of a listing in sort order. The empty cursor is the
+// start of the catalogue.
+func decodeSongCursor(cursor string, sort SongSort) (songCursor, error) {
+	if cursor == "" {
+		return songCursor{Sort: sort}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return songCursor{}, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	var decoded songCursor
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return songCursor{}, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if decoded.Sort != sort {
+		return songCursor{}, fmt.Errorf("%w: it is for a listing sorted by %q, not %q", ErrInvalidCursor, decoded.Sort, sort)
+	}
+	return decoded, nil
+}
+
+func checkSearchArgs(limit, offset int) error {
+	if limit <= 0 {
+		return fmt.Errorf("limit must be positive, got %d", limit)
+	}
+	if offset < 0 {
+		return fmt.Errorf("offset must not be negative, got %d", offset)
+	}
+	return nil
+}
+
+func checkListArgs(limit int, sort SongSort) error {
+	if limit <= 0 {
+		return fmt.Errorf("limit must be positive, got %d", limit)
+	}
+	if !sort.valid() {
+		return fmt.Errorf("unknown sort %q", sort)
+	}
+	return nil
+}
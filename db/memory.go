@@ -0,0 +1,523 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"shazoom/models"
+	"shazoom/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryClient is an in-process DBClient. Nothing is persisted, which makes it handy
+// for tests and offline evaluation where no Postgres instance is around.
+type MemoryClient struct {
+	mu           sync.RWMutex
+	songs        map[uint32]memorySong
+	fingerprints map[int64][]models.Couple
+	// songFingerprints counts the couples of every song, so listings don't rescan fingerprints.
+	songFingerprints map[uint32]int
+	chroma           map[uint32][]byte
+	// lastID is the last song ID handed out, IDs count up from 1 like a sequence.
+	lastID uint32
+}
+
+type memorySong struct {
+	Song
+	key string
+}
+
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{
+		songs:            make(map[uint32]memorySong),
+		fingerprints:     make(map[int64][]models.Couple),
+		songFingerprints: make(map[uint32]int),
+		chroma:           make(map[uint32][]byte),
+	}
+}
+
+func (c *MemoryClient) Close() error {
+	return nil
+}
+
+func (c *MemoryClient) StoreFingerprints(fingerprints map[int64]models.Couple) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for address, couple := range fingerprints {
+		// same primary key as the postgres table: (address, anchorTimeMs, songID)
+		duplicate := false
+		for _, existing := range c.fingerprints[address] {
+			if existing == couple {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			c.fingerprints[address] = append(c.fingerprints[address], couple)
+			c.songFingerprints[couple.SongId]++
+		}
+	}
+
+	return nil
+}
+
+func (c *MemoryClient) GetCouples(addresses []int64) (map[int64][]models.Couple, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	couples := make(map[int64][]models.Couple)
+	for _, address := range addresses {
+		if stored, ok := c.fingerprints[address]; ok {
+			couples[address] = append([]models.Couple(nil), stored...)
+		}
+	}
+
+	return couples, nil
+}
+
+func (c *MemoryClient) AddressFrequencies(addresses []int64) (map[int64]int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	frequencies := make(map[int64]int)
+	for _, address := range addresses {
+		stored, ok := c.fingerprints[address]
+		if !ok {
+			continue
+		}
+
+		songs := make(map[uint32]bool)
+		for _, couple := range stored {
+			songs[couple.SongId] = true
+		}
+		frequencies[address] = len(songs)
+	}
+
+	return frequencies, nil
+}
+
+func (c *MemoryClient) HasAddressesBetween(low, high int64) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for address := range c.fingerprints {
+		if address >= low && address < high {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *MemoryClient) WalkFingerprints(fn func(address int64, couple models.Couple) error) error {
+	type fingerprint struct {
+		address int64
+		couple  models.Couple
+	}
+
+	// copied out so fn can use the client
+	c.mu.RLock()
+	var all []fingerprint
+	for address, couples := range c.fingerprints {
+		for _, couple := range couples {
+			all = append(all, fingerprint{address, couple})
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.couple.SongId != b.couple.SongId {
+			return a.couple.SongId < b.couple.SongId
+		}
+		if a.address != b.address {
+			return a.address < b.address
+		}
+		return a.couple.AnchorTime < b.couple.AnchorTime
+	})
+
+	for _, f := range all {
+		if err := fn(f.address, f.couple); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MemoryClient) RewriteAddresses(rewrite func(address int64) (int64, error)) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rewritten := make(map[int64][]models.Couple, len(c.fingerprints))
+	changed := 0
+	for address, couples := range c.fingerprints {
+		newAddress, err := rewrite(address)
+		if err != nil {
+			return 0, fmt.Errorf("rewriting address %d: %w", address, err)
+		}
+		if newAddress != address {
+			changed += len(couples)
+		}
+		rewritten[newAddress] = append(rewritten[newAddress], couples...)
+	}
+
+	c.fingerprints = rewritten
+	return changed, nil
+}
+
+func (c *MemoryClient) TotalSongs() (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.songs), nil
+}
+
+func (c *MemoryClient) Stats(topAddresses int) (StoreStats, error) {
+	if err := checkStatsArgs(topAddresses); err != nil {
+		return StoreStats{}, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := StoreStats{Songs: len(c.songs)}
+	counts := make(map[uint32]int, len(c.songs))
+	for id := range c.songs {
+		counts[id] = 0
+	}
+
+	addresses := make([]AddressCount, 0, len(c.fingerprints))
+	for address, couples := range c.fingerprints {
+		stats.Fingerprints += len(couples)
+		addresses = append(addresses, AddressCount{Address: address, Count: len(couples)})
+
+		for _, couple := range couples {
+			if _, registered := c.songs[couple.SongId]; registered {
+				counts[couple.SongId]++
+			} else {
+				stats.OrphanedFingerprints++
+			}
+		}
+	}
+
+	for id, count := range counts {
+		stats.PerSong = append(stats.PerSong, SongCount{SongID: id, Fingerprints: count})
+	}
+	stats.summarisePerSong()
+
+	sort.Slice(addresses, func(i, j int) bool {
+		if addresses[i].Count != addresses[j].Count {
+			return addresses[i].Count > addresses[j].Count
+		}
+		return addresses[i].Address < addresses[j].Address
+	})
+	if len(addresses) > topAddresses {
+		addresses = addresses[:topAddresses]
+	}
+	stats.TopAddresses = addresses
+
+	return stats, nil
+}
+
+func (c *MemoryClient) RegisterSong(songTitle, songArtist, ytID string) (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	songKey := utils.GenerateSongKey(songTitle, songArtist)
+	for _, song := range c.songs {
+		if song.key == songKey {
+			return 0, fmt.Errorf("%w: %s", ErrSongExists, songKey)
+		}
+	}
+
+	return c.insertSong(Song{Title: songTitle, Artist: songArtist, YouTubeID: ytID}, songKey)
+}
+
+func (c *MemoryClient) RegisterOrGetSong(song Song) (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	songKey := utils.GenerateSongKey(song.Title, song.Artist)
+	for id, existing := range c.songs {
+		if existing.key == songKey ||
+			(song.YouTubeID != "" && existing.YouTubeID == song.YouTubeID) ||
+			(song.ContentHash != "" && existing.ContentHash == song.ContentHash) {
+			return id, fmt.Errorf("%w: %s", ErrSongExists, existing.key)
+		}
+	}
+
+	song.Fingerprinted = false
+	return c.insertSong(song, songKey)
+}
+
+// insertSong stores song under the next ID. The caller holds the lock.
+func (c *MemoryClient) insertSong(song Song, songKey string) (uint32, error) {
+	if c.lastID == math.MaxUint32 {
+		return 0, fmt.Errorf("song IDs exhausted")
+	}
+	c.lastID++
+	songID := c.lastID
+
+	song.ID = songID
+	song.CreatedAt = time.Now()
+	c.songs[songID] = memorySong{Song: song, key: songKey}
+
+	return songID, nil
+}
+
+func (c *MemoryClient) MarkSongFingerprinted(songID uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	song, ok := c.songs[songID]
+	if !ok {
+		return fmt.Errorf("song %d doesn't exist", songID)
+	}
+	song.Fingerprinted = true
+	c.songs[songID] = song
+	return nil
+}
+
+func (c *MemoryClient) GetSong(filterKey string, value interface{}) (Song, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	switch filterKey {
+	case "id":
+		var songID uint32
+		switch v := value.(type) {
+		case uint32:
+			songID = v
+		case int64:
+			songID = uint32(v)
+		case int:
+			songID = uint32(v)
+		default:
+			return Song{}, false, fmt.Errorf("invalid song id type %T", value)
+		}
+		song, ok := c.songs[songID]
+		return song.Song, ok, nil
+	case "ytID", "key", "contentHash":
+		for _, song := range c.songs {
+			if (filterKey == "ytID" && song.YouTubeID == value) || (filterKey == "key" && song.key == value) ||
+				(filterKey == "contentHash" && song.ContentHash == value) {
+				return song.Song, true, nil
+			}
+		}
+		return Song{}, false, nil
+	default:
+		return Song{}, false, fmt.Errorf("invalid filter key")
+	}
+}
+
+func (c *MemoryClient) SearchSongs(query string, limit, offset int) ([]Song, error) {
+	if err := checkSearchArgs(limit, offset); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	query = strings.ToLower(query)
+
+	var found []Song
+	for _, song := range c.songs {
+		if strings.Contains(strings.ToLower(song.Title), query) || strings.Contains(strings.ToLower(song.Artist), query) {
+			found = append(found, song.Song)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Title != found[j].Title {
+			return found[i].Title < found[j].Title
+		}
+		if found[i].Artist != found[j].Artist {
+			return found[i].Artist < found[j].Artist
+		}
+		return found[i].ID < found[j].ID
+	})
+
+	if offset >= len(found) {
+		return nil, nil
+	}
+	found = found[offset:]
+	if limit < len(found) {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+func (c *MemoryClient) ListSongs(cursor string, limit int, sort SongSort) (SongPage, error) {
+	if err := checkListArgs(limit, sort); err != nil {
+		return SongPage{}, err
+	}
+	after, err := decodeSongCursor(cursor, sort)
+	if err != nil {
+		return SongPage{}, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var listings []SongListing
+	for id, song := range c.songs {
+		key := sort.key(song.Song)
+		if cursor != "" && (key < after.Key || (key == after.Key && id <= after.ID)) {
+			continue
+		}
+		listings = append(listings, SongListing{Song: song.Song})
+	}
+
+	sortListings(listings, sort)
+
+	page := SongPage{Songs: listings}
+	if len(listings) > limit {
+		page.Songs = listings[:limit]
+		page.NextCursor = cursorAfter(page.Songs[limit-1].Song, sort)
+	}
+	for i := range page.Songs {
+		page.Songs[i].Fingerprints = c.songFingerprints[page.Songs[i].ID]
+	}
+	return page, nil
+}
+
+func sortListings(listings []SongListing, order SongSort) {
+	sort.Slice(listings, func(i, j int) bool {
+		ki, kj := order.key(listings[i].Song), order.key(listings[j].Song)
+		if ki != kj {
+			return ki < kj
+		}
+		return listings[i].ID < listings[j].ID
+	})
+}
+
+func (c *MemoryClient) GetSongByID(id uint32) (Song, bool, error) {
+	return c.GetSong("id", id)
+}
+
+func (c *MemoryClient) GetSongByYTID(id string) (Song, bool, error) {
+	return c.GetSong("ytID", id)
+}
+
+func (c *MemoryClient) GetSongByKey(k string) (Song, bool, error) {
+	return c.GetSong("key", k)
+}
+
+func (c *MemoryClient) UpdateSongMetadata(song Song) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.songs[song.ID]
+	if !ok {
+		return fmt.Errorf("song %d doesn't exist", song.ID)
+	}
+	stored.Album = song.Album
+	stored.ISRC = song.ISRC
+	stored.ReleaseYear = song.ReleaseYear
+	stored.Genre = song.Genre
+	stored.YouTubeID = song.YouTubeID
+	c.songs[song.ID] = stored
+	return nil
+}
+
+func (c *MemoryClient) DeleteSongByID(id uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.songs, id)
+	return nil
+}
+
+func (c *MemoryClient) DeleteSongFingerprints(songID uint32) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted := 0
+	for address, couples := range c.fingerprints {
+		kept := couples[:0]
+		for _, couple := range couples {
+			if couple.SongId == songID {
+				deleted++
+				continue
+			}
+			kept = append(kept, couple)
+		}
+		if len(kept) == 0 {
+			delete(c.fingerprints, address)
+		} else {
+			c.fingerprints[address] = kept
+		}
+	}
+	delete(c.songFingerprints, songID)
+	return deleted, nil
+}
+
+func (c *MemoryClient) StoreChroma(songID uint32, features []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chroma[songID] = append([]byte(nil), features...)
+	return nil
+}
+
+func (c *MemoryClient) WalkChroma(fn func(songID uint32, features []byte) error) error {
+	c.mu.RLock()
+	songIDs := make([]uint32, 0, len(c.chroma))
+	for songID := range c.chroma {
+		songIDs = append(songIDs, songID)
+	}
+	c.mu.RUnlock()
+	sort.Slice(songIDs, func(i, j int) bool { return songIDs[i] < songIDs[j] })
+
+	for _, songID := range songIDs {
+		c.mu.RLock()
+		features, ok := c.chroma[songID]
+		c.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		if err := fn(songID, features); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MemoryClient) GetChroma(songIDs []uint32) (map[uint32][]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	found := make(map[uint32][]byte, len(songIDs))
+	for _, songID := range songIDs {
+		if features, ok := c.chroma[songID]; ok {
+			found[songID] = features
+		}
+	}
+	return found, nil
+}
+
+func (c *MemoryClient) DeleteChroma(songID uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.chroma, songID)
+	return nil
+}
+
+func (c *MemoryClient) DeleteCollection(collectionName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch collectionName {
+	case "songs":
+		c.songs = make(map[uint32]memorySong)
+	case "fingerprints":
+		c.fingerprints = make(map[int64][]models.Couple)
+		c.songFingerprints = make(map[uint32]int)
+	default:
+		return fmt.Errorf("unauthorized table drop")
+	}
+	return nil
+}
@@ -2,11 +2,14 @@ package db
 
 import (
     "database/sql"
+    "errors"
     "fmt"
+    "os"
     "shazoom/models"
     "shazoom/utils"
     "strings"
 
+    "github.com/jackc/pgx/v5/pgconn"
     _ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -28,7 +31,7 @@ func NewPostgresClient(dsn string) (*PostgresClient, error) {
         return nil, fmt.Errorf("error creating tables: %w", err)
     }
 
-    fmt.Printf("successfully created postgreSQL client and created tables\n")
+    fmt.Fprintf(os.Stderr, "successfully created postgreSQL client and created tables\n")
     return &PostgresClient{db: db}, nil
 }
 
@@ -36,16 +39,44 @@ func (c *PostgresClient) Close() error {
     return c.db.Close()
 }
 
+// postgresTables are the tables createPostgresTables creates, which Stats sizes.
+var postgresTables = []string{"songs", "fingerprints", "chroma"}
+
 func createPostgresTables(db *sql.DB) error {
     createSongsTable := `
     CREATE TABLE IF NOT EXISTS songs (
-        id BIGINT PRIMARY KEY,
+        id BIGINT GENERATED BY DEFAULT AS IDENTITY (MAXVALUE 4294967295) PRIMARY KEY,
         title TEXT NOT NULL,
         artist TEXT NOT NULL,
         "ytID" TEXT, 
-        key TEXT NOT NULL UNIQUE
+        key TEXT NOT NULL UNIQUE,
+        "contentHash" TEXT,
+        fingerprinted BOOLEAN NOT NULL DEFAULT FALSE,
+        album TEXT NOT NULL DEFAULT '',
+        duration DOUBLE PRECISION NOT NULL DEFAULT 0,
+        isrc TEXT NOT NULL DEFAULT '',
+        "releaseYear" INTEGER NOT NULL DEFAULT 0,
+        genre TEXT NOT NULL DEFAULT '',
+        "sourcePath" TEXT NOT NULL DEFAULT '',
+        "createdAt" TIMESTAMPTZ NOT NULL DEFAULT now()
     );`
 
+    // older songs tables lack every column after key
+    migrateSongsTable := `
+    ALTER TABLE songs ADD COLUMN IF NOT EXISTS "contentHash" TEXT;
+    ALTER TABLE songs ADD COLUMN IF NOT EXISTS fingerprinted BOOLEAN NOT NULL DEFAULT FALSE;
+    ALTER TABLE songs ADD COLUMN IF NOT EXISTS album TEXT NOT NULL DEFAULT '';
+    ALTER TABLE songs ADD COLUMN IF NOT EXISTS duration DOUBLE PRECISION NOT NULL DEFAULT 0;
+    ALTER TABLE songs ADD COLUMN IF NOT EXISTS isrc TEXT NOT NULL DEFAULT '';
+    ALTER TABLE songs ADD COLUMN IF NOT EXISTS "releaseYear" INTEGER NOT NULL DEFAULT 0;
+    ALTER TABLE songs ADD COLUMN IF NOT EXISTS genre TEXT NOT NULL DEFAULT '';
+    ALTER TABLE songs ADD COLUMN IF NOT EXISTS "sourcePath" TEXT NOT NULL DEFAULT '';
+    ALTER TABLE songs ADD COLUMN IF NOT EXISTS "createdAt" TIMESTAMPTZ NOT NULL DEFAULT now();
+
+    CREATE INDEX IF NOT EXISTS idx_songs_ytid ON songs ("ytID");
+    CREATE INDEX IF NOT EXISTS idx_songs_content_hash ON songs ("contentHash");
+    `
+
     createFingerprintsTable := `
     CREATE TABLE IF NOT EXISTS fingerprints (
         address BIGINT NOT NULL,
@@ -55,18 +86,138 @@ func createPostgresTables(db *sql.DB) error {
     );
     
     CREATE INDEX IF NOT EXISTS idx_fingerprints_address ON fingerprints (address);
+    CREATE INDEX IF NOT EXISTS idx_fingerprints_song ON fingerprints ("songID");
     `
 
+    // older stores kept the document frequency of every address up to date on each
+    // insert. AddressFrequencies counts them from the fingerprints now.
+    dropFrequencyTable := `DROP TABLE IF EXISTS address_frequency`
+
+    // chroma features of the cover index, one row per song
+    createChromaTable := `
+    CREATE TABLE IF NOT EXISTS chroma (
+        "songID" BIGINT PRIMARY KEY,
+        features BYTEA NOT NULL
+    );`
+
     if _, err := db.Exec(createSongsTable); err != nil {
         return fmt.Errorf("creating songs table: %w", err)
     }
+    if _, err := db.Exec(migrateSongsTable); err != nil {
+        return fmt.Errorf("migrating songs table: %w", err)
+    }
     if _, err := db.Exec(createFingerprintsTable); err != nil {
         return fmt.Errorf("creating fingerprints table: %w", err)
     }
+    if _, err := db.Exec(dropFrequencyTable); err != nil {
+        return fmt.Errorf("dropping address frequency table: %w", err)
+    }
+    if _, err := db.Exec(createChromaTable); err != nil {
+        return fmt.Errorf("creating chroma table: %w", err)
+    }
+    if err := ensureSongIdentity(db); err != nil {
+        return fmt.Errorf("allocating song IDs: %w", err)
+    }
 
     return nil
 }
 
+const (
+    // maxSongID is the largest ID that fits the uint32 song IDs used everywhere else.
+    maxSongID = 4294967295
+    // minSongIDHeadroom is how many IDs above the highest legacy one the identity
+    // column needs. Tables with less are renumbered first.
+    minSongIDHeadroom = 1 << 20
+)
+
+// ErrSongIDsExhausted is returned, wrapped, when a songs table holds so many songs that
+// even renumbered they leave no ID to allocate.
+var ErrSongIDsExhausted = errors.New("no room left to allocate song IDs")
+
+// ensureSongIdentity turns the id of a songs table created before the database handed
+// out IDs into an identity column that continues after the highest ID in use. Legacy
+// IDs were random uint32s, so when the highest of them leaves too little room the
+// songs are renumbered 1..n first, all in one transaction.
+func ensureSongIdentity(db *sql.DB) error {
+    var isIdentity string
+    err := db.QueryRow(`
+        SELECT is_identity FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'songs' AND column_name = 'id'
+    `).Scan(&isIdentity)
+    if err != nil {
+        return err
+    }
+    if isIdentity == "YES" {
+        return nil
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var next, songs int64
+    if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) + 1, COUNT(*) FROM songs`).Scan(&next, &songs); err != nil {
+        return err
+    }
+
+    if next > maxSongID-minSongIDHeadroom {
+        if songs >= maxSongID {
+            return fmt.Errorf("%w: %d songs", ErrSongIDsExhausted, songs)
+        }
+        if err := renumberSongs(tx); err != nil {
+            return fmt.Errorf("renumbering songs: %w", err)
+        }
+        fmt.Fprintf(os.Stderr, "renumbered %d songs, the highest ID was %d\n", songs, next-1)
+        next = songs + 1
+    }
+
+    alter := fmt.Sprintf(`ALTER TABLE songs ALTER COLUMN id ADD GENERATED BY DEFAULT AS IDENTITY (START WITH %d MAXVALUE %d)`, next, maxSongID)
+    if _, err := tx.Exec(alter); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// sqlStep is one statement of a migration, named for its error message.
+type sqlStep struct {
+    name  string
+    query string
+}
+
+func runSteps(tx *sql.Tx, steps []sqlStep) error {
+    for _, step := range steps {
+        if _, err := tx.Exec(step.query); err != nil {
+            return fmt.Errorf("%s: %w", step.name, err)
+        }
+    }
+    return nil
+}
+
+/*
+renumberSongs compacts songs.id to 1..n in ID order and rewrites the "songID" of
+fingerprints and chroma to match. Every table goes through negative IDs first, so no
+row takes an ID another still holds. Fingerprints and chroma of songs that no longer
+exist keep a non-negative ID through the first pass and are deleted, since a new song
+could otherwise inherit them.
+*/
+func renumberSongs(tx *sql.Tx) error {
+    return runSteps(tx, []sqlStep{
+        {"numbering songs", `CREATE TEMP TABLE song_renumbering ON COMMIT DROP AS
+            SELECT id AS old, ROW_NUMBER() OVER (ORDER BY id) AS new FROM songs`},
+        {"indexing the numbering", `CREATE UNIQUE INDEX ON song_renumbering (old)`},
+        {"moving songs", `UPDATE songs SET id = -r.new FROM song_renumbering r WHERE songs.id = r.old`},
+        {"moving fingerprints", `UPDATE fingerprints SET "songID" = -r.new FROM song_renumbering r WHERE fingerprints."songID" = r.old`},
+        {"moving chroma", `UPDATE chroma SET "songID" = -r.new FROM song_renumbering r WHERE chroma."songID" = r.old`},
+        {"deleting orphaned chroma", `DELETE FROM chroma WHERE "songID" >= 0`},
+        {"deleting orphaned fingerprints", `DELETE FROM fingerprints WHERE "songID" >= 0`},
+        {"renumbering songs", `UPDATE songs SET id = -id`},
+        {"renumbering fingerprints", `UPDATE fingerprints SET "songID" = -"songID"`},
+        {"renumbering chroma", `UPDATE chroma SET "songID" = -"songID"`},
+    })
+}
+
 func (c *PostgresClient) StoreFingerprints(fingerprints map[int64]models.Couple) error {
     if len(fingerprints) == 0 {
         return nil
@@ -82,12 +233,15 @@ func (c *PostgresClient) StoreFingerprints(fingerprints map[int64]models.Couple)
 
     currentBatch := make(map[int64]models.Couple, batchSize)
     count := 0
+    stored := 0
+
     
     for address, couple := range fingerprints {
         currentBatch[address] = couple
         count++
+        stored++
         
-        if count == batchSize || len(currentBatch) == len(fingerprints) {
+        if count == batchSize || stored == len(fingerprints) {
             
             valueStrings := make([]string, 0, len(currentBatch))
             valueArgs := make([]any, 0, len(currentBatch) * 3)
@@ -105,7 +259,7 @@ func (c *PostgresClient) StoreFingerprints(fingerprints map[int64]models.Couple)
                 ON CONFLICT (address, "anchorTimeMs", "songID") DO NOTHING
             `, strings.Join(valueStrings, ","))
             
-            if _, err = tx.Exec(insertQuery, valueArgs...); err != nil {
+            if _, err := tx.Exec(insertQuery, valueArgs...); err != nil {
                 return err
             }
 
@@ -149,12 +303,229 @@ func (c *PostgresClient) GetCouples(addresses []int64) (map[int64][]models.Coupl
     return couples, nil
 }
 
+func (c *PostgresClient) AddressFrequencies(addresses []int64) (map[int64]int, error) {
+    frequencies := make(map[int64]int)
+
+    if len(addresses) == 0 {
+        return frequencies, nil
+    }
+
+    // counted from the address index rather than kept up to date, so ingestion doesn't
+    // pay for them while stop words are off
+    query := `SELECT address, COUNT(DISTINCT "songID") FROM fingerprints WHERE address = ANY($1) GROUP BY address`
+
+    rows, err := c.db.Query(query, addresses)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var address int64
+        var songs int
+        if err := rows.Scan(&address, &songs); err != nil {
+            return nil, err
+        }
+        frequencies[address] = songs
+    }
+
+    return frequencies, rows.Err()
+}
+
+func (c *PostgresClient) HasAddressesBetween(low, high int64) (bool, error) {
+    var found bool
+    err := c.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM fingerprints WHERE address >= $1 AND address < $2)`, low, high).Scan(&found)
+    if err != nil {
+        return false, fmt.Errorf("error looking up addresses: %w", err)
+    }
+    return found, nil
+}
+
+func (c *PostgresClient) WalkFingerprints(fn func(address int64, couple models.Couple) error) error {
+    rows, err := c.db.Query(`SELECT address, "anchorTimeMs", "songID" FROM fingerprints ORDER BY "songID", address, "anchorTimeMs"`)
+    if err != nil {
+        return fmt.Errorf("error reading fingerprints: %w", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var address, anchorTime, songID int64
+        if err := rows.Scan(&address, &anchorTime, &songID); err != nil {
+            return err
+        }
+        if err := fn(address, models.Couple{AnchorTime: uint32(anchorTime), SongId: uint32(songID)}); err != nil {
+            return err
+        }
+    }
+
+    return rows.Err()
+}
+
+func (c *PostgresClient) RewriteAddresses(rewrite func(address int64) (int64, error)) (int, error) {
+    tx, err := c.db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    rows, err := tx.Query(`SELECT DISTINCT address FROM fingerprints`)
+    if err != nil {
+        return 0, err
+    }
+
+    mapping := make(map[int64]int64)
+    for rows.Next() {
+        var address int64
+        if err := rows.Scan(&address); err != nil {
+            rows.Close()
+            return 0, err
+        }
+
+        newAddress, err := rewrite(address)
+        if err != nil {
+            rows.Close()
+            return 0, fmt.Errorf("rewriting address %d: %w", address, err)
+        }
+        if newAddress != address {
+            mapping[address] = newAddress
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    if len(mapping) == 0 {
+        return 0, tx.Commit()
+    }
+
+    createMapping := `CREATE TEMP TABLE address_migration (old BIGINT PRIMARY KEY, new BIGINT NOT NULL) ON COMMIT DROP`
+    if _, err := tx.Exec(createMapping); err != nil {
+        return 0, fmt.Errorf("creating migration table: %w", err)
+    }
+
+    const batchSize = 20000
+
+    valueStrings := make([]string, 0, batchSize)
+    valueArgs := make([]any, 0, batchSize*2)
+    flush := func() error {
+        if len(valueStrings) == 0 {
+            return nil
+        }
+        insertQuery := fmt.Sprintf(`INSERT INTO address_migration (old, new) VALUES %s`, strings.Join(valueStrings, ","))
+        _, err := tx.Exec(insertQuery, valueArgs...)
+        valueStrings = valueStrings[:0]
+        valueArgs = valueArgs[:0]
+        return err
+    }
+
+    for oldAddress, newAddress := range mapping {
+        valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d)", len(valueArgs)+1, len(valueArgs)+2))
+        valueArgs = append(valueArgs, oldAddress, newAddress)
+
+        if len(valueStrings) == batchSize {
+            if err := flush(); err != nil {
+                return 0, err
+            }
+        }
+    }
+    if err := flush(); err != nil {
+        return 0, err
+    }
+
+    result, err := tx.Exec(`UPDATE fingerprints f SET address = m.new FROM address_migration m WHERE f.address = m.old`)
+    if err != nil {
+        return 0, fmt.Errorf("rewriting fingerprints: %w", err)
+    }
+    changed, err := result.RowsAffected()
+    if err != nil {
+        return 0, err
+    }
+
+    return int(changed), tx.Commit()
+}
+
 func (c *PostgresClient) TotalSongs() (int, error) {
     var count int
     err := c.db.QueryRow(`SELECT COUNT(*) FROM songs`).Scan(&count)
     return count, err
 }
 
+func (c *PostgresClient) Stats(topAddresses int) (StoreStats, error) {
+    if err := checkStatsArgs(topAddresses); err != nil {
+        return StoreStats{}, err
+    }
+
+    var stats StoreStats
+
+    err := c.db.QueryRow(`SELECT (SELECT COUNT(*) FROM songs), (SELECT COUNT(*) FROM fingerprints)`).
+        Scan(&stats.Songs, &stats.Fingerprints)
+    if err != nil {
+        return StoreStats{}, err
+    }
+
+    perSongQuery := `
+        SELECT s.id, COUNT(f."songID") FROM songs s
+        LEFT JOIN fingerprints f ON f."songID" = s.id
+        GROUP BY s.id
+    `
+    rows, err := c.db.Query(perSongQuery)
+    if err != nil {
+        return StoreStats{}, err
+    }
+    for rows.Next() {
+        var id int64
+        var count int
+        if err := rows.Scan(&id, &count); err != nil {
+            rows.Close()
+            return StoreStats{}, err
+        }
+        stats.PerSong = append(stats.PerSong, SongCount{SongID: uint32(id), Fingerprints: count})
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return StoreStats{}, err
+    }
+    stats.summarisePerSong()
+
+    topQuery := `SELECT address, COUNT(*) AS count FROM fingerprints GROUP BY address ORDER BY count DESC, address LIMIT $1`
+    rows, err = c.db.Query(topQuery, topAddresses)
+    if err != nil {
+        return StoreStats{}, err
+    }
+    for rows.Next() {
+        var address AddressCount
+        if err := rows.Scan(&address.Address, &address.Count); err != nil {
+            rows.Close()
+            return StoreStats{}, err
+        }
+        stats.TopAddresses = append(stats.TopAddresses, address)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return StoreStats{}, err
+    }
+
+    orphanQuery := `
+        SELECT COUNT(*) FROM fingerprints f
+        WHERE NOT EXISTS (SELECT 1 FROM songs s WHERE s.id = f."songID")
+    `
+    if err := c.db.QueryRow(orphanQuery).Scan(&stats.OrphanedFingerprints); err != nil {
+        return StoreStats{}, err
+    }
+
+    stats.SizeBytes = make(map[string]int64)
+    for _, table := range postgresTables {
+        var size int64
+        if err := c.db.QueryRow(`SELECT pg_total_relation_size($1::regclass)`, table).Scan(&size); err != nil {
+            return StoreStats{}, fmt.Errorf("sizing %s: %w", table, err)
+        }
+        stats.SizeBytes[table] = size
+    }
+
+    return stats, nil
+}
+
 func (c *PostgresClient) RegisterSong(songTitle, songArtist, ytID string) (uint32, error) {
     tx, err := c.db.Begin()
     if err != nil {
@@ -162,15 +533,16 @@ func (c *PostgresClient) RegisterSong(songTitle, songArtist, ytID string) (uint3
     }
     defer tx.Rollback()
 
-    songID := utils.GenerateUniqueID()
     songKey := utils.GenerateSongKey(songTitle, songArtist)
 
-    query := `INSERT INTO songs (id, title, artist, "ytID", key) VALUES ($1, $2, $3, $4, $5)`
-    
-    _, err = tx.Exec(query, int64(songID), songTitle, songArtist, ytID, songKey)
+    // the identity column allocates the ID, so there is nothing to retry on collision
+    query := `INSERT INTO songs (title, artist, "ytID", key) VALUES ($1, $2, $3, $4) RETURNING id`
+
+    var songID int64
+    err = tx.QueryRow(query, songTitle, songArtist, ytID, songKey).Scan(&songID)
     if err != nil {
-        if strings.Contains(err.Error(), "duplicate key") {
-            return 0, fmt.Errorf("song already exists: %w", err)
+        if isUniqueViolation(err) {
+            return 0, fmt.Errorf("%w: %s", ErrSongExists, songKey)
         }
         return 0, fmt.Errorf("failed to insert song: %w", err)
     }
@@ -178,23 +550,105 @@ func (c *PostgresClient) RegisterSong(songTitle, songArtist, ytID string) (uint3
     if err := tx.Commit(); err != nil {
         return 0, err
     }
-    return songID, nil
+    return uint32(songID), nil
+}
+
+func (c *PostgresClient) RegisterOrGetSong(song Song) (uint32, error) {
+    tx, err := c.db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    songKey := utils.GenerateSongKey(song.Title, song.Artist)
+
+    findQuery := `
+        SELECT id, key FROM songs
+        WHERE key = $1 OR ($2 <> '' AND "ytID" = $2) OR ($3 <> '' AND "contentHash" = $3)
+        ORDER BY id LIMIT 1
+    `
+
+    var existingID int64
+    var existingKey string
+    err = tx.QueryRow(findQuery, songKey, song.YouTubeID, song.ContentHash).Scan(&existingID, &existingKey)
+    if err == nil {
+        return uint32(existingID), fmt.Errorf("%w: %s", ErrSongExists, existingKey)
+    }
+    if err != sql.ErrNoRows {
+        return 0, err
+    }
+
+    // a concurrent registration of the same key wins the insert, fall back to its row
+    insertQuery := `
+        INSERT INTO songs (title, artist, "ytID", key, "contentHash", album, duration, isrc, "releaseYear", genre, "sourcePath")
+        VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11)
+        ON CONFLICT (key) DO NOTHING
+        RETURNING id
+    `
+
+    var songID int64
+    err = tx.QueryRow(insertQuery, song.Title, song.Artist, song.YouTubeID, songKey, song.ContentHash,
+        song.Album, song.Duration, song.ISRC, song.ReleaseYear, song.Genre, song.SourcePath).Scan(&songID)
+    if err == sql.ErrNoRows {
+        if err := c.db.QueryRow(`SELECT id FROM songs WHERE key = $1`, songKey).Scan(&existingID); err != nil {
+            return 0, err
+        }
+        return uint32(existingID), fmt.Errorf("%w: %s", ErrSongExists, songKey)
+    }
+    if err != nil {
+        return 0, fmt.Errorf("failed to insert song: %w", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, err
+    }
+    return uint32(songID), nil
+}
+
+func (c *PostgresClient) MarkSongFingerprinted(songID uint32) error {
+    result, err := c.db.Exec(`UPDATE songs SET fingerprinted = TRUE WHERE id = $1`, int64(songID))
+    if err != nil {
+        return err
+    }
+    if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+        return fmt.Errorf("song %d doesn't exist", songID)
+    }
+    return nil
+}
+
+func isUniqueViolation(err error) bool {
+    var pgErr *pgconn.PgError
+    return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// songColumns is what scanSong reads, in order.
+const songColumns = `id, title, artist, COALESCE("ytID", ''), album, duration, isrc, "releaseYear", genre,
+    "sourcePath", "createdAt", COALESCE("contentHash", ''), fingerprinted`
+
+// scanSong reads songColumns, then any extra columns selected after them into extra.
+func scanSong(row interface{ Scan(dest ...any) error }, extra ...any) (Song, error) {
+    var song Song
+    var id int64
+    dest := []any{&id, &song.Title, &song.Artist, &song.YouTubeID, &song.Album, &song.Duration, &song.ISRC,
+        &song.ReleaseYear, &song.Genre, &song.SourcePath, &song.CreatedAt, &song.ContentHash, &song.Fingerprinted}
+    err := row.Scan(append(dest, extra...)...)
+    song.ID = uint32(id)
+    return song, err
 }
 
 func (c *PostgresClient) GetSong(filterKey string, value interface{}) (Song, bool, error) {
-    validKeys := map[string]bool{"id": true, "ytID": true, "key": true}
+    validKeys := map[string]bool{"id": true, "ytID": true, "key": true, "contentHash": true}
     if !validKeys[filterKey] {
         return Song{}, false, fmt.Errorf("invalid filter key")
     }
 
-    if filterKey == "ytID" {
-        filterKey = `"ytID"`
+    if filterKey == "ytID" || filterKey == "contentHash" {
+        filterKey = `"` + filterKey + `"`
     }
 
-    query := fmt.Sprintf(`SELECT title, artist, "ytID" FROM songs WHERE %s = $1`, filterKey)
+    query := fmt.Sprintf(`SELECT %s FROM songs WHERE %s = $1`, songColumns, filterKey)
     
-    var song Song
-    err := c.db.QueryRow(query, value).Scan(&song.Title, &song.Artist, &song.YouTubeID)
+    song, err := scanSong(c.db.QueryRow(query, value))
     if err != nil {
         if err == sql.ErrNoRows {
             return Song{}, false, nil
@@ -205,6 +659,112 @@ func (c *PostgresClient) GetSong(filterKey string, value interface{}) (Song, boo
     return song, true, nil
 }
 
+func (c *PostgresClient) SearchSongs(query string, limit, offset int) ([]Song, error) {
+    if err := checkSearchArgs(limit, offset); err != nil {
+        return nil, err
+    }
+
+    // match query literally, % and _ in it are not wildcards
+    escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+    pattern := "%" + escaper.Replace(query) + "%"
+
+    searchQuery := fmt.Sprintf(`
+        SELECT %s FROM songs
+        WHERE title ILIKE $1 OR artist ILIKE $1
+        ORDER BY title, artist, id
+        LIMIT $2 OFFSET $3
+    `, songColumns)
+
+    rows, err := c.db.Query(searchQuery, pattern, limit, offset)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var songs []Song
+    for rows.Next() {
+        song, err := scanSong(rows)
+        if err != nil {
+            return nil, err
+        }
+        songs = append(songs, song)
+    }
+
+    return songs, rows.Err()
+}
+
+func (c *PostgresClient) ListSongs(cursor string, limit int, sort SongSort) (SongPage, error) {
+    if err := checkListArgs(limit, sort); err != nil {
+        return SongPage{}, err
+    }
+    after, err := decodeSongCursor(cursor, sort)
+    if err != nil {
+        return SongPage{}, err
+    }
+
+    sortColumn := map[SongSort]string{
+        SortByID:      "id",
+        SortByTitle:   "title",
+        SortByArtist:  "artist",
+        SortByCreated: `"createdAt"`,
+    }[sort]
+
+    // one row more than asked for tells whether there is a next page
+    args := []any{limit + 1}
+    where := ""
+    if cursor != "" {
+        switch sort {
+        case SortByID:
+            where = `WHERE id > $2`
+            args = append(args, int64(after.ID))
+        case SortByCreated:
+            where = `WHERE ("createdAt", id) > ($2::timestamptz, $3)`
+            args = append(args, after.Key, int64(after.ID))
+        default:
+            where = fmt.Sprintf(`WHERE (%s, id) > ($2, $3)`, sortColumn)
+            args = append(args, after.Key, int64(after.ID))
+        }
+    }
+
+    // pick the page first, so fingerprints are only counted for the songs on it
+    query := fmt.Sprintf(`
+        WITH page AS (
+            SELECT * FROM songs
+            %s
+            ORDER BY %s, id
+            LIMIT $1
+        )
+        SELECT %s, fp.count FROM page
+        CROSS JOIN LATERAL (SELECT COUNT(*) AS count FROM fingerprints f WHERE f."songID" = page.id) fp
+        ORDER BY %s, id
+    `, where, sortColumn, songColumns, sortColumn)
+
+    rows, err := c.db.Query(query, args...)
+    if err != nil {
+        return SongPage{}, err
+    }
+    defer rows.Close()
+
+    var page SongPage
+    for rows.Next() {
+        var listing SongListing
+        listing.Song, err = scanSong(rows, &listing.Fingerprints)
+        if err != nil {
+            return SongPage{}, err
+        }
+        page.Songs = append(page.Songs, listing)
+    }
+    if err := rows.Err(); err != nil {
+        return SongPage{}, err
+    }
+
+    if len(page.Songs) > limit {
+        page.Songs = page.Songs[:limit]
+        page.NextCursor = cursorAfter(page.Songs[limit-1].Song, sort)
+    }
+    return page, nil
+}
+
 func (c *PostgresClient) GetSongByID(id uint32) (Song, bool, error) { 
     return c.GetSong("id", int64(id)) 
 }
@@ -217,11 +777,99 @@ func (c *PostgresClient) GetSongByKey(k string) (Song, bool, error) {
     return c.GetSong("key", k) 
 }
 
+func (c *PostgresClient) UpdateSongMetadata(song Song) error {
+    query := `UPDATE songs SET album = $2, isrc = $3, "releaseYear" = $4, genre = $5, "ytID" = NULLIF($6, '') WHERE id = $1`
+    result, err := c.db.Exec(query, int64(song.ID), song.Album, song.ISRC, song.ReleaseYear, song.Genre, song.YouTubeID)
+    if err != nil {
+        return err
+    }
+    if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+        return fmt.Errorf("song %d doesn't exist", song.ID)
+    }
+    return nil
+}
+
 func (c *PostgresClient) DeleteSongByID(id uint32) error {
     _, err := c.db.Exec(`DELETE FROM songs WHERE id = $1`, int64(id))
     return err
 }
 
+func (c *PostgresClient) DeleteSongFingerprints(songID uint32) (int, error) {
+    result, err := c.db.Exec(`DELETE FROM fingerprints WHERE "songID" = $1`, int64(songID))
+    if err != nil {
+        return 0, fmt.Errorf("error deleting fingerprints of song %d: %w", songID, err)
+    }
+    deleted, err := result.RowsAffected()
+    if err != nil {
+        return 0, err
+    }
+    return int(deleted), nil
+}
+
+func (c *PostgresClient) StoreChroma(songID uint32, features []byte) error {
+    query := `
+        INSERT INTO chroma ("songID", features) VALUES ($1, $2)
+        ON CONFLICT ("songID") DO UPDATE SET features = EXCLUDED.features
+    `
+    _, err := c.db.Exec(query, int64(songID), features)
+    return err
+}
+
+func (c *PostgresClient) WalkChroma(fn func(songID uint32, features []byte) error) error {
+    rows, err := c.db.Query(`SELECT "songID", features FROM chroma ORDER BY "songID"`)
+    if err != nil {
+        return fmt.Errorf("error reading chroma: %w", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var songID int64
+        var features []byte
+        if err := rows.Scan(&songID, &features); err != nil {
+            return err
+        }
+        if err := fn(uint32(songID), features); err != nil {
+            return err
+        }
+    }
+
+    return rows.Err()
+}
+
+func (c *PostgresClient) GetChroma(songIDs []uint32) (map[uint32][]byte, error) {
+    found := make(map[uint32][]byte, len(songIDs))
+    if len(songIDs) == 0 {
+        return found, nil
+    }
+
+    ids := make([]int64, len(songIDs))
+    for i, songID := range songIDs {
+        ids[i] = int64(songID)
+    }
+
+    rows, err := c.db.Query(`SELECT "songID", features FROM chroma WHERE "songID" = ANY($1)`, ids)
+    if err != nil {
+        return nil, fmt.Errorf("error reading chroma: %w", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var songID int64
+        var features []byte
+        if err := rows.Scan(&songID, &features); err != nil {
+            return nil, err
+        }
+        found[uint32(songID)] = features
+    }
+
+    return found, rows.Err()
+}
+
+func (c *PostgresClient) DeleteChroma(songID uint32) error {
+    _, err := c.db.Exec(`DELETE FROM chroma WHERE "songID" = $1`, int64(songID))
+    return err
+}
+
 func (c *PostgresClient) DeleteCollection(table string) error {
     if table != "songs" && table != "fingerprints" {
         return fmt.Errorf("unauthorized table drop")
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AddressCount is how many fingerprints share one address.
+type AddressCount struct {
+	Address int64
+	Count   int
+}
+
+// SongCount is how many fingerprints one song has.
+type SongCount struct {
+	SongID       uint32
+	Fingerprints int
+}
+
+// StoreStats describes the size and health of an index.
+type StoreStats struct {
+	Songs        int
+	Fingerprints int
+
+	// Fingerprints per song, over every registered song including those with none.
+	MinPerSong    int
+	MedianPerSong int
+	MaxPerSong    int
+	// PerSong holds every song, fewest fingerprints first.
+	PerSong []SongCount
+
+	// TopAddresses are the most shared addresses, biggest first. A query hash that
+	// lands in one of them pulls in couples from a large part of the catalogue.
+	TopAddresses []AddressCount
+
+	// OrphanedFingerprints belong to song IDs that are no longer registered.
+	OrphanedFingerprints int
+
+	// SizeBytes is the on-disk size of each table, including indexes. Stores that
+	// don't persist anything leave it empty.
+	SizeBytes map[string]int64
+}
+
+func checkStatsArgs(topAddresses int) error {
+	if topAddresses < 0 {
+		return fmt.Errorf("the number of top addresses must not be negative, got %d", topAddresses)
+	}
+	return nil
+}
+
+// summarisePerSong sorts stats.PerSong and fills in the min, median and max from it.
+func (s *StoreStats) summarisePerSong() {
+	sort.Slice(s.PerSong, func(i, j int) bool {
+		if s.PerSong[i].Fingerprints != s.PerSong[j].Fingerprints {
+			return s.PerSong[i].Fingerprints < s.PerSong[j].Fingerprints
+		}
+		return s.PerSong[i].SongID < s.PerSong[j].SongID
+	})
+
+	if len(s.PerSong) == 0 {
+		return
+	}
+	s.MinPerSong = s.PerSong[0].Fingerprints
+	s.MaxPerSong = s.PerSong[len(s.PerSong)-1].Fingerprints
+
+	mid := len(s.PerSong) / 2
+	s.MedianPerSong = s.PerSong[mid].Fingerprints
+	if len(s.PerSong)%2 == 0 {
+		s.MedianPerSong = (s.PerSong[mid-1].Fingerprints + s.PerSong[mid].Fingerprints) / 2
+	}
+}
+
+/*
+SparseSongs returns the songs with fewer than minimum fingerprints or fewer than
+fraction of the median, fewest first. A healthy song has a number of hashes roughly
+proportional to its length, so these are usually silent, truncated or broken files.
+*/
+func (s StoreStats) SparseSongs(minimum int, fraction float64) []SongCount {
+	threshold := int(fraction * float64(s.MedianPerSong))
+	if minimum > threshold {
+		threshold = minimum
+	}
+
+	var sparse []SongCount
+	for _, song := range s.PerSong {
+		if song.Fingerprints >= threshold {
+			break
+		}
+		sparse = append(sparse, song)
+	}
+	return sparse
+}
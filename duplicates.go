@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"shazoom/core"
+	"shazoom/db"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+func init() {
+	commands["duplicates"] = command{summary: "find songs uploaded more than once, edits and remasters", run: runDuplicates}
+	commands["merge"] = command{summary: "keep one song of a duplicate cluster and delete the rest", run: runMerge}
+	commands["delete"] = command{summary: "delete songs and their fingerprints", run: runDelete}
+}
+
+func runDuplicates(args []string) error {
+	defaults := core.DefaultDuplicateOptions()
+	flags := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	minScore := flags.Float64("min-score", defaults.MinScore, "timing score a pair needs to be compared")
+	minOverlap := flags.Float64("min-overlap", defaults.MinOverlap, "share of the shorter song that has to be in the other")
+	asJSON := flags.Bool("json", false, "print one JSON object per cluster")
+	flags.Parse(args)
+
+	opts := defaults
+	opts.MinScore, opts.MinOverlap = *minScore, *minOverlap
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	clusters, err := core.NewMatcher(dbClient).FindDuplicates(opts)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, cluster := range clusters {
+			if err := encoder.Encode(cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	writeDuplicateReport(os.Stdout, clusters)
+	return nil
+}
+
+func writeDuplicateReport(w io.Writer, clusters []core.DuplicateCluster) {
+	if len(clusters) == 0 {
+		fmt.Fprintln(w, "no duplicates found")
+		return
+	}
+
+	for i, cluster := range clusters {
+		fmt.Fprintf(w, "cluster %d:\n", i+1)
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "  ID\tTITLE\tARTIST\tALBUM\tDURATION")
+		for _, song := range cluster.Songs {
+			fmt.Fprintf(table, "  %d\t%s\t%s\t%s\t%s\n", song.ID, song.Title, song.Artist, song.Album, formatDuration(song.Duration))
+		}
+		table.Flush()
+
+		table = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "  PAIR\tOVERLAP\tALIGNMENT\tSCORE")
+		for _, pair := range cluster.Pairs {
+			fmt.Fprintf(table, "  %d ~ %d\t%.0f%% / %.0f%%\t%s\t%.0f\n", pair.SongA, pair.SongB,
+				100*pair.OverlapA, 100*pair.OverlapB, describeAlignment(pair), pair.Score)
+		}
+		table.Flush()
+
+		ids := make([]string, 0, len(cluster.Songs)-1)
+		for _, song := range cluster.Songs[1:] {
+			ids = append(ids, strconv.FormatUint(uint64(song.ID), 10))
+		}
+		fmt.Fprintf(w, "  to keep %d: shazoom merge -keep %d %s\n\n", cluster.Songs[0].ID, cluster.Songs[0].ID, strings.Join(ids, " "))
+	}
+}
+
+func describeAlignment(pair core.DuplicatePair) string {
+	offset := float64(pair.OffsetMs) / 1000
+	switch {
+	case offset >= 0.5:
+		return fmt.Sprintf("%d starts %.1fs into %d", pair.SongA, offset, pair.SongB)
+	case offset <= -0.5:
+		return fmt.Sprintf("%d starts %.1fs into %d", pair.SongB, -offset, pair.SongA)
+	default:
+		return "same start"
+	}
+}
+
+func runMerge(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	keep := flags.Uint("keep", 0, "ID of the song to keep")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: shazoom merge -keep <id> <duplicate id>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *keep == 0 || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	duplicates, err := parseSongIDs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	stats, err := core.MergeDuplicates(dbClient, uint32(*keep), duplicates)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "kept song %d, deleted %d songs and %d fingerprints\n", *keep, stats.Songs, stats.Fingerprints)
+	return nil
+}
+
+func runDelete(args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: shazoom delete <id>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	songIDs, err := parseSongIDs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	for _, songID := range songIDs {
+		_, found, err := dbClient.GetSongByID(songID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("song %d doesn't exist", songID)
+		}
+		deleted, err := core.DeleteSong(dbClient, songID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "deleted song %d and %d fingerprints\n", songID, deleted)
+	}
+	return nil
+}
+
+func parseSongIDs(args []string) ([]uint32, error) {
+	songIDs := make([]uint32, 0, len(args))
+	for _, arg := range args {
+		songID, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil || songID == 0 {
+			return nil, fmt.Errorf("%q is not a song ID", arg)
+		}
+		songIDs = append(songIDs, uint32(songID))
+	}
+	return songIDs, nil
+}
@@ -0,0 +1,78 @@
+package eval
+
+import (
+	"fmt"
+	"path/filepath"
+	"shazoom/core"
+	"shazoom/db"
+	"shazoom/fileformat"
+	"strings"
+)
+
+// Track is one recording of the evaluation catalogue, held as mono samples.
+type Track struct {
+	Title      string
+	Artist     string
+	Samples    []float64
+	SampleRate int
+}
+
+func (t Track) Duration() float64 {
+	return float64(len(t.Samples)) / float64(t.SampleRate)
+}
+
+// LoadCatalogue reads every 16-bit PCM .wav file in dir as a track. The file name
+// (without extension) becomes the title, stereo files are averaged down to mono.
+func LoadCatalogue(dir string) ([]Track, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wav"))
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]Track, 0, len(paths))
+	for _, path := range paths {
+		wavInfo, err := fileformat.ReadWavInfo(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+
+		samples := wavInfo.LeftChannelSamples
+		if wavInfo.Channels == 2 {
+			samples = make([]float64, len(wavInfo.LeftChannelSamples))
+			for i := range samples {
+				samples[i] = (wavInfo.LeftChannelSamples[i] + wavInfo.RightChannelSamples[i]) / 2
+			}
+		}
+
+		tracks = append(tracks, Track{
+			Title:      strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			Artist:     "eval",
+			Samples:    samples,
+			SampleRate: wavInfo.SampleRate,
+		})
+	}
+
+	return tracks, nil
+}
+
+// IndexCatalogue registers and fingerprints every track in dbClient with cfg and
+// returns the song IDs in the order of tracks. Tracks already in dbClient are skipped.
+func IndexCatalogue(dbClient db.DBClient, tracks []Track, cfg core.Config) ([]uint32, error) {
+	songIDs := make([]uint32, len(tracks))
+
+	indexer := core.NewIndexer(dbClient)
+	indexer.Config = cfg
+
+	for i, track := range tracks {
+		song := db.Song{Title: track.Title, Artist: track.Artist}
+
+		result, err := indexer.IndexSamples(track.Samples, track.SampleRate, song)
+		if err != nil {
+			return nil, err
+		}
+
+		songIDs[i] = result.SongID
+	}
+
+	return songIDs, nil
+}
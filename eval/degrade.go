@@ -0,0 +1,155 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Degradation transforms a query clip the way a real capture would. Apply must not
+// modify its input.
+type Degradation struct {
+	Name  string
+	Apply func(samples []float64, sampleRate int, rng *rand.Rand) []float64
+}
+
+// WhiteNoise mixes in gaussian white noise at snrDb relative to the clip's power.
+func WhiteNoise(snrDb float64) Degradation {
+	return Degradation{
+		Name: fmt.Sprintf("white noise %gdB", snrDb),
+		Apply: func(samples []float64, _ int, rng *rand.Rand) []float64 {
+			noise := make([]float64, len(samples))
+			for i := range noise {
+				noise[i] = rng.NormFloat64()
+			}
+			return mixAtSNR(samples, noise, snrDb)
+		},
+	}
+}
+
+// PinkNoise mixes in 1/f noise at snrDb, which like room and crowd noise carries most
+// of its energy in the low frequencies the fingerprint relies on.
+func PinkNoise(snrDb float64) Degradation {
+	return Degradation{
+		Name: fmt.Sprintf("pink noise %gdB", snrDb),
+		Apply: func(samples []float64, _ int, rng *rand.Rand) []float64 {
+			// Paul Kellet's economy filter turns white noise into pink
+			var b0, b1, b2 float64
+			noise := make([]float64, len(samples))
+			for i := range noise {
+				white := rng.NormFloat64()
+				b0 = 0.99765*b0 + white*0.0990460
+				b1 = 0.96300*b1 + white*0.2965164
+				b2 = 0.57000*b2 + white*1.0526913
+				noise[i] = b0 + b1 + b2 + white*0.1848
+			}
+			return mixAtSNR(samples, noise, snrDb)
+		},
+	}
+}
+
+// Clipping drives the clip by gainDb and hard clips it to [-1, 1], like an
+// overloaded microphone preamp.
+func Clipping(gainDb float64) Degradation {
+	return Degradation{
+		Name: fmt.Sprintf("clipping +%gdB", gainDb),
+		Apply: func(samples []float64, _ int, _ *rand.Rand) []float64 {
+			gain := math.Pow(10, gainDb/20)
+			out := make([]float64, len(samples))
+			for i, s := range samples {
+				out[i] = math.Max(-1, math.Min(1, s*gain))
+			}
+			return out
+		},
+	}
+}
+
+// EQ splits the clip at crossoverHz and scales the bands by lowGainDb and highGainDb,
+// which roughly models small speakers and phone microphones.
+func EQ(lowGainDb, highGainDb, crossoverHz float64) Degradation {
+	return Degradation{
+		Name: fmt.Sprintf("eq %+gdB/%+gdB @%gHz", lowGainDb, highGainDb, crossoverHz),
+		Apply: func(samples []float64, sampleRate int, _ *rand.Rand) []float64 {
+			lowGain := math.Pow(10, lowGainDb/20)
+			highGain := math.Pow(10, highGainDb/20)
+			alpha := onePoleAlpha(crossoverHz, float64(sampleRate))
+
+			out := make([]float64, len(samples))
+			var low float64
+			for i, s := range samples {
+				low += alpha * (s - low)
+				out[i] = low*lowGain + (s-low)*highGain
+			}
+			return out
+		},
+	}
+}
+
+// BandLimit removes everything above cutoffHz with a windowed-sinc filter, the way
+// low bitrate MP3 encoders throw away the top of the spectrum.
+func BandLimit(cutoffHz float64) Degradation {
+	return Degradation{
+		Name: fmt.Sprintf("band limit %gHz", cutoffHz),
+		Apply: func(samples []float64, sampleRate int, _ *rand.Rand) []float64 {
+			const taps = 101
+			fc := cutoffHz / float64(sampleRate)
+
+			kernel := make([]float64, taps)
+			var sum float64
+			for i := range kernel {
+				n := float64(i - taps/2)
+				if n == 0 {
+					kernel[i] = 2 * fc
+				} else {
+					kernel[i] = math.Sin(2*math.Pi*fc*n) / (math.Pi * n)
+				}
+				kernel[i] *= 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(taps-1))
+				sum += kernel[i]
+			}
+
+			out := make([]float64, len(samples))
+			for i := range samples {
+				var acc float64
+				for k, h := range kernel {
+					j := i + k - taps/2
+					if j >= 0 && j < len(samples) {
+						acc += h * samples[j]
+					}
+				}
+				out[i] = acc / sum
+			}
+			return out
+		},
+	}
+}
+
+func mixAtSNR(signal, noise []float64, snrDb float64) []float64 {
+	signalPower, noisePower := power(signal), power(noise)
+	scale := 0.0
+	if noisePower > 0 {
+		scale = math.Sqrt(signalPower / noisePower / math.Pow(10, snrDb/10))
+	}
+
+	out := make([]float64, len(signal))
+	for i := range signal {
+		out[i] = signal[i] + noise[i]*scale
+	}
+	return out
+}
+
+func power(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += s * s
+	}
+	return sum / float64(len(samples))
+}
+
+func onePoleAlpha(cutoffHz, sampleRate float64) float64 {
+	rc := 1.0 / (2 * math.Pi * cutoffHz)
+	dt := 1.0 / sampleRate
+	return dt / (rc + dt)
+}
@@ -0,0 +1,252 @@
+/*
+Package eval is an offline accuracy harness for the recogniser. It indexes a fixture
+catalogue into an in-process store, cuts query clips at random offsets, degrades them
+(noise at a given SNR, clipping, EQ, band limiting) and reports, per condition, how
+often the top match is right, how often a wrong song is confidently returned and how
+long each query takes. Nothing here needs a microphone, ffmpeg or a database.
+*/
+package eval
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"shazoom/core"
+	"shazoom/db"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Condition is a named chain of degradations applied to every query clip.
+type Condition struct {
+	Name         string
+	Degradations []Degradation
+}
+
+// NewCondition names the condition after its degradations.
+func NewCondition(degradations ...Degradation) Condition {
+	name := "clean"
+	for i, d := range degradations {
+		if i == 0 {
+			name = d.Name
+		} else {
+			name += " + " + d.Name
+		}
+	}
+	return Condition{Name: name, Degradations: degradations}
+}
+
+// DefaultConditions is the standard grid the harness reports on.
+func DefaultConditions() []Condition {
+	return []Condition{
+		NewCondition(),
+		NewCondition(WhiteNoise(10)),
+		NewCondition(WhiteNoise(0)),
+		NewCondition(PinkNoise(10)),
+		NewCondition(PinkNoise(0)),
+		NewCondition(Clipping(12)),
+		NewCondition(EQ(-12, 6, 300)),
+		NewCondition(BandLimit(3000)),
+		NewCondition(BandLimit(3000), PinkNoise(5)),
+	}
+}
+
+type Config struct {
+	// ClipLength is the length of every query in seconds.
+	ClipLength float64
+	// QueriesPerTrack clips are cut from each catalogue track and each distractor.
+	QueriesPerTrack int
+	// Distractors are tracks that are not indexed. Any confident match for them is a
+	// false positive.
+	Distractors []Track
+	Conditions  []Condition
+	// MinScore is the score the top match needs to count as an answer at all. Sustained
+	// notes repeat the same address frame after frame, so a single chance collision with
+	// another song is already worth a handful of votes.
+	MinScore float64
+	Seed     int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ClipLength:      5,
+		QueriesPerTrack: 3,
+		Conditions:      DefaultConditions(),
+		MinScore:        10,
+		Seed:            1,
+	}
+}
+
+type ConditionResult struct {
+	Condition string
+	// Queries counts clips from indexed tracks, DistractorQueries clips from distractors.
+	Queries           int
+	DistractorQueries int
+	Correct           int
+	FalsePositives    int
+	Top1Accuracy      float64
+	FalsePositiveRate float64
+	MeanLatency       time.Duration
+	P95Latency        time.Duration
+}
+
+type Report struct {
+	Results []ConditionResult
+}
+
+// WriteTo prints the report as a table.
+func (r Report) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	tw := tabwriter.NewWriter(counter, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "condition\tqueries\ttop-1\tfalse pos.\tmean latency\tp95 latency")
+	for _, result := range r.Results {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%.1f%%\t%v\t%v\n",
+			result.Condition,
+			result.Queries+result.DistractorQueries,
+			100*result.Top1Accuracy,
+			100*result.FalsePositiveRate,
+			result.MeanLatency.Round(time.Microsecond),
+			result.P95Latency.Round(time.Microsecond),
+		)
+	}
+
+	err := tw.Flush()
+	return counter.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Harness holds a catalogue indexed into an in-process store.
+type Harness struct {
+	DB      db.DBClient
+	Config  core.Config
+	tracks  []Track
+	songIDs []uint32
+}
+
+// NewHarness indexes the catalogue into a fresh db.MemoryClient. Queries are
+// fingerprinted with the same cfg.
+func NewHarness(catalogue []Track, cfg core.Config) (*Harness, error) {
+	dbClient := db.NewMemoryClient()
+
+	songIDs, err := IndexCatalogue(dbClient, catalogue, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Harness{DB: dbClient, Config: cfg, tracks: catalogue, songIDs: songIDs}, nil
+}
+
+type query struct {
+	track  Track
+	songID uint32 // 0 for distractors
+	offset int
+}
+
+// Run queries the store once per clip and condition. The same clip offsets are used
+// for every condition, so the rows of the report are directly comparable.
+func (h *Harness) Run(cfg Config) (Report, error) {
+	rng := rand.New(rand.NewSource(cfg.Seed))
+
+	var queries []query
+	addQueries := func(track Track, songID uint32) error {
+		clipSamples := int(cfg.ClipLength * float64(track.SampleRate))
+		if clipSamples <= 0 || clipSamples > len(track.Samples) {
+			return fmt.Errorf("track %q is shorter than the %.1fs clip length", track.Title, cfg.ClipLength)
+		}
+		for i := 0; i < cfg.QueriesPerTrack; i++ {
+			queries = append(queries, query{track, songID, rng.Intn(len(track.Samples) - clipSamples + 1)})
+		}
+		return nil
+	}
+
+	for i, track := range h.tracks {
+		if err := addQueries(track, h.songIDs[i]); err != nil {
+			return Report{}, err
+		}
+	}
+	for _, track := range cfg.Distractors {
+		if err := addQueries(track, 0); err != nil {
+			return Report{}, err
+		}
+	}
+
+	matcher := core.NewMatcher(h.DB)
+	matcher.Config = h.Config
+	report := Report{}
+
+	for c, condition := range cfg.Conditions {
+		result := ConditionResult{Condition: condition.Name}
+		latencies := make([]time.Duration, 0, len(queries))
+
+		for q, qry := range queries {
+			clipSamples := int(cfg.ClipLength * float64(qry.track.SampleRate))
+			clip := qry.track.Samples[qry.offset : qry.offset+clipSamples]
+
+			degradeRng := rand.New(rand.NewSource(cfg.Seed + int64(c)*1000003 + int64(q)))
+			for _, degradation := range condition.Degradations {
+				clip = degradation.Apply(clip, qry.track.SampleRate, degradeRng)
+			}
+
+			matches, latency, err := matcher.FindMatches(clip, cfg.ClipLength, qry.track.SampleRate)
+			if err != nil {
+				return Report{}, fmt.Errorf("query %d of %q failed: %w", q, condition.Name, err)
+			}
+			latencies = append(latencies, latency)
+
+			if qry.songID == 0 {
+				result.DistractorQueries++
+			} else {
+				result.Queries++
+			}
+
+			if len(matches) == 0 || matches[0].Score < cfg.MinScore {
+				continue
+			}
+			if matches[0].SongId == qry.songID {
+				result.Correct++
+			} else {
+				result.FalsePositives++
+			}
+		}
+
+		if result.Queries > 0 {
+			result.Top1Accuracy = float64(result.Correct) / float64(result.Queries)
+		}
+		if total := result.Queries + result.DistractorQueries; total > 0 {
+			result.FalsePositiveRate = float64(result.FalsePositives) / float64(total)
+		}
+		result.MeanLatency, result.P95Latency = latencyStats(latencies)
+
+		report.Results = append(report.Results, result)
+	}
+
+	return report, nil
+}
+
+func latencyStats(latencies []time.Duration) (mean, p95 time.Duration) {
+	if len(latencies) == 0 {
+		return 0, 0
+	}
+
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+
+	return total / time.Duration(len(sorted)), sorted[(len(sorted)*95-1)/100]
+}
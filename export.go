@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"shazoom/archive"
+	"shazoom/core"
+	"shazoom/db"
+)
+
+func init() {
+	commands["export"] = command{summary: "write the index to a portable archive", run: runExport}
+	commands["import"] = command{summary: "load an archive written by export", run: runImport}
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "-", "file to write, - for stdout")
+	configPath := flags.String("config", "", "JSON file with the Config the index was built with, the default config if empty")
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	stats, err := archive.Export(w, dbClient, cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d songs and %d fingerprints", stats.Songs, stats.Fingerprints)
+	if stats.Orphaned > 0 {
+		fmt.Fprintf(os.Stderr, ", left out %d orphaned fingerprints", stats.Orphaned)
+	}
+	fmt.Fprintln(os.Stderr)
+
+	if file, ok := w.(*os.File); ok && file != os.Stdout {
+		return file.Sync()
+	}
+	return nil
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	configPath := flags.String("config", "", "JSON file with the Config this store is queried with, the default config if empty")
+	force := flags.Bool("force", false, "import an archive fingerprinted with another config")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: shazoom import [flags] <archive>, - reads stdin")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	opts := archive.DefaultImportOptions()
+	opts.Config = cfg
+	opts.Force = *force
+
+	var r io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	stats, err := archive.Import(r, dbClient, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "imported %d songs and %d fingerprints, skipped %d songs already indexed\n",
+		stats.Songs, stats.Fingerprints, stats.SkippedSongs)
+	if stats.Header.Config.FingerprintVersion() != opts.Config.FingerprintVersion() {
+		fmt.Fprintln(os.Stderr, "warning: the archive was fingerprinted with another config, its songs won't match queries")
+	}
+	return nil
+}
+
+// loadConfig reads a Config written as JSON, as in the header of an archive. Settings
+// the file leaves out keep their defaults, an empty path is the default config.
+func loadConfig(path string) (core.Config, error) {
+	cfg := core.DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error reading config %s: %w", path, err)
+	}
+	return cfg, nil
+}
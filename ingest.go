@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"shazoom/core"
+	"shazoom/db"
+	"sort"
+	"strings"
+)
+
+func init() {
+	commands["ingest"] = command{summary: "register and fingerprint audio files, skipping those already indexed", run: runIngest}
+}
+
+// audioExtensions are the files ingest picks up when it walks a directory. Files named
+// on the command line are ingested whatever their extension.
+var audioExtensions = map[string]bool{
+	".aac": true, ".aiff": true, ".flac": true, ".m4a": true, ".mp3": true,
+	".ogg": true, ".opus": true, ".wav": true, ".wma": true,
+}
+
+func runIngest(args []string) error {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	title := flags.String("title", "", "title of the song, when a single file is ingested")
+	artist := flags.String("artist", "", "artist of the song, when a single file is ingested")
+	covers := flags.Bool("covers", false, "add the songs to the cover index as well")
+	workers := flags.Int("workers", core.AllProcs, "goroutines computing each spectrogram, -1 for one per CPU")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: shazoom ingest [flags] <audio file or directory>...")
+		fmt.Fprintln(os.Stderr, "Tags fill in the metadata, the file name stands in for a missing title.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *workers < core.AllProcs {
+		return fmt.Errorf("-workers must be -1 or more, got %d", *workers)
+	}
+
+	paths, err := audioFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	if (*title != "" || *artist != "") && len(paths) != 1 {
+		return fmt.Errorf("-title and -artist need a single file, got %d", len(paths))
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	indexer := core.NewIndexer(dbClient)
+	indexer.Config.Spectrogram.Workers = *workers
+	if *covers {
+		indexer.Covers = core.NewCoverIndex(dbClient)
+	}
+
+	var indexed, skipped, failed int
+	for _, path := range paths {
+		result, err := indexer.IndexFile(path, db.Song{Title: *title, Artist: *artist})
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "failed %s: %v\n", path, err)
+			failed++
+		case result.Skipped:
+			fmt.Printf("skipped %s, already indexed as song %d\n", path, result.SongID)
+			skipped++
+		default:
+			fmt.Printf("indexed %s as song %d, %d fingerprints\n", path, result.SongID, result.Fingerprints)
+			indexed++
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "indexed %d files, skipped %d already indexed, %d failed\n", indexed, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(paths))
+	}
+	return nil
+}
+
+// audioFiles expands the directories among args into the audio files below them, in
+// name order, and keeps the other arguments as they are.
+func audioFiles(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+
+		var found []string
+		err = filepath.WalkDir(arg, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && audioExtensions[strings.ToLower(filepath.Ext(path))] {
+				found = append(found, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		paths = append(paths, found...)
+	}
+	return paths, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"shazoom/db"
+	"text/tabwriter"
+)
+
+func init() {
+	commands["list"] = command{summary: "list the songs in the catalogue", run: runList}
+}
+
+func runList(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	limit := flags.Int("limit", 50, "songs per page")
+	sortName := flags.String("sort", string(db.SortByID), "order: id, title, artist or created")
+	cursor := flags.String("cursor", "", "continue after the page that printed this cursor")
+	all := flags.Bool("all", false, "follow the cursors through the whole catalogue")
+	asJSON := flags.Bool("json", false, "print one JSON object per song")
+	flags.Parse(args)
+
+	sort, err := db.ParseSongSort(*sortName)
+	if err != nil {
+		return err
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !*asJSON {
+		fmt.Fprintln(table, "ID\tTITLE\tARTIST\tALBUM\tDURATION\tFINGERPRINTS")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+
+	for {
+		page, err := dbClient.ListSongs(*cursor, *limit, sort)
+		if err != nil {
+			return err
+		}
+
+		for _, song := range page.Songs {
+			if *asJSON {
+				if err := encoder.Encode(song); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\t%d\n",
+				song.ID, song.Title, song.Artist, song.Album, formatDuration(song.Duration), song.Fingerprints)
+		}
+
+		*cursor = page.NextCursor
+		if !*all || *cursor == "" {
+			break
+		}
+	}
+
+	table.Flush()
+	if !*all && *cursor != "" {
+		fmt.Fprintf(os.Stderr, "more songs: shazoom list -sort %s -limit %d -cursor %s\n", sort, *limit, *cursor)
+	}
+	return nil
+}
+
+func formatDuration(seconds float64) string {
+	total := int(seconds + 0.5)
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
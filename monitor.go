@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"shazoom/core"
+	"shazoom/db"
+	"shazoom/monitor"
+	"syscall"
+)
+
+func init() {
+	commands["monitor"] = command{summary: "log the songs played on a PCM stream", run: runMonitor}
+	commands["station"] = command{summary: "serve a PCM file as a test radio stream", run: runStation}
+}
+
+func runMonitor(args []string) error {
+	defaults := monitor.DefaultOptions()
+	flags := flag.NewFlagSet("monitor", flag.ExitOnError)
+	rate := flags.Int("rate", defaults.SampleRate, "sample rate of the PCM")
+	channels := flags.Int("channels", defaults.Channels, "channels of the PCM")
+	window := flags.Float64("window", defaults.Segments.WindowSeconds, "seconds of audio recognised at a time")
+	hop := flags.Float64("hop", defaults.Segments.HopSeconds, "seconds between the starts of windows")
+	logPath := flags.String("log", "-", "JSON-lines event log to append to, - for stdout")
+	webhook := flags.String("webhook", "", "URL to POST every event to")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: shazoom monitor [flags] <file | - | http://stream>")
+		fmt.Fprintln(os.Stderr, "The input is raw signed 16-bit little endian PCM, streams may carry ICY metadata.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := defaults
+	opts.SampleRate, opts.Channels = *rate, *channels
+	opts.Segments.WindowSeconds, opts.Segments.HopSeconds = *window, *hop
+
+	var log io.Writer = os.Stdout
+	if *logPath != "-" {
+		file, err := os.OpenFile(*logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		log = file
+	}
+	sinks := []monitor.Sink{monitor.NewJSONLines(log)}
+	if *webhook != "" {
+		hook := monitor.NewWebhook(*webhook)
+		defer hook.Close()
+		sinks = append(sinks, hook)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source, err := monitor.Open(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	mon := monitor.New(core.NewMatcher(dbClient))
+	mon.Options = opts
+	err = mon.Run(ctx, source, sinks...)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func runStation(args []string) error {
+	flags := flag.NewFlagSet("station", flag.ExitOnError)
+	addr := flags.String("addr", ":8000", "address to listen on")
+	rate := flags.Int("rate", 44100, "sample rate of the PCM, for pacing")
+	channels := flags.Int("channels", 2, "channels of the PCM, for pacing")
+	title := flags.String("title", "", "StreamTitle sent as ICY metadata")
+	loop := flags.Bool("loop", true, "repeat the file forever")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: shazoom station [flags] <raw s16le PCM file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	pcm, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	station := &monitor.Station{
+		PCM:            pcm,
+		Title:          *title,
+		MetaInt:        16000,
+		BytesPerSecond: 2 * *rate * *channels,
+		Loop:           *loop,
+	}
+	fmt.Printf("streaming %s on %s\n", flags.Arg(0), *addr)
+	return http.ListenAndServe(*addr, station)
+}
@@ -0,0 +1,239 @@
+/*
+Package monitor keeps a play log of a broadcast. It reads raw PCM as it arrives,
+recognises overlapping windows of it with a core.SegmentTracker and reports songs
+starting and ending as events.
+*/
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"shazoom/core"
+	"time"
+)
+
+// Options describe the PCM coming in, signed 16-bit little endian and interleaved,
+// and how it is recognised.
+type Options struct {
+	SampleRate int
+	Channels   int
+	Segments   core.SegmentOptions
+}
+
+func DefaultOptions() Options {
+	return Options{
+		SampleRate: 44100,
+		Channels:   2,
+		Segments:   core.DefaultSegmentOptions(),
+	}
+}
+
+type EventType string
+
+const (
+	SongStarted EventType = "song_started"
+	SongEnded   EventType = "song_ended"
+)
+
+/*
+Event is one line of the play log. Position is in seconds of the stream and Time the
+wall clock at that position, counted from when the monitor started reading, so a
+backlog read faster than real time still gets the times it was broadcast at.
+Duration is only set when a song ends. StreamTitle is what the station announced
+when the song started, for both of its events.
+*/
+type Event struct {
+	Type        EventType `json:"event"`
+	Time        time.Time `json:"time"`
+	Position    float64   `json:"position"`
+	SongID      uint32    `json:"song_id"`
+	Title       string    `json:"title"`
+	Artist      string    `json:"artist"`
+	SongOffset  float64   `json:"song_offset"`
+	Duration    float64   `json:"duration,omitempty"`
+	Confidence  float64   `json:"confidence"`
+	Score       float64   `json:"score"`
+	StreamTitle string    `json:"stream_title,omitempty"`
+}
+
+// Sink receives events in order. An error from a sink stops the monitor.
+type Sink interface {
+	Emit(event Event) error
+}
+
+// Monitor recognises one stream. Start is the wall clock of the stream's first
+// sample, the time Run is called if zero.
+type Monitor struct {
+	Matcher *core.Matcher
+	Options Options
+	Start   time.Time
+}
+
+func New(matcher *core.Matcher) *Monitor {
+	return &Monitor{Matcher: matcher, Options: DefaultOptions()}
+}
+
+/*
+Run reads r until it ends or ctx is cancelled, recognising a window of
+Options.Segments.WindowSeconds every HopSeconds, and sends every event to each sink.
+A song still playing when the stream stops is ended there. Run returns nil when r
+ends and ctx.Err() when cancelled. If r is an io.Closer it is closed on cancellation,
+so a read waiting on a stalled stream returns.
+*/
+func (mon *Monitor) Run(ctx context.Context, r io.Reader, sinks ...Sink) error {
+	opts := mon.Options
+	if opts.SampleRate <= 0 || opts.Channels <= 0 {
+		return fmt.Errorf("sample rate and channels must be positive, got %d and %d", opts.SampleRate, opts.Channels)
+	}
+	if opts.Segments.WindowSeconds <= 0 || opts.Segments.HopSeconds <= 0 {
+		return fmt.Errorf("window and hop must be positive, got %gs and %gs", opts.Segments.WindowSeconds, opts.Segments.HopSeconds)
+	}
+
+	start := mon.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	window := int(opts.Segments.WindowSeconds * float64(opts.SampleRate))
+	hop := int(opts.Segments.HopSeconds * float64(opts.SampleRate))
+
+	// a read blocked on a stream that sends nothing only returns once it is closed
+	if closer, ok := r.(io.Closer); ok {
+		stop := context.AfterFunc(ctx, func() { closer.Close() })
+		defer stop()
+	}
+
+	tracker := mon.Matcher.NewSegmentTracker(opts.Segments)
+	buffer := make([][]float64, opts.Channels)
+	// position is the stream sample buffer[*][0] is
+	position := 0
+
+	// the titles the stream announced, each with the second it applies from. Songs
+	// are confirmed windows after they start, by when the title may have moved on.
+	source, hasTitles := r.(titled)
+	var titles []streamTitle
+	titleAt := func(at float64) string {
+		title := ""
+		for _, t := range titles {
+			if t.from > at {
+				break
+			}
+			title = t.title
+		}
+		return title
+	}
+
+	emit := func(kind EventType, segment *core.Segment) error {
+		if segment == nil {
+			return nil
+		}
+		at := segment.Start
+		event := Event{
+			Type:       kind,
+			SongID:     segment.SongID,
+			Title:      segment.Title,
+			Artist:     segment.Artist,
+			SongOffset: segment.SongOffset,
+			Confidence: segment.Confidence,
+			Score:      segment.Score,
+		}
+		if kind == SongEnded {
+			at = segment.End
+			event.Duration = segment.End - segment.Start
+		}
+		event.Position = at
+		event.Time = start.Add(time.Duration(at * float64(time.Second)))
+		event.StreamTitle = titleAt(segment.Start)
+		if kind == SongStarted {
+			// no later event looks further back than the start of this song
+			for len(titles) > 1 && titles[1].from <= segment.Start {
+				titles = titles[1:]
+			}
+		}
+
+		for _, sink := range sinks {
+			if err := sink.Emit(event); err != nil {
+				return fmt.Errorf("error emitting %s event: %w", kind, err)
+			}
+		}
+		return nil
+	}
+
+	recognise := func(length int) error {
+		slices := make([][]float64, len(buffer))
+		for i, channel := range buffer {
+			slices[i] = channel[:length]
+		}
+		started, ended, err := tracker.Add(slices, opts.SampleRate, float64(position)/float64(opts.SampleRate))
+		if err != nil {
+			return err
+		}
+		// a song ends before the next one starts
+		if err := emit(SongEnded, ended); err != nil {
+			return err
+		}
+		if err := emit(SongStarted, started); err != nil {
+			return err
+		}
+
+		for i := range buffer {
+			buffer[i] = buffer[i][min(hop, len(buffer[i])):]
+		}
+		position += hop
+		return nil
+	}
+
+	frame := 2 * opts.Channels
+	chunk := make([]byte, hop*frame)
+	var pending int
+	for {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(err, emit(SongEnded, tracker.Close()))
+		}
+
+		from := float64(position+len(buffer[0])) / float64(opts.SampleRate)
+		n, err := io.ReadFull(r, chunk[pending:])
+		if hasTitles {
+			if title := source.StreamTitle(); len(titles) == 0 || titles[len(titles)-1].title != title {
+				titles = append(titles, streamTitle{from: from, title: title})
+			}
+		}
+		n += pending
+		frames := n / frame
+		for f := 0; f < frames; f++ {
+			for c := range buffer {
+				sample := int16(binary.LittleEndian.Uint16(chunk[f*frame+2*c:]))
+				buffer[c] = append(buffer[c], float64(sample)/32768.0)
+			}
+		}
+		// keep the bytes of a frame split across reads
+		pending = copy(chunk, chunk[frames*frame:n])
+
+		for len(buffer[0]) >= window {
+			if err := recognise(window); err != nil {
+				return err
+			}
+		}
+
+		// closing r on cancellation may end it like any other stream
+		if err != nil && ctx.Err() != nil {
+			return errors.Join(ctx.Err(), emit(SongEnded, tracker.Close()))
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading the stream: %w", err)
+		}
+	}
+
+	// like RecognizeSegments, the tail gets a window if it is at least a hop long
+	for len(buffer[0]) >= hop || (position == 0 && len(buffer[0]) > 0) {
+		if err := recognise(len(buffer[0])); err != nil {
+			return err
+		}
+	}
+	return emit(SongEnded, tracker.Close())
+}
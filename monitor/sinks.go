@@ -0,0 +1,104 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"shazoom/utils"
+	"sync"
+	"time"
+)
+
+// JSONLines writes each event as one line of JSON.
+type JSONLines struct {
+	w io.Writer
+}
+
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{w: w}
+}
+
+func (s *JSONLines) Emit(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// webhookQueue is how many events a Webhook holds while the receiver is slow.
+const webhookQueue = 64
+
+/*
+Webhook POSTs each event as JSON to URL from a goroutine of its own, so a slow
+receiver never holds up recognition. A broadcast doesn't wait for a receiver that is
+down either: failures, and events that find the queue full, are logged and dropped
+rather than stopping the monitor; the JSON-lines log is the record to rely on. Create
+it with NewWebhook and Close it once the monitor has stopped.
+*/
+type Webhook struct {
+	URL    string
+	Client *http.Client
+
+	events    chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewWebhook(url string) *Webhook {
+	s := &Webhook{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		events: make(chan Event, webhookQueue),
+		done:   make(chan struct{}),
+	}
+	go s.deliver()
+	return s
+}
+
+// Emit queues the event and returns straight away.
+func (s *Webhook) Emit(event Event) error {
+	select {
+	case s.events <- event:
+	default:
+		utils.GetLogger().Error(fmt.Sprintf("webhook queue is full, dropped %s of song %d", event.Type, event.SongID))
+	}
+	return nil
+}
+
+// Close waits for the queued events to be sent. Emit must not be called after it.
+func (s *Webhook) Close() error {
+	s.closeOnce.Do(func() { close(s.events) })
+	<-s.done
+	return nil
+}
+
+func (s *Webhook) deliver() {
+	defer close(s.done)
+	for event := range s.events {
+		s.post(event)
+	}
+}
+
+func (s *Webhook) post(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		utils.GetLogger().Error(fmt.Sprintf("webhook for %s of song %d failed: %v", event.Type, event.SongID, err))
+		return
+	}
+
+	response, err := s.Client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		utils.GetLogger().Error(fmt.Sprintf("webhook for %s of song %d failed: %v", event.Type, event.SongID, err))
+		return
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 300 {
+		utils.GetLogger().Error(fmt.Sprintf("webhook for %s of song %d answered %s", event.Type, event.SongID, response.Status))
+	}
+}
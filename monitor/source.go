@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+Open opens a source of raw PCM: "-" for stdin, an http:// or https:// URL for a
+stream, anything else a file or named pipe. Streams are asked for ICY metadata,
+which is stripped from the audio; the station's current title is kept for events.
+Stations sending MP3 or AAC need a decoder in front, such as
+
+	ffmpeg -i http://station/stream -f s16le -ac 2 -ar 44100 - | shazoom monitor -
+*/
+func Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	if location == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.Open(location)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Icy-MetaData", "1")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("stream answered %s", response.Status)
+	}
+
+	metaint := response.Header.Get("icy-metaint")
+	if metaint == "" {
+		return response.Body, nil
+	}
+	interval, err := strconv.Atoi(metaint)
+	if err != nil || interval <= 0 {
+		response.Body.Close()
+		return nil, fmt.Errorf("bad icy-metaint %q", metaint)
+	}
+	return &icyReader{body: response.Body, interval: interval, untilMeta: interval}, nil
+}
+
+// titled is implemented by sources that know what the station says is playing.
+type titled interface {
+	StreamTitle() string
+}
+
+// streamTitle is a title a stream announced and the second of the stream it came with.
+type streamTitle struct {
+	from  float64
+	title string
+}
+
+// icyReader strips the metadata blocks an ICY server puts after every interval bytes
+// of audio: a length byte, times 16, of text like StreamTitle='...';
+type icyReader struct {
+	body      io.ReadCloser
+	interval  int
+	untilMeta int
+	title     string
+}
+
+func (r *icyReader) Read(p []byte) (int, error) {
+	if r.untilMeta == 0 {
+		if err := r.readMeta(); err != nil {
+			return 0, err
+		}
+		r.untilMeta = r.interval
+	}
+
+	n, err := r.body.Read(p[:min(len(p), r.untilMeta)])
+	r.untilMeta -= n
+	return n, err
+}
+
+func (r *icyReader) readMeta() error {
+	var length [1]byte
+	if _, err := io.ReadFull(r.body, length[:]); err != nil {
+		return err
+	}
+	if length[0] == 0 {
+		return nil
+	}
+
+	meta := make([]byte, 16*int(length[0]))
+	if _, err := io.ReadFull(r.body, meta); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	text := strings.TrimRight(string(meta), "\x00")
+	if start := strings.Index(text, "StreamTitle='"); start >= 0 {
+		text = text[start+len("StreamTitle='"):]
+		if end := strings.Index(text, "';"); end >= 0 {
+			r.title = text[:end]
+		}
+	}
+	return nil
+}
+
+func (r *icyReader) StreamTitle() string {
+	return r.title
+}
+
+func (r *icyReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,107 @@
+package monitor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+Station serves PCM over HTTP the way an ICY (SHOUTcast/Icecast) server does, to stand
+in for a real station in tests and local runs. Clients that send Icy-MetaData: 1 get
+Title in a metadata block every MetaInt bytes.
+*/
+type Station struct {
+	PCM   []byte
+	Title string
+	// MetaInt is the ICY metadata interval, 0 to never send metadata.
+	MetaInt int
+	// BytesPerSecond paces the stream like a live broadcast, 0 sends it as fast as the
+	// client reads.
+	BytesPerSecond int
+	// Loop repeats PCM forever instead of ending the response after it.
+	Loop bool
+}
+
+func (s *Station) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	metaint := 0
+	if r.Header.Get("Icy-MetaData") == "1" {
+		metaint = s.MetaInt
+	}
+
+	w.Header().Set("Content-Type", "audio/L16")
+	w.Header().Set("icy-name", "shazoom test station")
+	if metaint > 0 {
+		w.Header().Set("icy-metaint", strconv.Itoa(metaint))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	// a tenth of a second at a time when paced
+	chunk := 4096
+	var tick <-chan time.Time
+	if s.BytesPerSecond > 0 {
+		chunk = max(1, s.BytesPerSecond/10)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	untilMeta := metaint
+	sentTitle := false
+	offset := 0
+	for {
+		if offset == len(s.PCM) {
+			if !s.Loop || len(s.PCM) == 0 {
+				return
+			}
+			offset = 0
+		}
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		end := min(offset+chunk, len(s.PCM))
+		for offset < end {
+			n := end - offset
+			if metaint > 0 {
+				n = min(n, untilMeta)
+			}
+			if _, err := w.Write(s.PCM[offset : offset+n]); err != nil {
+				return
+			}
+			offset += n
+			untilMeta -= n
+
+			if metaint > 0 && untilMeta == 0 {
+				// the title goes out once, then empty blocks until it changes
+				if _, err := w.Write(metadataBlock(s.Title, sentTitle)); err != nil {
+					return
+				}
+				sentTitle = true
+				untilMeta = metaint
+			}
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+func metadataBlock(title string, sent bool) []byte {
+	if sent || title == "" {
+		return []byte{0}
+	}
+	text := fmt.Sprintf("StreamTitle='%s';", title)
+	// the length byte counts 16 byte units
+	text = text[:min(len(text), 255*16)]
+	length := (len(text) + 15) / 16
+	block := make([]byte, 1+16*length)
+	block[0] = byte(length)
+	copy(block[1:], text)
+	return block
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"shazoom/core"
+	"shazoom/db"
+)
+
+func init() {
+	commands["segments"] = command{summary: "list the songs in a long recording as a timeline or CUE sheet", run: runSegments}
+}
+
+func runSegments(args []string) error {
+	defaults := core.DefaultSegmentOptions()
+	flags := flag.NewFlagSet("segments", flag.ExitOnError)
+	format := flags.String("format", "json", "output format, json or cue")
+	window := flags.Float64("window", defaults.WindowSeconds, "seconds of audio recognised at a time")
+	hop := flags.Float64("hop", defaults.HopSeconds, "seconds between the starts of windows")
+	minScore := flags.Float64("min-score", defaults.MinScore, "score a window needs to count as a match")
+	minWindows := flags.Int("min-windows", defaults.MinWindows, "drop segments matched by fewer windows")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: shazoom segments [flags] <audio file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *format != "json" && *format != "cue" {
+		return fmt.Errorf("unknown format %q, want json or cue", *format)
+	}
+
+	opts := defaults
+	opts.WindowSeconds, opts.HopSeconds = *window, *hop
+	opts.MinScore, opts.MinWindows = *minScore, *minWindows
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	matcher := core.NewMatcher(dbClient)
+	segments, err := matcher.RecognizeSegmentsInFile(flags.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+
+	if *format == "cue" {
+		return core.WriteCueSheet(os.Stdout, segments, filepath.Base(flags.Arg(0)))
+	}
+	return core.WriteTimeline(os.Stdout, segments)
+}
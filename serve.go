@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"shazoom/api"
+	"shazoom/db"
+)
+
+func init() {
+	commands["serve"] = command{summary: "serve the HTTP API", run: runServe}
+}
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	flags.Parse(args)
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	fmt.Printf("listening on %s\n", *addr)
+	return http.ListenAndServe(*addr, api.NewServer(dbClient).Handler())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"shazoom/core"
+	"shazoom/db"
+	"sort"
+	"text/tabwriter"
+)
+
+func init() {
+	commands["stats"] = command{summary: "report the size and health of the index", run: runStats}
+}
+
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	top := flags.Int("top", 10, "how many of the most shared addresses to show")
+	minHashes := flags.Int("min-hashes", 100, "flag songs with fewer fingerprints than this")
+	minFraction := flags.Float64("min-fraction", 0.1, "flag songs with fewer fingerprints than this fraction of the median")
+	flags.Parse(args)
+	if *top < 0 {
+		return fmt.Errorf("-top must not be negative, got %d", *top)
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	stats, err := dbClient.Stats(*top)
+	if err != nil {
+		return err
+	}
+
+	return writeStatsReport(os.Stdout, dbClient, stats, *minHashes, *minFraction)
+}
+
+func writeStatsReport(w io.Writer, dbClient db.DBClient, stats db.StoreStats, minHashes int, minFraction float64) error {
+	fmt.Fprintf(w, "songs:         %d\n", stats.Songs)
+	fmt.Fprintf(w, "fingerprints:  %d (%d orphaned)\n", stats.Fingerprints, stats.OrphanedFingerprints)
+	fmt.Fprintf(w, "per song:      min %d, median %d, max %d\n", stats.MinPerSong, stats.MedianPerSong, stats.MaxPerSong)
+
+	tables := make([]string, 0, len(stats.SizeBytes))
+	for table := range stats.SizeBytes {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		fmt.Fprintf(w, "size of %-19s %s\n", table+":", formatBytes(stats.SizeBytes[table]))
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	if len(stats.TopAddresses) > 0 {
+		fmt.Fprintln(w, "\nmost shared addresses:")
+		fmt.Fprintln(table, "ADDRESS\tFIELDS\tFINGERPRINTS")
+		for _, address := range stats.TopAddresses {
+			fmt.Fprintf(table, "%#x\t%s\t%d\n", address.Address, core.DescribeAddress(address.Address), address.Count)
+		}
+		table.Flush()
+	}
+
+	sparse := stats.SparseSongs(minHashes, minFraction)
+	if len(sparse) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "\n%d songs with suspiciously few fingerprints (silent or broken files?):\n", len(sparse))
+	fmt.Fprintln(table, "ID\tTITLE\tARTIST\tDURATION\tFINGERPRINTS")
+	for _, count := range sparse {
+		song, _, err := dbClient.GetSongByID(count.SongID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%d\n", count.SongID, song.Title, song.Artist, formatDuration(song.Duration), count.Fingerprints)
+	}
+	return table.Flush()
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exp])
+}
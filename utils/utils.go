@@ -4,16 +4,8 @@ import (
 	"io"
 	"os"
 	"fmt"
-	"time"
-	"math/rand"
 )
 
-func GenerateUniqueID() uint32 {
-	//rand.seed(SEED) deprecated :(
-	rand := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return rand.Uint32();
-}
-
 func GetEnv(key string, fallback ...string) string {
 	if value, ok := os.LookupEnv(key); ok {
 		return value